@@ -3,14 +3,18 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/ovlad32/geq/dump"
+	"github.com/ovlad32/geq/sketch"
 	"github.com/pkg/errors"
 )
 
@@ -19,16 +23,226 @@ var columnToExtract = flag.String("ec", "", "")
 var extractCount = flag.Int("evc", 10, "")
 var efcs = flag.Int("efcs", 1, "")
 var efcp = flag.Int("efcp", 1, "")
+var extractCounts = flag.Bool("ecount", false, "")
+var extractTop = flag.Int("etop", 0, "")
+var extractMemory = flag.Int("emem", 512, "")
+var extractCombined = flag.Bool("ecombined", false, "")
+var extractSample = flag.Int("esample", 0, "")
+var extractSeed = flag.Uint64("eseed", 1, "")
+var extractSampleRows = flag.Bool("erows", false, "")
+var extractStrata = flag.String("estrata", "", "")
 
+var errEnoughValues = errors.New("enough values extracted")
+
+//extractColumnType is a column or a fusion sub-field of a column to extract
+type extractColumnType struct {
+	name           string
+	index          int
+	fusionPosition int
+	fusionSize     int
+}
+
+//parseExtractColumns reads a comma-separated list of columns, each may be followed by /position/size of a fusion sub-field.
+//Columns without them take fusionPosition and fusionSize
+func (t *TableMap) parseExtractColumns(list string, fusionPosition, fusionSize int) (result []*extractColumnType, err error) {
+	for _, item := range strings.Split(list, ",") {
+		parts := strings.Split(strings.TrimSpace(item), "/")
+		column := &extractColumnType{
+			fusionPosition: fusionPosition,
+			fusionSize:     fusionSize,
+		}
+		column.index, err = t.columnPosition(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		switch len(parts) {
+		case 1:
+		case 3:
+			column.fusionPosition, err = strconv.Atoi(parts[1])
+			if err == nil {
+				column.fusionSize, err = strconv.Atoi(parts[2])
+			}
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not read fusion position/size of %v", item)
+			}
+		default:
+			return nil, errors.Errorf("column %v is neither a name nor name/position/size", item)
+		}
+		if column.fusionSize < 1 || column.fusionPosition < 1 || column.fusionPosition > column.fusionSize {
+			return nil, errors.Errorf("fusion position %v is out of fusion size %v for %v", column.fusionPosition, column.fusionSize, item)
+		}
+		column.name = fmt.Sprintf("%v.%v.%v",
+			strings.TrimSpace(string(t.headers[column.index])),
+			column.fusionPosition,
+			column.fusionSize,
+		)
+		for _, other := range result {
+			if other.name == column.name {
+				return nil, errors.Errorf("column %v is listed twice", item)
+			}
+		}
+		result = append(result, column)
+	}
+	return
+}
+
+//title names the column in headers of outputs, a fusion sub-field is named by header/position/size
+func (c *extractColumnType) title(t *TableMap) string {
+	title := strings.TrimSpace(string(t.headers[c.index]))
+	if c.fusionSize > 1 {
+		title = fmt.Sprintf("%v/%v/%v", title, c.fusionPosition, c.fusionSize)
+	}
+	return title
+}
+
+//outputColumn types the column of an output by the column of tableColumns, fusion sub-fields are text
+func (c *extractColumnType) outputColumn(t *TableMap, tableColumns []*outputColumnType) *outputColumnType {
+	if c.fusionSize > 1 {
+		return textColumn(c.title(t))
+	}
+	return tableColumns[c.index]
+}
+
+func (c *extractColumnType) value(cellsBytes [][]byte, separator []byte) []byte {
+	if len(cellsBytes) <= c.index {
+		return nil
+	}
+	cellBytes := cellsBytes[c.index]
+	if c.fusionSize == 1 {
+		return cellBytes
+	}
+	fcellsBytes := bytes.Split(cellBytes, separator)
+	if len(fcellsBytes) < c.fusionPosition {
+		return nil
+	}
+	return fcellsBytes[c.fusionPosition-1]
+}
+
+//extractOutputType is a file values are extracted to
+type extractOutputType struct {
+	name    string
+	columns []*extractColumnType
+	valueOf func(cellsBytes [][]byte) []byte
+	//cellsOf returns values of columns apart, as they are written to Parquet outputs
+	cellsOf func(cellsBytes [][]byte) [][]byte
+	cache   map[string]bool
+	output  outputType
+}
+
+//outputColumns returns a Parquet column per column of the output, nil for text outputs
+func (o *extractOutputType) outputColumns(t *TableMap, tableColumns []*outputColumnType) (result []*outputColumnType) {
+	if tableColumns == nil {
+		return nil
+	}
+	for _, column := range o.columns {
+		result = append(result, column.outputColumn(t, tableColumns))
+	}
+	return
+}
+
+//valueColumn returns a Parquet column of joined values of the output, nil for text outputs.
+//It's typed if the output has a single column
+func (o *extractOutputType) valueColumn(t *TableMap, tableColumns []*outputColumnType) *outputColumnType {
+	if tableColumns == nil {
+		return nil
+	}
+	if len(o.columns) == 1 {
+		return o.columns[0].outputColumn(t, tableColumns)
+	}
+	return textColumn(o.name)
+}
+
+//extractOutputs makes an output per column, or a single output of column values joined by separator when combined.
+//Rows having no value of any column are not combined
+func extractOutputs(columns []*extractColumnType, fusionSeparator []byte, combined bool, separator byte) (result []*extractOutputType) {
+	if !combined {
+		for _, column := range columns {
+			column := column
+			cells := make([][]byte, 1)
+			result = append(result, &extractOutputType{
+				name:    column.name,
+				columns: []*extractColumnType{column},
+				valueOf: func(cellsBytes [][]byte) []byte {
+					return column.value(cellsBytes, fusionSeparator)
+				},
+				cellsOf: func(cellsBytes [][]byte) [][]byte {
+					cells[0] = column.value(cellsBytes, fusionSeparator)
+					return cells
+				},
+				cache: make(map[string]bool),
+			})
+		}
+		return
+	}
+	names := make([]string, 0, len(columns))
+	for _, column := range columns {
+		names = append(names, column.name)
+	}
+	var buffer []byte
+	cells := make([][]byte, len(columns))
+	return append(result, &extractOutputType{
+		name:    shortName(strings.Join(names, "+")),
+		columns: columns,
+		cellsOf: func(cellsBytes [][]byte) [][]byte {
+			for index, column := range columns {
+				cells[index] = column.value(cellsBytes, fusionSeparator)
+			}
+			return cells
+		},
+		valueOf: func(cellsBytes [][]byte) []byte {
+			buffer = buffer[:0]
+			empty := true
+			for index, column := range columns {
+				if index > 0 {
+					buffer = append(buffer, separator)
+				}
+				value := column.value(cellsBytes, fusionSeparator)
+				empty = empty && len(value) == 0
+				buffer = append(buffer, value...)
+			}
+			if empty {
+				return nil
+			}
+			return buffer
+		},
+		cache: make(map[string]bool),
+	})
+}
+
+//outputsName names a checkpoint of extracting to outputs
+func outputsName(outputs []*extractOutputType) string {
+	names := make([]string, 0, len(outputs))
+	for _, output := range outputs {
+		names = append(names, output.name)
+	}
+	return shortName(strings.Join(names, ","))
+}
+
+//maxNameLength keeps names of many columns within file name limits
+const maxNameLength = 100
+
+//shortName cuts a long name keeping it distinct with a hash
+func shortName(name string) string {
+	if len(name) <= maxNameLength {
+		return name
+	}
+	return fmt.Sprintf("%v.%v", name[:maxNameLength-9], shortHash(name))
+}
+
+//Extract writes distinct values of columns listed by -ec in a single scan, to a file per column or, with -ecombined,
+//to a file of projected rows
 func Extract() {
 	var table *TableMap = nil
 
 	if *tableToExtract == "" {
 		panic("specify table name to extract data")
 	}
-	if *columnToExtract == "" {
+	if *columnToExtract == "" && !*extractSampleRows {
 		panic("specify column name to extract data")
 	}
+	if *extractSampleRows && *extractSample <= 0 {
+		panic("specify sample size of rows with -esample")
+	}
 
 	conf, err := readConfig()
 	if err != nil {
@@ -48,24 +262,36 @@ func Extract() {
 
 	table.readHeader([]byte(conf.HeaderColumnSeparatorChar))
 
-	colpos := -1
-	for index, hb := range table.headers {
-		if strings.ToLower(strings.TrimSpace(string(hb))) ==
-			strings.ToLower(strings.TrimSpace(*columnToExtract)) {
-			colpos = index
+	var columns []*extractColumnType
+	if !*extractSampleRows {
+		columns, err = table.parseExtractColumns(*columnToExtract, *efcp, *efcs)
+		if err != nil {
+			panic(err)
+		}
+	}
+	var strata *extractColumnType
+	if *extractStrata != "" {
+		if *extractSample <= 0 {
+			panic("stratification applies to samples taken with -esample")
+		}
+		list, err := table.parseExtractColumns(*extractStrata, 1, 1)
+		if err != nil {
+			panic(err)
 		}
+		if len(list) != 1 {
+			panic("specify a single column to stratify samples")
+		}
+		strata = list[0]
 	}
-	if colpos == -1 {
-		panic(fmt.Sprintf("column name %v not found in %v ", *columnToExtract, table.TableName))
+	if *extractTop < 0 {
+		panic("number of top values must not be negative")
 	}
+	outputs := extractOutputs(columns, []byte(conf.FusionSeparatorChar), *extractCombined, byte(conf.ResultColumnSeparatorByte))
 
-	dcs := byte(conf.DataColumnSeparatorByte)
-
-	dc := &dump.DumperConfigType{
-		ColumnSeparator: dcs,
-		LineSeparator:   dump.LineFeedByte,
-		GZip:            true,
-		BufferSize:      4096,
+	dc, err := conf.dumperConfig(table)
+	if err != nil {
+		err = errors.Wrapf(err, "could not read dump config")
+		panic(err)
 	}
 
 	dmp, err := dump.NewDumper(dc)
@@ -73,8 +299,70 @@ func Extract() {
 		err = errors.Wrapf(err, "could not create dumper")
 		panic(err)
 	}
-	var cache map[string]bool = make(map[string]bool)
+	tableColumns, err := table.outputColumns(dmp)
+	if err != nil {
+		panic(err)
+	}
+	if *extractSample > 0 {
+		extractSampled(conf, table, dmp, outputs, strata, tableColumns)
+		return
+	}
+	if *extractCounts || *extractTop > 0 {
+		extractCounted(conf, table, dmp, outputs, tableColumns)
+		return
+	}
+
+	type extractState struct {
+		outputStateType
+		Values []string `json:"values"`
+	}
+	states := make(map[string]*extractState)
+	cp, err := newCheckpoint("e."+outputsName(outputs)+outputExtension(), table)
+	if err != nil {
+		panic(err)
+	}
+	err = cp.restoreState(&states)
+	if err != nil {
+		panic(err)
+	}
+	//pending are outputs having less than -evc values
+	pending := 0
+	for _, output := range outputs {
+		state, found := states[output.name]
+		if !found {
+			state = &extractState{}
+			states[output.name] = state
+		}
+		for _, value := range state.Values {
+			output.cache[value] = true
+		}
+		if *extractCount <= 0 || len(output.cache) < *extractCount {
+			pending++
+		}
+	}
+	if pending == 0 {
+		log.Printf("%v value(s) have already been extracted", *extractCount)
+		return
+	}
+	cp.flush = func() (interface{}, error) {
+		for _, output := range outputs {
+			state := states[output.name]
+			if output.output != nil {
+				outputState, err := output.output.flush()
+				if err != nil {
+					return nil, err
+				}
+				state.outputStateType = *outputState
+			}
+			state.Values = state.Values[:0]
+			for value := range output.cache {
+				state.Values = append(state.Values, value)
+			}
+		}
+		return states, nil
+	}
 
+	var line []byte
 	var proc4Extract dump.RowProcessingFuncType = func(
 		cancelContext context.Context,
 		config *dump.DumperConfigType,
@@ -83,86 +371,417 @@ func Extract() {
 		cellsBytes [][]byte,
 		rawLineBytes []byte,
 	) (err error) {
-		if len(cellsBytes) < colpos {
-			return
+		for _, output := range outputs {
+			if *extractCount > 0 && len(output.cache) >= *extractCount {
+				continue
+			}
+			value := output.valueOf(cellsBytes)
+			if len(value) == 0 {
+				continue
+			}
+			if *extractCount > 0 {
+				sref := string(value)
+				if _, found := output.cache[sref]; found {
+					continue
+				} else {
+					output.cache[sref] = true
+				}
+			}
+			if output.output == nil {
+				if *pfout == "" {
+					output.output = newTextOutput(os.Stdout)
+				} else {
+					err = os.MkdirAll(*pfout, 0777)
+					if err != nil {
+						panic(err)
+					}
+					s := path.Join(*pfout, table.TableName+"."+output.name+outputExtension())
+					output.output, err = newOutput(s, output.outputColumns(table, tableColumns), &states[output.name].outputStateType)
+					if err != nil {
+						panic(err)
+					}
+				}
+			}
+			line = append(append(line[:0], value...), '\n')
+			var cells [][]byte
+			if tableColumns != nil {
+				cells = output.cellsOf(cellsBytes)
+			}
+			err = output.output.write(cells, line)
+			if err != nil {
+				panic(err)
+			}
+			if *extractCount > 0 && len(output.cache) == *extractCount {
+				pending--
+			}
 		}
-		cellBytes := cellsBytes[colpos]
-		if len(cellBytes) == 0 {
-			return
+		if pending == 0 {
+			err = errEnoughValues
 		}
+		return
+	}
 
-		strippedCellBytes := cellBytes[1 : len(cellBytes)-1]
-		var ref *[]byte = nil
-		if *efcs == 1 {
-			ref = &strippedCellBytes
-		} else {
-			fcellsBytes := bytes.Split(strippedCellBytes, []byte(conf.FusionSeparatorChar))
-			if len(fcellsBytes) < *efcp {
-				return
+	err = table.readData(dmp, true, cp, func(string) dump.RowProcessingFuncType {
+		return proc4Extract
+	}, nil)
+	if err != nil && errors.Cause(err) != errEnoughValues {
+		panic(err)
+	}
+	for _, output := range outputs {
+		if output.output != nil {
+			err = output.output.Close()
+			if err != nil {
+				panic(err)
 			}
-			ref = &fcellsBytes[*efcp-1]
 		}
+	}
 
-		if ref == nil || len(*ref) == 0 {
-			return
+}
+
+//extractCounted counts occurrences of every distinct value, or of the most frequent ones with -etop,
+//and writes values along with their counts, the most frequent first. -evc does not apply.
+//Exact counts exceeding -emem megabytes, shared by outputs, are spilled to disk, top values are approximated within a bounded memory
+func extractCounted(conf *TableMaps, table *TableMap, dmp *dump.DumperType, outputs []*extractOutputType, tableColumns []*outputColumnType) {
+	mode := "counts"
+	if *extractTop > 0 {
+		mode = fmt.Sprintf("top%v", *extractTop)
+	}
+	type countedState struct {
+		Runs []string             `json:"runs,omitempty"`
+		Top  []sketch.TopItemType `json:"top,omitempty"`
+	}
+	states := make(map[string]*countedState)
+	cp, err := newCheckpoint(fmt.Sprintf("e.%v.%v", outputsName(outputs), mode), table)
+	if err != nil {
+		panic(err)
+	}
+	err = cp.restoreState(&states)
+	if err != nil {
+		panic(err)
+	}
+	pathToOutput := func(output *extractOutputType) string {
+		return path.Join(*pfout, fmt.Sprintf("%v.%v.%v", table.TableName, output.name, mode))
+	}
+
+	counts := make([]*valueCountsType, len(outputs))
+	tops := make([]*sketch.TopKType, len(outputs))
+	for index, output := range outputs {
+		state, found := states[output.name]
+		if !found {
+			state = &countedState{}
+			states[output.name] = state
 		}
-		if (*extractCount) > 0 {
-			sref := string(*ref)
-			if _, found := cache[sref]; found {
-				return
-			} else {
-				cache[sref] = true
+		if *extractTop > 0 {
+			topCapacity := *extractTop * topCapacityFactor
+			if topCapacity < minTopCapacity {
+				topCapacity = minTopCapacity
+			}
+			tops[index] = sketch.NewTopK(topCapacity)
+			tops[index].Restore(state.Top)
+		} else {
+			counts[index], err = newValueCounts(pathToOutput(output)+".spill", (*extractMemory<<20)/len(outputs), state.Runs)
+			if err != nil {
+				panic(err)
 			}
 		}
-		if table.writer == nil {
-			if *pfout == "" {
-				table.writer = os.Stdout
-			} else {
-				err = os.MkdirAll(*pfout, 0777)
-				if err != nil {
-					panic(err)
-				}
-				s := path.Join(*pfout, fmt.Sprintf("%v.%v.%v.%v",
-					table.TableName,
-					strings.TrimSpace(string(table.headers[colpos])),
-					*efcp,
-					*efcs,
-				))
-				table.writer, err = os.Create(s)
-				if err != nil {
-					panic(err)
-				}
+	}
+	//counts in memory are spilled to make them durable along with the checkpoint
+	cp.flush = func() (interface{}, error) {
+		for index, output := range outputs {
+			state := states[output.name]
+			if tops[index] != nil {
+				state.Top = tops[index].Top(0)
+				continue
+			}
+			err := counts[index].spill()
+			if err != nil {
+				return nil, err
+			}
+			state.Runs = counts[index].files()
+		}
+		return states, nil
+	}
+
+	var proc4Count dump.RowProcessingFuncType = func(
+		cancelContext context.Context,
+		config *dump.DumperConfigType,
+		currentLineNumber uint64,
+		currentStreamPosition uint64,
+		cellsBytes [][]byte,
+		rawLineBytes []byte,
+	) (err error) {
+		for index, output := range outputs {
+			value := output.valueOf(cellsBytes)
+			if len(value) == 0 {
+				continue
+			}
+			if tops[index] != nil {
+				tops[index].Add(value)
+				continue
+			}
+			err = counts[index].add(value)
+			if err != nil {
+				return
 			}
 		}
-		_, err = table.writer.Write(*ref)
+		return
+	}
 
+	err = table.readData(dmp, true, cp, func(string) dump.RowProcessingFuncType {
+		return proc4Count
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	for index, output := range outputs {
+		var columns []*outputColumnType
+		if tableColumns != nil {
+			columns = []*outputColumnType{
+				output.valueColumn(table, tableColumns),
+				{name: "count", columnType: &pgColumnType{name: "integer"}},
+			}
+		}
+		s := pathToOutput(output) + outputExtension()
+		written, err := writeCounted(s, columns, byte(conf.ResultColumnSeparatorByte), counts[index], tops[index])
 		if err != nil {
 			panic(err)
 		}
-		_, err = table.writer.Write([]byte("\n"))
-		if (*extractCount) > 0 && len(cache) == *extractCount {
-			table.writer.Close()
-			os.Exit(0)
+		log.Printf("%v value(s) with counts written to %v", written, s)
+	}
+}
+
+//writeCounted writes values along with their counts either of counts or of top, spilled counts are removed
+func writeCounted(pathToOutput string, columns []*outputColumnType, separator byte, counts *valueCountsType, top *sketch.TopKType) (written int, err error) {
+	output, err := newOutput(pathToOutput, columns, nil)
+	if err != nil {
+		return
+	}
+	var line []byte
+	cells := make([][]byte, 2)
+	write := func(item sketch.TopItemType) error {
+		line = append(append(line[:0], item.Value...), separator)
+		line = strconv.AppendUint(line, item.Count, 10)
+		cells[0], cells[1] = []byte(item.Value), line[len(item.Value)+1:]
+		line = append(line, '\n')
+		written++
+		return output.write(cells, line)
+	}
+	if top != nil {
+		for _, item := range top.Top(*extractTop) {
+			if item.Error > 0 {
+				log.Printf("count %v of %v may be overestimated by up to %v", item.Count, item.Value, item.Error)
+			}
+			err = write(item)
+			if err != nil {
+				break
+			}
 		}
+	} else {
+		err = counts.ordered(write)
+	}
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "could not write %v", pathToOutput)
 		return
 	}
+	if counts != nil {
+		err = counts.remove()
+	}
+	return
+}
 
-	for _, filePath := range allFiles(table.PathToData, ".gz") {
+//extractSampled writes a uniform sample of -esample distinct values of every output, or of rows with -erows.
+//Values and rows are chosen by their hashes seeded with -eseed, so a sample is spread over all the files,
+//is reproducible and does not depend on the order files are read in.
+//With -estrata a sample is taken for every value of the column given, which precedes sampled values in the output
+func extractSampled(conf *TableMaps, table *TableMap, dmp *dump.DumperType, outputs []*extractOutputType, strata *extractColumnType, tableColumns []*outputColumnType) {
+	mode := fmt.Sprintf("sample%v", *extractSample)
+	if strata != nil {
+		mode += ".by." + strata.name
+	}
+	names := []string{"rows"}
+	if *extractSampleRows && tableColumns != nil {
+		panic("sampled rows are written as text only")
+	}
+	if !*extractSampleRows {
+		names = names[:0]
+		for _, output := range outputs {
+			names = append(names, output.name)
+		}
+	}
 
-		log.Printf("%v...", filePath)
-		_, err = dmp.ReadFromFile(
-			context.Background(),
-			filePath,
-			proc4Extract,
-		)
+	type sampledState struct {
+		Seed uint64 `json:"seed"`
+		//Samples are items by stratum by output name
+		Samples map[string]map[string][]sketch.SampleItemType `json:"samples"`
+	}
+	state := &sampledState{Seed: *extractSeed}
+	cp, err := newCheckpoint(fmt.Sprintf("e.%v.%v", shortName(strings.Join(names, ",")), mode), table)
+	if err != nil {
+		panic(err)
+	}
+	err = cp.restoreState(state)
+	if err != nil {
+		panic(err)
+	}
+	if state.Seed != *extractSeed {
+		panic(fmt.Sprintf("checkpoint has been made with -eseed=%v", state.Seed))
+	}
 
+	samples := make([]map[string]*sketch.SampleType, len(names))
+	for index, name := range names {
+		samples[index] = make(map[string]*sketch.SampleType)
+		for stratum, items := range state.Samples[name] {
+			samples[index][stratum] = sketch.NewSample(*extractSample)
+			samples[index][stratum].Restore(items)
+		}
+	}
+	sample := func(index int, stratum string) *sketch.SampleType {
+		result, found := samples[index][stratum]
+		if !found {
+			result = sketch.NewSample(*extractSample)
+			samples[index][stratum] = result
+		}
+		return result
+	}
+	cp.flush = func() (interface{}, error) {
+		state.Samples = make(map[string]map[string][]sketch.SampleItemType)
+		for index, name := range names {
+			state.Samples[name] = make(map[string][]sketch.SampleItemType)
+			for stratum, sample := range samples[index] {
+				state.Samples[name][stratum] = sample.Items()
+			}
+		}
+		return state, nil
+	}
+
+	fusionSeparator := []byte(conf.FusionSeparatorChar)
+	newProcessor := func(pathToFile string) dump.RowProcessingFuncType {
+		//rows are identified by the file path within path_to_data and the line number, not by the order they are read in.
+		//Files of the same name in different directories are different sources
+		source := table.dataFileName(pathToFile)
+		fileSeed := sketch.Hash64Seed(*extractSeed, []byte(source))
+		lineBytes := make([]byte, 8)
+		return func(
+			cancelContext context.Context,
+			config *dump.DumperConfigType,
+			currentLineNumber uint64,
+			currentStreamPosition uint64,
+			cellsBytes [][]byte,
+			rawLineBytes []byte,
+		) (err error) {
+			stratum := ""
+			if strata != nil {
+				stratum = string(strata.value(cellsBytes, fusionSeparator))
+			}
+			if *extractSampleRows {
+				binary.LittleEndian.PutUint64(lineBytes, currentLineNumber)
+				sample(0, stratum).Add(sketch.SampleItemType{
+					Key:    sketch.Hash64Seed(fileSeed, lineBytes),
+					Data:   rawLineBytes,
+					Source: source,
+					Line:   currentLineNumber,
+				})
+				return
+			}
+			for index, output := range outputs {
+				value := output.valueOf(cellsBytes)
+				if len(value) == 0 {
+					continue
+				}
+				sample(index, stratum).Add(sketch.SampleItemType{
+					Key:  sketch.Hash64Seed(*extractSeed, value),
+					Data: value,
+				})
+			}
+			return
+		}
+	}
+
+	err = table.readData(dmp, true, cp, newProcessor, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	err = os.MkdirAll(*pfout, 0777)
+	if err != nil {
+		panic(err)
+	}
+	for index, name := range names {
+		var columns []*outputColumnType
+		if tableColumns != nil {
+			if strata != nil {
+				columns = append(columns, strata.outputColumn(table, tableColumns))
+			}
+			columns = append(columns, outputs[index].valueColumn(table, tableColumns))
+		}
+		pathToOutput := path.Join(*pfout, fmt.Sprintf("%v.%v.%v%v", table.TableName, name, mode, outputExtension()))
+		written, err := writeSampled(pathToOutput, columns, byte(conf.ResultColumnSeparatorByte), samples[index], *extractSampleRows, strata != nil)
 		if err != nil {
-			err = errors.Wrapf(err, "could not read %v", filePath)
 			panic(err)
 		}
+		log.Printf("%v sampled item(s) written to %v", written, pathToOutput)
 	}
-	if table.writer != nil {
-		table.writer.Close()
-	}
+}
 
+//writeSampled writes samples in the order of strata. Rows are written as they are in the order of files and lines,
+//values are sorted and preceded by the stratum if stratified
+func writeSampled(pathToOutput string, columns []*outputColumnType, separator byte, samples map[string]*sketch.SampleType, rows, stratified bool) (written int, err error) {
+	output, err := newOutput(pathToOutput, columns, nil)
+	if err != nil {
+		return
+	}
+	var line []byte
+	var cells [][]byte
+	strata := make([]string, 0, len(samples))
+	for stratum := range samples {
+		strata = append(strata, stratum)
+	}
+	sort.Strings(strata)
+	for _, stratum := range strata {
+		items := samples[stratum].Items()
+		sort.Slice(items, func(i, j int) bool {
+			if items[i].Source != items[j].Source {
+				return items[i].Source < items[j].Source
+			}
+			if items[i].Line != items[j].Line {
+				return items[i].Line < items[j].Line
+			}
+			return bytes.Compare(items[i].Data, items[j].Data) < 0
+		})
+		for _, item := range items {
+			line, cells = line[:0], cells[:0]
+			if rows {
+				line = append(line, item.Data...)
+				if !bytes.HasSuffix(item.Data, []byte("\n")) {
+					line = append(line, '\n')
+				}
+			} else {
+				if stratified {
+					line = append(append(line, stratum...), separator)
+					cells = append(cells, []byte(stratum))
+				}
+				line = append(append(line, item.Data...), '\n')
+				cells = append(cells, item.Data)
+			}
+			err = output.write(cells, line)
+			if err != nil {
+				break
+			}
+			written++
+		}
+		if err != nil {
+			break
+		}
+	}
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "could not write %v", pathToOutput)
+	}
+	return
 }
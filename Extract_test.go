@@ -0,0 +1,213 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ovlad32/geq/dump"
+)
+
+func extractTestTable() *TableMap {
+	return &TableMap{TableName: "t", headers: [][]byte{[]byte("id"), []byte(" code "), []byte("name")}}
+}
+
+func TestParseExtractColumns(t *testing.T) {
+	table := extractTestTable()
+	columns, err := table.parseExtractColumns("ID, code/2/3 ,name,code", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*extractColumnType{
+		{name: "id.1.1", index: 0, fusionPosition: 1, fusionSize: 1},
+		{name: "code.2.3", index: 1, fusionPosition: 2, fusionSize: 3},
+		{name: "name.1.1", index: 2, fusionPosition: 1, fusionSize: 1},
+		{name: "code.1.1", index: 1, fusionPosition: 1, fusionSize: 1},
+	}
+	if !reflect.DeepEqual(columns, expected) {
+		for _, column := range columns {
+			t.Errorf("%+v", *column)
+		}
+	}
+	var titles []string
+	for _, column := range columns {
+		titles = append(titles, column.title(table))
+	}
+	if !reflect.DeepEqual(titles, []string{"id", "code/2/3", "name", "code"}) {
+		t.Errorf("titles %v", titles)
+	}
+
+	//-efcp and -efcs apply to columns listed without a position and a size
+	columns, err = table.parseExtractColumns("code,name/1/1", 2, 2)
+	if err != nil || columns[0].name != "code.2.2" || columns[1].name != "name.1.1" {
+		t.Errorf("columns %v: %v", columns, err)
+	}
+
+	for _, list := range []string{"price", "code/2", "code/a/3", "code/4/3", "code/0/3", "code/1/0", "id,ID", "code/1/2,code/1/2", ""} {
+		if _, err = table.parseExtractColumns(list, 1, 1); err == nil {
+			t.Errorf("columns %q are parsed", list)
+		}
+	}
+}
+
+func TestExtractColumnValue(t *testing.T) {
+	table := extractTestTable()
+	columns, err := table.parseExtractColumns("id,code/2/3,code/3/3", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		cells  []string
+		values []string
+	}{
+		{[]string{"1", "a|b|c"}, []string{"1", "b", "c"}},
+		{[]string{"2", "a|b"}, []string{"2", "b", ""}},
+		{[]string{"3", ""}, []string{"3", "", ""}},
+		{[]string{"4"}, []string{"4", "", ""}},
+	}
+	for _, test := range tests {
+		cells := make([][]byte, len(test.cells))
+		for index, cell := range test.cells {
+			cells[index] = []byte(cell)
+		}
+		var values []string
+		for _, column := range columns {
+			values = append(values, string(column.value(cells, []byte("|"))))
+		}
+		if !reflect.DeepEqual(values, test.values) {
+			t.Errorf("values %q of %q", values, test.cells)
+		}
+	}
+}
+
+func TestExtractOutputs(t *testing.T) {
+	table := extractTestTable()
+	columns, err := table.parseExtractColumns("id,code/2/3", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := [][][]byte{
+		{[]byte("1"), []byte("a|b|c")},
+		{[]byte("2"), []byte("a")},
+		{[]byte(""), []byte("a")},
+		{},
+	}
+
+	outputs := extractOutputs(columns, []byte("|"), false, '\t')
+	if len(outputs) != 2 || outputs[0].name != "id.1.1" || outputs[1].name != "code.2.3" || outputsName(outputs) != "id.1.1,code.2.3" {
+		t.Fatalf("outputs %v", outputsName(outputs))
+	}
+	for index, expected := range [][]string{{"1", "2", "", ""}, {"b", "", "", ""}} {
+		for row, cells := range rows {
+			if value := string(outputs[index].valueOf(cells)); value != expected[row] {
+				t.Errorf("%v of row %v is %q", outputs[index].name, row, value)
+			}
+			if cells := outputs[index].cellsOf(cells); len(cells) != 1 || string(cells[0]) != expected[row] {
+				t.Errorf("%v cells of row %v are %q", outputs[index].name, row, cells)
+			}
+		}
+	}
+
+	outputs = extractOutputs(columns, []byte("|"), true, '\t')
+	if len(outputs) != 1 || outputs[0].name != "id.1.1+code.2.3" || len(outputs[0].columns) != 2 {
+		t.Fatalf("combined outputs %v", outputsName(outputs))
+	}
+	//rows having no value of any column are not combined
+	for row, expected := range []string{"1\tb", "2\t", "", ""} {
+		value := outputs[0].valueOf(rows[row])
+		if string(value) != expected || expected == "" && value != nil {
+			t.Errorf("combined value of row %v is %q", row, value)
+		}
+	}
+	if cells := outputs[0].cellsOf(rows[0]); len(cells) != 2 || string(cells[0]) != "1" || string(cells[1]) != "b" {
+		t.Errorf("combined cells %q", cells)
+	}
+
+	tableColumns := []*outputColumnType{textColumn("id"), textColumn("code"), textColumn("name")}
+	if parquetColumns := outputs[0].outputColumns(table, tableColumns); len(parquetColumns) != 2 ||
+		parquetColumns[0] != tableColumns[0] || parquetColumns[1].name != "code/2/3" {
+		t.Errorf("output columns %v", parquetColumns)
+	}
+	if outputs[0].outputColumns(table, nil) != nil || outputs[0].valueColumn(table, nil) != nil {
+		t.Errorf("text output has columns")
+	}
+}
+
+func TestShortName(t *testing.T) {
+	name := strings.Repeat("column,", 20)
+	short := shortName(name)
+	if len(short) != maxNameLength || !strings.HasPrefix(short, name[:maxNameLength-9]) || short == shortName(name+"x") {
+		t.Errorf("%v is shortened to %v", name, short)
+	}
+	if shortName("id.1.1") != "id.1.1" {
+		t.Errorf("short name is changed")
+	}
+}
+
+func TestExtractSampled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output, size, seed, rows, workerCount := *pfout, *extractSample, *extractSeed, *extractSampleRows, *workers
+	defer func() {
+		*pfout, *extractSample, *extractSeed, *extractSampleRows, *workers = output, size, seed, rows, workerCount
+	}()
+	*pfout, *extractSample, *extractSeed, *workers = path.Join(dir, "out"), 10, 7, 1
+
+	table := &TableMap{
+		TableName:          "t",
+		PathToData:         path.Join(dir, "data"),
+		DataFileExtensions: []string{".txt"},
+		headers:            [][]byte{[]byte("id"), []byte("name")},
+	}
+	//files of the same name are sampled as different sources
+	for _, subdir := range []string{"a", "b"} {
+		pathToFile := path.Join(table.PathToData, subdir, "part.txt")
+		err = os.MkdirAll(path.Dir(pathToFile), 0777)
+		if err == nil {
+			err = ioutil.WriteFile(pathToFile, []byte("1,x\n2,y\n"), 0666)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf := &TableMaps{DataColumnSeparatorByte: ',', ResultColumnSeparatorByte: '\t'}
+	dc, err := conf.dumperConfig(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dmp, err := dump.NewDumper(dc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	*extractSampleRows = true
+	extractSampled(conf, table, dmp, nil, nil, nil)
+	data, err := ioutil.ReadFile(path.Join(*pfout, "t.rows.sample10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "1,x\n2,y\n1,x\n2,y\n" {
+		t.Errorf("rows %q sampled", data)
+	}
+
+	//values are sampled once per stratum
+	*extractSampleRows = false
+	columns, err := table.parseExtractColumns("id,name", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extractSampled(conf, table, dmp, extractOutputs(columns[:1], nil, false, '\t'), columns[1], nil)
+	data, err = ioutil.ReadFile(path.Join(*pfout, "t.id.1.1.sample10.by.name.1.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "x\t1\ny\t2\n" {
+		t.Errorf("values %q sampled", data)
+	}
+}
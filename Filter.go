@@ -1,15 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"log"
 	"os"
 	"path"
+	"regexp"
 	"strings"
 
 	"github.com/ovlad32/geq/dump"
+	"github.com/ovlad32/geq/expr"
 	"github.com/pkg/errors"
 )
 
@@ -18,6 +22,11 @@ var columnToFilter = flag.String("fc", "", "")
 var valueToFilter = flag.String("fv", "", "")
 var valueToEmptyEmpty = flag.Bool("fvempty", false, "")
 var filterOperator = flag.String("fo", "e", "")
+var filterExpression = flag.String("fx", "", "")
+var valueFileToFilter = flag.String("fvfile", "", "")
+var valueFileSplit = flag.Bool("fvsplit", false, "")
+var ffcs = flag.Int("ffcs", 1, "")
+var ffcp = flag.Int("ffcp", 1, "")
 
 func Filter() {
 
@@ -26,14 +35,16 @@ func Filter() {
 	if *tableToFilter == "" {
 		panic("specify table name to filter data")
 	}
-	if *columnToFilter == "" {
-		panic("specify column name to filter data")
-	}
-	if *filterOperator == "" {
-		panic("specify filter operator: e=equal,p=prefix,s=suffix,i=inclusion")
-	}
-	if !*valueToEmptyEmpty && *columnToFilter == "" {
-		panic("specify value to filter data")
+	if *filterExpression == "" {
+		if *columnToFilter == "" {
+			panic("specify column name or expression to filter data")
+		}
+		if *filterOperator == "" {
+			panic("specify filter operator: e=equal,p=prefix,s=suffix,i=inclusion,r=regular expression")
+		}
+		if !*valueToEmptyEmpty && *valueToFilter == "" && *valueFileToFilter == "" {
+			panic("specify value or value file to filter data")
+		}
 	}
 
 	conf, err := readConfig()
@@ -54,24 +65,16 @@ func Filter() {
 
 	table.readHeader([]byte(conf.HeaderColumnSeparatorChar))
 
-	colpos := -1
-	for index, hb := range table.headers {
-		if strings.ToLower(strings.TrimSpace(string(hb))) ==
-			strings.ToLower(strings.TrimSpace(*columnToFilter)) {
-			colpos = index
-		}
-	}
-	if colpos == -1 {
-		panic(fmt.Sprintf("column name %v not found in %v ", *columnToFilter, table.TableName))
+	filter, err := newRowFilter(conf, table)
+	if err != nil {
+		panic(err)
 	}
+	outputPath := path.Join(*pfout, fmt.Sprintf("%v.%v", table.TableName, filter.outputName))
 
-	dcs := byte(conf.DataColumnSeparatorByte)
-
-	dc := &dump.DumperConfigType{
-		ColumnSeparator: dcs,
-		LineSeparator:   dump.LineFeedByte,
-		GZip:            true,
-		BufferSize:      4096,
+	dc, err := conf.dumperConfig(table)
+	if err != nil {
+		err = errors.Wrapf(err, "could not read dump config")
+		panic(err)
 	}
 
 	dmp, err := dump.NewDumper(dc)
@@ -80,6 +83,58 @@ func Filter() {
 		panic(err)
 	}
 
+	columns, err := table.outputColumns(dmp)
+	if err != nil {
+		panic(err)
+	}
+
+	type filterState struct {
+		outputStateType
+		//Outputs are sizes of per-value outputs
+		Outputs map[string]int64 `json:"outputs,omitempty"`
+		//Hits are numbers of rows found by value of a value file
+		Hits map[string]uint64 `json:"hits,omitempty"`
+	}
+	state := &filterState{}
+	cp, err := newCheckpoint("f."+filter.outputName+outputExtension(), table)
+	if err != nil {
+		panic(err)
+	}
+	err = cp.restoreState(state)
+	if err != nil {
+		panic(err)
+	}
+	var valueOutputs *valueOutputsType
+	if filter.valueSet != nil {
+		filter.valueSet.restoreCounts(state.Hits)
+		if *valueFileSplit {
+			if parquetOutput() {
+				panic("rows split by values are written as text only")
+			}
+			valueOutputs, err = newValueOutputs(outputPath, filter.valueSet.values, state.Outputs)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+	var output outputType
+	cp.flush = func() (interface{}, error) {
+		if output != nil {
+			outputState, err := output.flush()
+			if err != nil {
+				return nil, err
+			}
+			state.outputStateType = *outputState
+		}
+		if filter.valueSet != nil {
+			state.Hits = filter.valueSet.counts()
+		}
+		if valueOutputs != nil {
+			state.Outputs = valueOutputs.sizes
+		}
+		return state, nil
+	}
+
 	var proc4Filter dump.RowProcessingFuncType = func(
 		cancelContext context.Context,
 		config *dump.DumperConfigType,
@@ -88,69 +143,33 @@ func Filter() {
 		cellsBytes [][]byte,
 		rawLineBytes []byte,
 	) (err error) {
-		if len(cellsBytes) < colpos {
+		if !filter.matches(cellsBytes) {
 			return
 		}
-		cellBytes := cellsBytes[colpos]
-		if len(cellBytes) == 0 {
-			return
-		}
-		strippedCellBytes := cellBytes[1 : len(cellBytes)-1]
-		var ref *[]byte = &strippedCellBytes
-		/*if *efcs == 1 {
-			ref = &strippedCellBytes
-		} else {
-			fcellsBytes := bytes.Split(strippedCellBytes, []byte(conf.FusionSeparatorChar))
-			if len(fcellsBytes) < *efcp {
-				return
+		if valueOutputs != nil {
+			cellBytes, _ := filter.valueOf(cellsBytes)
+			err = valueOutputs.write(filter.valueSet.value(cellBytes), rawLineBytes)
+			if err != nil {
+				panic(err)
 			}
-			ref = &fcellsBytes[*efcp-1]
-		}*/
-
-		if (ref == nil || len(*ref) == 0) && !*valueToEmptyEmpty {
 			return
 		}
-		sref := string(*ref)
-		switch *filterOperator {
-		case "e":
-			if sref != *valueToFilter {
-				return
-			}
-		case "p":
-			if !strings.HasPrefix(sref, *valueToFilter) {
-				return
-			}
-		case "s":
-			if !strings.HasSuffix(sref, *valueToFilter) {
-				return
-			}
-		case "i":
-			if !strings.Contains(sref, *valueToFilter) {
-				return
-			}
-		default:
-			panic(fmt.Sprintf("filter operator value, -fo option, (%v) is not recognized", *filterOperator))
-		}
 
-		if table.writer == nil {
+		if output == nil {
 			if *pfout == "" {
-				table.writer = os.Stdout
+				output = newTextOutput(os.Stdout)
 			} else {
 				err = os.MkdirAll(*pfout, 0777)
 				if err != nil {
 					panic(err)
 				}
-				s := path.Join(*pfout, fmt.Sprintf("%v.%v",
-					table.TableName,
-					*valueToFilter,
-				))
-				table.writer, err = os.Create(s)
+				output, err = newOutput(outputPath+outputExtension(), columns, &state.outputStateType)
 				if err != nil {
 					panic(err)
 				}
 			}
 		}
-		_, err = table.writer.Write(rawLineBytes)
+		err = output.write(cellsBytes, rawLineBytes)
 
 		if err != nil {
 			panic(err)
@@ -159,22 +178,232 @@ func Filter() {
 		return
 	}
 
-	for _, filePath := range allFiles(table.PathToData, ".gz") {
+	err = table.readData(dmp, true, cp, func(string) dump.RowProcessingFuncType {
+		return proc4Filter
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+	if output != nil {
+		err = output.Close()
+		if err != nil {
+			panic(err)
+		}
+	}
+	if valueOutputs != nil {
+		err = valueOutputs.Close()
+		if err != nil {
+			panic(err)
+		}
+	}
+	if filter.valueSet != nil {
+		unseenPath := outputPath + ".unseen"
+		unseen, err := filter.valueSet.writeUnseen(unseenPath)
+		if err != nil {
+			panic(err)
+		}
+		log.Printf("%v of %v value(s) found, values never seen are listed in %v",
+			len(filter.valueSet.values)-unseen, len(filter.valueSet.values), unseenPath)
+	}
 
-		log.Printf("%v...", filePath)
-		_, err = dmp.ReadFromFile(
-			context.Background(),
-			filePath,
-			proc4Filter,
-		)
+}
 
+//rowFilterType decides which rows Filter outputs
+type rowFilterType struct {
+	//outputName distinguishes outputs and checkpoints of different filters of a table
+	outputName string
+	matches    func(cellsBytes [][]byte) bool
+	//valueSet is loaded from -fvfile
+	valueSet *valueSetType
+	//valueOf returns the cell, or the fusion sub-field of it, compared by -fo
+	valueOf func(cellsBytes [][]byte) ([]byte, bool)
+}
+
+//newRowFilter makes a filter of the -fx expression or of the -fc column compared by -fo with -fv or the values of -fvfile
+func newRowFilter(conf *TableMaps, table *TableMap) (result *rowFilterType, err error) {
+	result = &rowFilterType{}
+	if *filterExpression != "" {
+		expression, err := expr.Compile(*filterExpression, &expr.ConfigType{
+			Columns: table.columnPosition,
+			Fusion:  conf.fusion(),
+		})
 		if err != nil {
-			err = errors.Wrapf(err, "could not read %v", filePath)
-			panic(err)
+			return nil, err
 		}
+		result.outputName = "fx." + shortHash(expression.String())
+		log.Printf("filtering %v by %v to %v.%v", table.TableName, expression, table.TableName, result.outputName)
+		result.matches = expression.Match
+		return result, nil
+	}
+
+	colpos, err := table.columnPosition(*columnToFilter)
+	if err != nil {
+		return
 	}
-	if table.writer != nil {
-		table.writer.Close()
+	operator, err := parseFilterOperator(*filterOperator)
+	if err != nil {
+		return
+	}
+	var matchCell func(cell []byte) bool
+	if *valueFileToFilter != "" {
+		if operator.operator != 'e' {
+			err = errors.Errorf("value file is compared with equal operators only: e, ei, ne, nei")
+			return
+		}
+		if *valueFileSplit && operator.negated {
+			err = errors.Errorf("rows can't be split by values when the value file operator is negated")
+			return
+		}
+		valueSet, err := loadValueSet(*valueFileToFilter, operator.ignoreCase)
+		if err != nil {
+			return nil, err
+		}
+		result.valueSet = valueSet
+		matchCell = valueSet.match
+		if operator.negated {
+			matchCell = func(cell []byte) bool {
+				return !valueSet.match(cell)
+			}
+		}
+		_, fileName := split(*valueFileToFilter)
+		result.outputName = "in." + strings.TrimSuffix(fileName, path.Ext(fileName))
+		if operator.name != "e" {
+			result.outputName = operator.name + "." + result.outputName
+		}
+		log.Printf("filtering %v by %v value(s) of %v", table.TableName, len(valueSet.values), *valueFileToFilter)
+	} else {
+		matchCell, err = operator.compile(*valueToFilter)
+		if err != nil {
+			return
+		}
+		result.outputName = operator.outputName(*valueToFilter)
+	}
+	//-ffcs and -ffcp address a sub-field of a fused column the way JsonCheck does
+	if *ffcs < 0 || *ffcp < 1 || *ffcs > 0 && *ffcp > *ffcs {
+		err = errors.Errorf("fusion position %v of size %v is out of range", *ffcp, *ffcs)
+		return
+	}
+	fusion := conf.fusion()
+	if *ffcs != 1 {
+		if fusion == nil {
+			err = errors.Errorf("specify fusion_separator_char in config to filter by fusion sub-fields")
+			return
+		}
+		result.outputName = fmt.Sprintf("%v.%v.%v", result.outputName, *ffcp, *ffcs)
+	}
+	size, position := *ffcs, *ffcp
+	result.valueOf = func(cellsBytes [][]byte) ([]byte, bool) {
+		if len(cellsBytes) <= colpos {
+			return nil, false
+		}
+		if size == 1 {
+			return cellsBytes[colpos], true
+		}
+		return fusion.Field(cellsBytes[colpos], position, size)
 	}
+	matchEmpty := *valueToEmptyEmpty
+	result.matches = func(cellsBytes [][]byte) bool {
+		cellBytes, found := result.valueOf(cellsBytes)
+		if !found {
+			return false
+		}
+		if len(cellBytes) == 0 && !matchEmpty {
+			return false
+		}
+		return matchCell(cellBytes)
+	}
+	return
+}
+
+//filterOperatorType is a parsed -fo option: an operator letter e=equal, p=prefix, s=suffix, i=inclusion,
+//r=regular expression, optionally prefixed with n to negate it and followed by i to ignore case.
+//E.g. ne is not equal, ni is not contains, pi is case-insensitive prefix, nri is case-insensitive regex mismatch
+type filterOperatorType struct {
+	name       string
+	operator   byte
+	negated    bool
+	ignoreCase bool
+}
+
+func parseFilterOperator(option string) (result filterOperatorType, err error) {
+	s := strings.ToLower(option)
+	result.name = s
+	if strings.HasPrefix(s, "n") && len(s) > 1 {
+		result.negated = true
+		s = s[1:]
+	}
+	if len(s) == 2 && s[1] == 'i' {
+		result.ignoreCase = true
+		s = s[:1]
+	}
+	if len(s) != 1 || strings.IndexByte("epsir", s[0]) == -1 {
+		err = errors.Errorf("filter operator value, -fo option, (%v) is not recognized: "+
+			"e=equal,p=prefix,s=suffix,i=inclusion,r=regular expression, "+
+			"n prefix negates, i suffix ignores case", option)
+		return
+	}
+	result.operator = s[0]
+	return
+}
+
+//compile returns a function checking a cell against value
+func (o filterOperatorType) compile(value string) (match func(cell []byte) bool, err error) {
+	if o.operator == 'r' {
+		pattern := value
+		if o.ignoreCase {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			err = errors.Wrapf(err, "could not compile regular expression %v", value)
+			return nil, err
+		}
+		match = re.Match
+	} else {
+		valueBytes := []byte(value)
+		if o.ignoreCase {
+			valueBytes = bytes.ToLower(valueBytes)
+		}
+		var compare func(cell, value []byte) bool
+		switch o.operator {
+		case 'e':
+			compare = bytes.Equal
+		case 'p':
+			compare = bytes.HasPrefix
+		case 's':
+			compare = bytes.HasSuffix
+		case 'i':
+			compare = bytes.Contains
+		}
+		match = func(cell []byte) bool {
+			if o.ignoreCase {
+				cell = bytes.ToLower(cell)
+			}
+			return compare(cell, valueBytes)
+		}
+	}
+	if o.negated {
+		positive := match
+		match = func(cell []byte) bool {
+			return !positive(cell)
+		}
+	}
+	return
+}
+
+//outputName keeps output names of the equality filter as they were: the value alone
+func (o filterOperatorType) outputName(value string) string {
+	if o.operator == 'r' {
+		value = shortHash(value)
+	}
+	if o.operator == 'e' && !o.negated && !o.ignoreCase {
+		return value
+	}
+	return fmt.Sprintf("%v.%v", o.name, value)
+}
 
+func shortHash(s string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(s))
+	return fmt.Sprintf("%08x", hash.Sum32())
 }
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFilterOperator(t *testing.T) {
+	tests := []struct {
+		option     string
+		operator   byte
+		negated    bool
+		ignoreCase bool
+	}{
+		{"e", 'e', false, false},
+		{"E", 'e', false, false},
+		{"n", 0, false, false},
+		{"ne", 'e', true, false},
+		{"ni", 'i', true, false},
+		{"ii", 'i', false, true},
+		{"nri", 'r', true, true},
+		{"pi", 'p', false, true},
+		{"ns", 's', true, false},
+		{"x", 0, false, false},
+		{"nn", 0, false, false},
+		{"eii", 0, false, false},
+		{"", 0, false, false},
+	}
+	for _, test := range tests {
+		operator, err := parseFilterOperator(test.option)
+		if test.operator == 0 {
+			if err == nil {
+				t.Errorf("%q is parsed as %+v", test.option, operator)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.option, err)
+		} else if operator.operator != test.operator || operator.negated != test.negated || operator.ignoreCase != test.ignoreCase {
+			t.Errorf("%q is parsed as %+v", test.option, operator)
+		}
+	}
+}
+
+func TestFilterOperatorCompile(t *testing.T) {
+	tests := []struct {
+		option string
+		value  string
+		cell   string
+		match  bool
+	}{
+		{"e", "abc", "abc", true},
+		{"e", "abc", "ABC", false},
+		{"ei", "abc", "ABC", true},
+		{"ne", "abc", "abc", false},
+		{"nei", "abc", "aBc", false},
+		{"nei", "abc", "abd", true},
+		{"p", "ab", "abc", true},
+		{"p", "bc", "abc", false},
+		{"pi", "AB", "abc", true},
+		{"s", "bc", "abc", true},
+		{"si", "BC", "aBc", true},
+		{"i", "b", "abc", true},
+		{"ni", "b", "abc", false},
+		{"ii", "B", "abc", true},
+		{"r", "^a.c$", "abc", true},
+		{"r", "^a.c$", "ABC", false},
+		{"ri", "^a.c$", "ABC", true},
+		{"nr", "[0-9]", "abc", true},
+		{"nri", "B", "abc", false},
+	}
+	for _, test := range tests {
+		operator, err := parseFilterOperator(test.option)
+		if err != nil {
+			t.Fatal(err)
+		}
+		match, err := operator.compile(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match([]byte(test.cell)) != test.match {
+			t.Errorf("-fo=%v -fv=%v on %q: %v expected", test.option, test.value, test.cell, test.match)
+		}
+	}
+
+	operator, _ := parseFilterOperator("r")
+	if _, err := operator.compile("a("); err == nil {
+		t.Errorf("invalid regular expression is compiled")
+	}
+}
+
+func TestFilterOperatorOutputName(t *testing.T) {
+	tests := []struct {
+		option string
+		value  string
+		name   string
+	}{
+		{"e", "abc", "abc"},
+		{"ne", "abc", "ne.abc"},
+		{"ei", "abc", "ei.abc"},
+		{"p", "abc", "p.abc"},
+		{"r", "a/b", "r." + shortHash("a/b")},
+		{"nri", "a/b", "nri." + shortHash("a/b")},
+	}
+	for _, test := range tests {
+		operator, err := parseFilterOperator(test.option)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name := operator.outputName(test.value); name != test.name {
+			t.Errorf("-fo=%v -fv=%v output is named %v, %v expected", test.option, test.value, name, test.name)
+		}
+	}
+}
+
+//filterFlagsType are Filter options newRowFilter reads
+type filterFlagsType struct {
+	expression, column, operator, value, valueFile string
+	split, empty                                   bool
+	size, position                                 int
+}
+
+//set applies the options returning a function restoring the previous ones
+func (f filterFlagsType) set() (restore func()) {
+	saved := filterFlagsType{*filterExpression, *columnToFilter, *filterOperator, *valueToFilter, *valueFileToFilter,
+		*valueFileSplit, *valueToEmptyEmpty, *ffcs, *ffcp}
+	assign := func(f filterFlagsType) {
+		*filterExpression, *columnToFilter, *filterOperator, *valueToFilter, *valueFileToFilter = f.expression, f.column, f.operator, f.value, f.valueFile
+		*valueFileSplit, *valueToEmptyEmpty, *ffcs, *ffcp = f.split, f.empty, f.size, f.position
+	}
+	if f.operator == "" {
+		f.operator = "e"
+	}
+	if f.size == 0 && f.position == 0 {
+		f.size, f.position = 1, 1
+	}
+	assign(f)
+	return func() {
+		assign(saved)
+	}
+}
+
+func filterTestTable() *TableMap {
+	return &TableMap{TableName: "t", headers: [][]byte{[]byte("id"), []byte("amount"), []byte("name")}}
+}
+
+//filterRows returns indexes of rows matched
+func filterRows(filter *rowFilterType, rows ...[]string) (result []int) {
+	for index, row := range rows {
+		cells := make([][]byte, len(row))
+		for column, cell := range row {
+			cells[column] = []byte(cell)
+		}
+		if filter.matches(cells) {
+			result = append(result, index)
+		}
+	}
+	return
+}
+
+func TestRowFilterExpression(t *testing.T) {
+	defer filterFlagsType{expression: "amount > 2 AND NAME LIKE 'a%'"}.set()()
+	filter, err := newRowFilter(&TableMaps{}, filterTestTable())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(filter.outputName, "fx.") || filter.valueSet != nil {
+		t.Errorf("filter %+v", filter)
+	}
+	matched := filterRows(filter, []string{"1", "3", "abc"}, []string{"2", "1", "abc"}, []string{"3", "3", "bc"}, []string{"4", "3"})
+	if !reflect.DeepEqual(matched, []int{0}) {
+		t.Errorf("rows %v matched", matched)
+	}
+
+	defer filterFlagsType{expression: "price > 2"}.set()()
+	if _, err = newRowFilter(&TableMaps{}, filterTestTable()); err == nil {
+		t.Errorf("expression of an unknown column is compiled")
+	}
+}
+
+func TestRowFilterValueFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pathToFile := path.Join(dir, "ids.txt")
+	if err = ioutil.WriteFile(pathToFile, []byte("A1\nb2\n\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	rows := [][]string{{"a1", "1"}, {"A1", "2"}, {"B2", "3"}, {"c3", "4"}, {"", "5"}, {}}
+
+	tests := []struct {
+		operator   string
+		empty      bool
+		outputName string
+		matched    []int
+		counts     map[string]uint64
+	}{
+		{"e", false, "in.ids", []int{1}, map[string]uint64{"A1": 1}},
+		{"ei", false, "ei.in.ids", []int{0, 1, 2}, map[string]uint64{"a1": 2, "b2": 1}},
+		{"ne", false, "ne.in.ids", []int{0, 2, 3}, map[string]uint64{"A1": 1}},
+		{"ne", true, "ne.in.ids", []int{0, 2, 3, 4}, map[string]uint64{"A1": 1}},
+	}
+	for _, test := range tests {
+		restore := filterFlagsType{column: "ID", operator: test.operator, valueFile: pathToFile, empty: test.empty}.set()
+		filter, err := newRowFilter(&TableMaps{}, filterTestTable())
+		restore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		matched := filterRows(filter, rows...)
+		if filter.outputName != test.outputName || !reflect.DeepEqual(matched, test.matched) ||
+			!reflect.DeepEqual(filter.valueSet.counts(), test.counts) {
+			t.Errorf("-fo=%v: %v matches rows %v counting %v", test.operator, filter.outputName, matched, filter.valueSet.counts())
+		}
+	}
+
+	for _, flags := range []filterFlagsType{
+		{column: "id", operator: "p", valueFile: pathToFile},
+		{column: "id", operator: "ne", valueFile: pathToFile, split: true},
+		{column: "id", valueFile: path.Join(dir, "missing.txt")},
+		{column: "price", valueFile: pathToFile},
+	} {
+		restore := flags.set()
+		if _, err = newRowFilter(&TableMaps{}, filterTestTable()); err == nil {
+			t.Errorf("filter of %+v is made", flags)
+		}
+		restore()
+	}
+}
+
+func TestRowFilterFusion(t *testing.T) {
+	conf := &TableMaps{FusionSeparatorChar: "|"}
+	rows := [][]string{{"x|A1|y"}, {"A1|x|y"}, {"x|A1"}, {"A1"}, {"x||y"}, {}}
+	tests := []struct {
+		flags      filterFlagsType
+		outputName string
+		matched    []int
+	}{
+		{filterFlagsType{column: "id", value: "A1", size: 3, position: 2}, "A1.2.3", []int{0}},
+		{filterFlagsType{column: "id", value: "A1", size: 3, position: 1}, "A1.1.3", []int{1}},
+		{filterFlagsType{column: "id", value: "A1", size: 0, position: 2}, "A1.2.0", []int{0, 2}},
+		{filterFlagsType{column: "id", value: "A1"}, "A1", []int{3}},
+		{filterFlagsType{column: "id", operator: "ne", value: "A1", size: 3, position: 2}, "ne.A1.2.3", []int{1}},
+		{filterFlagsType{column: "id", operator: "ne", value: "A1", size: 3, position: 2, empty: true}, "ne.A1.2.3", []int{1, 4}},
+	}
+	for _, test := range tests {
+		restore := test.flags.set()
+		filter, err := newRowFilter(conf, filterTestTable())
+		restore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matched := filterRows(filter, rows...); filter.outputName != test.outputName || !reflect.DeepEqual(matched, test.matched) {
+			t.Errorf("%+v: %v matches rows %v", test.flags, filter.outputName, matched)
+		}
+	}
+
+	for _, flags := range []filterFlagsType{
+		{column: "id", value: "A1", size: 2, position: 3},
+		{column: "id", value: "A1", size: -1, position: 1},
+		{column: "id", value: "A1", size: 2, position: 0},
+	} {
+		restore := flags.set()
+		if _, err := newRowFilter(conf, filterTestTable()); err == nil {
+			t.Errorf("filter of %+v is made", flags)
+		}
+		restore()
+	}
+	restore := filterFlagsType{column: "id", value: "A1", size: 2, position: 1}.set()
+	defer restore()
+	if _, err := newRowFilter(&TableMaps{}, filterTestTable()); err == nil {
+		t.Errorf("filter of a fusion sub-field is made without fusion_separator_char")
+	}
+}
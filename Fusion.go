@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ovlad32/geq/dump"
+	"github.com/pkg/errors"
+)
+
+var tableToDiscover = flag.String("dt", "", "")
+var fusionMaxSize = flag.Int("dmax", 8, "")
+var fusionTop = flag.Int("dtop", 5, "")
+
+//minFusionTopCapacity is lower than the profile one, as there is a profile per sub-field
+const minFusionTopCapacity = 100
+
+//fusionPrecision makes distinct counts of sub-fields cheaper than the column ones
+const fusionPrecision = 12
+
+//fusedColumnType accumulates numbers of sub-fields of column values and profiles of the sub-fields
+type fusedColumnType struct {
+	//Values is a number of non-blank values, Sizes are numbers of them by a number of sub-fields
+	Values uint64         `json:"values"`
+	Sizes  map[int]uint64 `json:"sizes"`
+	//Positions are profiles of sub-fields by the number of sub-fields of a value, sizes above the maximum are not profiled
+	Positions   map[int][]*columnProfileType `json:"positions"`
+	topCapacity int
+}
+
+func newFusedColumn(topCapacity int) *fusedColumnType {
+	return &fusedColumnType{
+		Sizes:       make(map[int]uint64),
+		Positions:   make(map[int][]*columnProfileType),
+		topCapacity: topCapacity,
+	}
+}
+
+func (c *fusedColumnType) positions(size int) []*columnProfileType {
+	result, found := c.Positions[size]
+	if !found {
+		result = make([]*columnProfileType, size)
+		for index := range result {
+			result[index] = newColumnProfile(c.topCapacity, fusionPrecision)
+		}
+		c.Positions[size] = result
+	}
+	return result
+}
+
+func (c *fusedColumnType) add(value []byte, separator []byte, maxSize int) {
+	if len(bytes.TrimSpace(value)) == 0 {
+		return
+	}
+	c.Values++
+	parts := bytes.Split(value, separator)
+	c.Sizes[len(parts)]++
+	if len(parts) < 2 || len(parts) > maxSize {
+		return
+	}
+	for index, profile := range c.positions(len(parts)) {
+		profile.add(parts[index], true)
+	}
+}
+
+func (c *fusedColumnType) merge(other *fusedColumnType) {
+	c.Values += other.Values
+	for size, count := range other.Sizes {
+		c.Sizes[size] += count
+	}
+	for size, profiles := range other.Positions {
+		for index, profile := range c.positions(size) {
+			profile.merge(profiles[index])
+		}
+	}
+}
+
+//dominantSize returns the most frequent number of sub-fields, the smaller one of equally frequent
+func (c *fusedColumnType) dominantSize() (size int) {
+	for s, count := range c.Sizes {
+		if count > c.Sizes[size] || count == c.Sizes[size] && s < size {
+			size = s
+		}
+	}
+	return
+}
+
+//fused tells if any value of the column has been split
+func (c *fusedColumnType) fused() bool {
+	for size := range c.Sizes {
+		if size > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+//tableFusionType is a fusion structure of table columns in the header order
+type tableFusionType struct {
+	Rows      uint64             `json:"rows"`
+	MaxSize   int                `json:"max_size"`
+	Columns   []*fusedColumnType `json:"columns"`
+	separator []byte
+}
+
+func newTableFusion(columnCount, maxSize, topCapacity int, separator []byte) *tableFusionType {
+	result := &tableFusionType{
+		MaxSize:   maxSize,
+		Columns:   make([]*fusedColumnType, columnCount),
+		separator: separator,
+	}
+	for index := range result.Columns {
+		result.Columns[index] = newFusedColumn(topCapacity)
+	}
+	return result
+}
+
+func (f *tableFusionType) add(cellsBytes [][]byte) {
+	f.Rows++
+	for index, column := range f.Columns {
+		if index < len(cellsBytes) {
+			column.add(cellsBytes[index], f.separator, f.MaxSize)
+		}
+	}
+}
+
+func (f *tableFusionType) merge(partial mergeableType) {
+	other := partial.(*tableFusionType)
+	f.Rows += other.Rows
+	for index, column := range f.Columns {
+		column.merge(other.Columns[index])
+	}
+}
+
+//fusionSizeReportType is a number of column values split into Size sub-fields
+type fusionSizeReportType struct {
+	Size    int     `json:"size"`
+	Count   uint64  `json:"count"`
+	Percent float64 `json:"percent"`
+}
+
+//fusionReportType describes a fused column.
+//Positions are named as fusion references of filter expressions: column/position/size
+type fusionReportType struct {
+	Column       string                  `json:"column"`
+	Values       uint64                  `json:"values"`
+	DominantSize int                     `json:"dominant_size"`
+	Sizes        []*fusionSizeReportType `json:"sizes"`
+	Positions    []*columnReportType     `json:"positions"`
+}
+
+//fusionMatchReportType checks a fusion size referenced by match results against the sizes found in data
+type fusionMatchReportType struct {
+	Column       string `json:"column"`
+	FusionSize   int    `json:"fusion_size"`
+	Joins        int    `json:"joins"`
+	DominantSize int    `json:"dominant_size"`
+	//Alignment is the FusionColumnSizeAlignment making the fusion size to address the dominant number of sub-fields
+	Alignment  int  `json:"alignment"`
+	Consistent bool `json:"consistent"`
+}
+
+func (t *TableMap) fusionReport(fusion *tableFusionType, top int) (result []*fusionReportType) {
+	for index, column := range fusion.Columns {
+		if !column.fused() {
+			continue
+		}
+		name := strings.TrimSpace(string(t.headers[index]))
+		report := &fusionReportType{
+			Column:       name,
+			Values:       column.Values,
+			DominantSize: column.dominantSize(),
+		}
+		for size, count := range column.Sizes {
+			report.Sizes = append(report.Sizes, &fusionSizeReportType{
+				Size:    size,
+				Count:   count,
+				Percent: float64(count*10000/column.Values) / 100,
+			})
+		}
+		sort.Slice(report.Sizes, func(i, j int) bool {
+			if report.Sizes[i].Count != report.Sizes[j].Count {
+				return report.Sizes[i].Count > report.Sizes[j].Count
+			}
+			return report.Sizes[i].Size < report.Sizes[j].Size
+		})
+		for _, size := range report.Sizes {
+			for position, profile := range column.Positions[size.Size] {
+				report.Positions = append(report.Positions,
+					profile.report(fmt.Sprintf("%v/%v/%v", name, position+1, size.Size), top))
+			}
+		}
+		result = append(result, report)
+	}
+	return
+}
+
+//fusionMatchReport collects fusion sizes of the table columns referenced by match results
+func (t *TableMap) fusionMatchReport(fusion *tableFusionType, matches []*MatchResult, alignment int) (result []*fusionMatchReportType, err error) {
+	type keyType struct {
+		column string
+		size   int
+	}
+	joins := make(map[keyType]int)
+	add := func(table, column string, size int) {
+		if size > 1 && strings.ToLower(table) == strings.ToLower(t.TableName) {
+			joins[keyType{strings.ToLower(strings.TrimSpace(column)), size}]++
+		}
+	}
+	for _, match := range matches {
+		for _, join := range match.Joins {
+			add(join.LeftTable, join.LeftColumn, join.LeftSize)
+			for _, right := range join.RightColumns {
+				add(right.RightTable, right.RightColumn, right.RightSize)
+			}
+		}
+	}
+	for key, count := range joins {
+		index, err := t.columnPosition(key.column)
+		if err != nil {
+			return nil, err
+		}
+		dominant := fusion.Columns[index].dominantSize()
+		result = append(result, &fusionMatchReportType{
+			Column:       strings.TrimSpace(string(t.headers[index])),
+			FusionSize:   key.size,
+			Joins:        count,
+			DominantSize: dominant,
+			Alignment:    dominant - key.size,
+			Consistent:   dominant == key.size+alignment,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Column != result[j].Column {
+			return result[i].Column < result[j].Column
+		}
+		return result[i].FusionSize < result[j].FusionSize
+	})
+	return
+}
+
+//Fusion scans a table splitting values by FusionSeparatorChar and reports numbers of sub-fields of fused columns
+//and profiles of the sub-fields to <table>.fusion.json, <table>.fusion.tsv and <table>.fusion.positions.tsv.
+//Fusion sizes of match results given with -i are checked against the sizes found
+func Fusion() {
+	var table *TableMap = nil
+
+	if *tableToDiscover == "" {
+		panic("specify table name to discover fusion structure")
+	}
+	if *fusionMaxSize < 2 {
+		panic("maximum fusion size to profile must be at least 2")
+	}
+	if *fusionTop < 0 {
+		panic("number of top values must not be negative")
+	}
+
+	conf, err := readConfig()
+	if err != nil {
+		err = errors.Wrapf(err, "could not read config")
+		panic(err)
+	}
+	if conf.FusionSeparatorChar == "" {
+		panic("fusion_separator_char is not set in config file")
+	}
+
+	for _, tb := range conf.Tables {
+		if strings.ToLower(tb.TableName) == strings.ToLower(*tableToDiscover) {
+			table = tb
+			break
+		}
+	}
+	if table == nil {
+		panic(fmt.Sprintf("table %v not found in config file", *tableToDiscover))
+	}
+
+	table.readHeader([]byte(conf.HeaderColumnSeparatorChar))
+
+	var matches []*MatchResult
+	if *pfin != "" {
+		matches, err = readJoinFiles(*pfin)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	dc, err := conf.dumperConfig(table)
+	if err != nil {
+		err = errors.Wrapf(err, "could not read dump config")
+		panic(err)
+	}
+
+	dmp, err := dump.NewDumper(dc)
+	if err != nil {
+		err = errors.Wrapf(err, "could not create dumper")
+		panic(err)
+	}
+
+	topCapacity := *fusionTop * topCapacityFactor
+	if topCapacity < minFusionTopCapacity {
+		topCapacity = minFusionTopCapacity
+	}
+	separator := []byte(conf.FusionSeparatorChar)
+	total := newTableFusion(len(table.headers), *fusionMaxSize, topCapacity, separator)
+
+	cp, err := newCheckpoint("fusion", table)
+	if err != nil {
+		panic(err)
+	}
+	err = cp.restoreState(total)
+	if err != nil {
+		panic(err)
+	}
+	if len(total.Columns) != len(table.headers) {
+		panic(fmt.Sprintf("checkpoint has %v column(s), but header of %v has %v", len(total.Columns), table.TableName, len(table.headers)))
+	}
+	if total.MaxSize != *fusionMaxSize {
+		panic(fmt.Sprintf("checkpoint has been made with -dmax=%v", total.MaxSize))
+	}
+	for _, column := range total.Columns {
+		column.topCapacity = topCapacity
+		for _, profiles := range column.Positions {
+			for _, profile := range profiles {
+				profile.restore(topCapacity)
+			}
+		}
+	}
+
+	err = table.readDataMerging(dmp, cp, total, func() mergeableType {
+		return newTableFusion(len(table.headers), *fusionMaxSize, topCapacity, separator)
+	}, func() interface{} {
+		for _, column := range total.Columns {
+			for _, profiles := range column.Positions {
+				for _, profile := range profiles {
+					profile.prepareSave()
+				}
+			}
+		}
+		return total
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	report := table.fusionReport(total, *fusionTop)
+	var matchReport []*fusionMatchReportType
+	if matches != nil {
+		matchReport, err = table.fusionMatchReport(total, matches, conf.FusionColumnSizeAlignment)
+		if err != nil {
+			panic(err)
+		}
+		for _, check := range matchReport {
+			if !check.Consistent {
+				log.Printf("%v.%v: match results use fusion size %v, but %v sub-fields dominate, fusion_column_size_alignment should be %v",
+					table.TableName, check.Column, check.FusionSize, check.DominantSize, check.Alignment)
+			}
+		}
+	}
+
+	err = os.MkdirAll(*pfout, 0777)
+	if err != nil {
+		panic(err)
+	}
+	pathToReport := path.Join(*pfout, table.TableName+".fusion")
+	err = writeFusionJSON(pathToReport+".json", table.TableName, total.Rows, report, matchReport)
+	if err == nil {
+		err = writeFusionTSV(pathToReport+".tsv", byte(conf.ResultColumnSeparatorByte), report)
+	}
+	if err == nil {
+		var positions []*columnReportType
+		for _, column := range report {
+			positions = append(positions, column.Positions...)
+		}
+		err = writeProfileTSV(pathToReport+".positions.tsv", byte(conf.ResultColumnSeparatorByte), positions)
+	}
+	if err != nil {
+		panic(err)
+	}
+	log.Printf("%v fused column(s) of %v found in %v row(s), reported to %v.json and %v.tsv",
+		len(report), table.TableName, total.Rows, pathToReport, pathToReport)
+}
+
+func writeFusionJSON(pathToFile string, tableName string, rows uint64, report []*fusionReportType, matchReport []*fusionMatchReportType) (err error) {
+	data, err := json.MarshalIndent(struct {
+		Table   string                   `json:"table"`
+		Rows    uint64                   `json:"rows"`
+		Columns []*fusionReportType      `json:"columns"`
+		Matches []*fusionMatchReportType `json:"matches,omitempty"`
+	}{tableName, rows, report, matchReport}, "", " ")
+	if err == nil {
+		err = ioutil.WriteFile(pathToFile, data, 0666)
+	}
+	return errors.Wrapf(err, "could not write fusion report %v", pathToFile)
+}
+
+func writeFusionTSV(pathToFile string, separator byte, report []*fusionReportType) (err error) {
+	file, err := os.Create(pathToFile)
+	if err != nil {
+		return errors.Wrapf(err, "could not create fusion report %v", pathToFile)
+	}
+	writer := bufio.NewWriter(file)
+	sep := string(separator)
+	writer.WriteString(strings.Join([]string{"column", "size", "count", "percent", "dominant"}, sep) + "\n")
+	for _, column := range report {
+		for _, size := range column.Sizes {
+			writer.WriteString(strings.Join([]string{
+				column.Column,
+				strconv.Itoa(size.Size),
+				strconv.FormatUint(size.Count, 10),
+				strconv.FormatFloat(size.Percent, 'f', 2, 64),
+				strconv.FormatBool(size.Size == column.DominantSize),
+			}, sep) + "\n")
+		}
+	}
+	err = writer.Flush()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Wrapf(err, "could not write fusion report %v", pathToFile)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func fusionTestTable() *TableMap {
+	return &TableMap{TableName: "t", headers: [][]byte{[]byte("id"), []byte("code"), []byte(" name ")}}
+}
+
+var fusionTestRows = [][]string{
+	{"1", "a|b|c", "x"},
+	{"2", "a|d|c", "y|z"},
+	{"3", "e|b", ""},
+	{"4", "f|g|h"},
+	{"5"},
+	{"6", "  ", "q"},
+}
+
+func addFusionRows(fusion *tableFusionType, rows [][]string) {
+	for _, row := range rows {
+		cells := make([][]byte, len(row))
+		for column, cell := range row {
+			cells[column] = []byte(cell)
+		}
+		fusion.add(cells)
+	}
+}
+
+func TestTableFusion(t *testing.T) {
+	fusion := newTableFusion(3, 2, 100, []byte("|"))
+	addFusionRows(fusion, fusionTestRows)
+	code := fusion.Columns[1]
+	if fusion.Rows != 6 || code.Values != 4 || !reflect.DeepEqual(code.Sizes, map[int]uint64{3: 3, 2: 1}) {
+		t.Errorf("%v row(s), %v value(s) of sizes %v", fusion.Rows, code.Values, code.Sizes)
+	}
+	//sizes above the maximum are not profiled
+	if len(code.Positions) != 1 || len(code.Positions[2]) != 2 || code.Positions[2][0].Values != 1 {
+		t.Errorf("positions %v", code.Positions)
+	}
+	if fusion.Columns[0].fused() || !code.fused() || !fusion.Columns[2].fused() {
+		t.Errorf("fused columns are not told")
+	}
+
+	tests := []struct {
+		sizes    map[int]uint64
+		dominant int
+	}{
+		{map[int]uint64{}, 0},
+		{map[int]uint64{1: 5, 3: 7, 2: 6}, 3},
+		{map[int]uint64{3: 2, 2: 2, 4: 1}, 2},
+	}
+	for _, test := range tests {
+		column := &fusedColumnType{Sizes: test.sizes}
+		if dominant := column.dominantSize(); dominant != test.dominant {
+			t.Errorf("dominant size of %v is %v", test.sizes, dominant)
+		}
+	}
+}
+
+func TestFusionReport(t *testing.T) {
+	table := fusionTestTable()
+	whole := newTableFusion(3, 3, 100, []byte("|"))
+	addFusionRows(whole, fusionTestRows)
+	first, second := newTableFusion(3, 3, 100, []byte("|")), newTableFusion(3, 3, 100, []byte("|"))
+	addFusionRows(first, fusionTestRows[:3])
+	addFusionRows(second, fusionTestRows[3:])
+	first.merge(second)
+
+	report := table.fusionReport(first, 5)
+	if !reflect.DeepEqual(report, table.fusionReport(whole, 5)) {
+		t.Errorf("merged fusion report differs from the whole one")
+	}
+	if len(report) != 2 || report[0].Column != "code" || report[1].Column != "name" {
+		t.Fatalf("fused columns %+v", report)
+	}
+	code, name := report[0], report[1]
+	expectedSizes := []*fusionSizeReportType{{Size: 3, Count: 3, Percent: 75}, {Size: 2, Count: 1, Percent: 25}}
+	if code.Values != 4 || code.DominantSize != 3 || !reflect.DeepEqual(code.Sizes, expectedSizes) {
+		t.Errorf("code: %+v", *code)
+	}
+	var positions []string
+	for _, position := range code.Positions {
+		positions = append(positions, position.Column)
+	}
+	if !reflect.DeepEqual(positions, []string{"code/1/3", "code/2/3", "code/3/3", "code/1/2", "code/2/2"}) {
+		t.Errorf("code positions %v", positions)
+	}
+	if top := code.Positions[0].Top; len(top) != 1 || top[0].Value != "a" || top[0].Count != 2 {
+		t.Errorf("code/1/3 top %v", top)
+	}
+	expectedSizes = []*fusionSizeReportType{{Size: 1, Count: 2, Percent: 66.66}, {Size: 2, Count: 1, Percent: 33.33}}
+	if name.DominantSize != 1 || !reflect.DeepEqual(name.Sizes, expectedSizes) || len(name.Positions) != 2 {
+		t.Errorf("name: %+v", *name)
+	}
+}
+
+func TestFusionMatchReport(t *testing.T) {
+	table := fusionTestTable()
+	fusion := newTableFusion(3, 3, 100, []byte("|"))
+	addFusionRows(fusion, fusionTestRows)
+
+	join := func(leftTable, leftColumn string, leftSize int, rightColumns ...*MatchedRightColumn) *MatchResult {
+		return &MatchResult{Joins: []*MatchedJoin{{
+			LeftTable: leftTable, LeftColumn: leftColumn, LeftSize: leftSize, RightColumns: rightColumns,
+		}}}
+	}
+	matches := []*MatchResult{
+		join("T", "CODE", 3, &MatchedRightColumn{RightTable: "t", RightColumn: "name", RightSize: 2}),
+		join("t", "code", 3, &MatchedRightColumn{RightTable: "other", RightColumn: "name", RightSize: 4}),
+		join("other", "code", 2),
+		join("t", "id", 1),
+	}
+	report, err := table.fusionMatchReport(fusion, matches, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*fusionMatchReportType{
+		{Column: "code", FusionSize: 3, Joins: 2, DominantSize: 3, Alignment: 0, Consistent: true},
+		{Column: "name", FusionSize: 2, Joins: 1, DominantSize: 1, Alignment: -1, Consistent: false},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		for _, check := range report {
+			t.Errorf("%+v", *check)
+		}
+	}
+
+	report, err = table.fusionMatchReport(fusion, matches, -1)
+	if err != nil || len(report) != 2 || report[0].Consistent || !report[1].Consistent {
+		t.Errorf("alignment -1 is not taken into account: %v", err)
+	}
+
+	if _, err = table.fusionMatchReport(fusion, []*MatchResult{join("t", "missing", 2)}, 0); err == nil {
+		t.Errorf("fusion size of an unknown column is checked")
+	}
+}
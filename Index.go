@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/ovlad32/geq/dump"
+	"github.com/pkg/errors"
+)
+
+var tableToIndex = flag.String("xt", "", "")
+var indexSpan = flag.Int64("xspan", 4, "")
+
+//Index builds seek indexes of gzip data files of a table,
+//an access point is recorded every -xspan MB of decompressed data
+func Index() {
+	var table *TableMap = nil
+
+	if *tableToIndex == "" {
+		panic("specify table name to index data files")
+	}
+	if *indexSpan <= 0 {
+		panic("index span must be positive")
+	}
+
+	conf, err := readConfig()
+	if err != nil {
+		err = errors.Wrapf(err, "could not read config")
+		panic(err)
+	}
+
+	for _, tb := range conf.Tables {
+		if strings.ToLower(tb.TableName) == strings.ToLower(*tableToIndex) {
+			table = tb
+			break
+		}
+	}
+	if table == nil {
+		panic(fmt.Sprintf("table %v not found in config file", *tableToIndex))
+	}
+
+	dc, err := conf.dumperConfig(table)
+	if err != nil {
+		err = errors.Wrapf(err, "could not read dump config")
+		panic(err)
+	}
+
+	files := make(chan string)
+	errs := make(chan error, *workers)
+	var wg sync.WaitGroup
+	for worker := 0; worker < *workers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pathToFile := range files {
+				log.Printf("%v...", pathToFile)
+				index, err := dump.BuildIndex(pathToFile, *indexSpan<<20, dc.LineSeparator)
+				if err == nil {
+					err = index.Save(dump.IndexFileName(pathToFile))
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				log.Printf("%v: %v line(s), %v access point(s)", pathToFile, index.LineCount, len(index.Points))
+			}
+		}()
+	}
+
+	go func() {
+		defer close(files)
+		for _, pathToFile := range table.dataFiles() {
+			codec := dc.Codec
+			if codec == dump.CodecAuto {
+				codec = dump.CodecByFileName(pathToFile)
+			}
+			if codec != dump.CodecGZip {
+				continue
+			}
+			select {
+			case files <- pathToFile:
+			case err := <-errs:
+				errs <- err
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	if err, failed := <-errs; failed {
+		panic(err)
+	}
+}
@@ -118,11 +118,11 @@ func JsonCheck() {
 				jsonFileName: row.fileName,
 			}
 			if jl.LeftSize <= 0 {
-				fmt.Sprintf("Left Fusion Column Size is '%v'<=0 at %v.%v",
+				log.Printf("Left Fusion Column Size is '%v'<=0 at %v.%v",
 					jl.LeftSize, jl.LeftTable, jl.LeftColumn)
 			}
 			if jl.LeftPosition <= 0 {
-				fmt.Sprintf("Left Fusion Column Position is '%v'<=0 at %v.%v",
+				log.Printf("Left Fusion Column Position is '%v'<=0 at %v.%v",
 					jl.LeftPosition, jl.LeftTable, jl.LeftColumn)
 			}
 			if leftTable != nil {
@@ -151,11 +151,11 @@ func JsonCheck() {
 						jr.RightPosition,
 						jr.RightSize)
 					if jr.RightSize <= 0 {
-						fmt.Sprintf("Right Fusion Column Size is '%v'<=0 at %v.%v",
+						log.Printf("Right Fusion Column Size is '%v'<=0 at %v.%v",
 							jr.RightSize, jr.RightTable, jr.RightColumn)
 					}
 					if jr.RightPosition <= 0 {
-						fmt.Sprintf("Right Fusion Column Position is '%v'<=0 at %v.%v",
+						log.Printf("Right Fusion Column Position is '%v'<=0 at %v.%v",
 							jr.RightPosition, jr.RightTable, jr.RightColumn)
 					}
 
@@ -194,141 +194,198 @@ func JsonCheck() {
 		rightRows = append(rightRows, rightColumns)
 	}
 
-	dcs := byte(conf.DataColumnSeparatorByte)
-	dc := &dump.DumperConfigType{
-		ColumnSeparator: dcs,
-		LineSeparator:   dump.LineFeedByte,
-		GZip:            true,
-		BufferSize:      4096,
-	}
-
-	dmp, err := dump.NewDumper(dc)
-	if err != nil {
-		err = errors.Wrapf(err, "could not create dumper")
-		panic(err)
+	fusion := conf.fusion()
+	if fusion == nil {
+		fusion = &dump.FusionType{}
 	}
 
 	check := func(t *TableMap, rows [][]*tcolval, fileSuffix string) {
-		dumpFile := ""
-		var proc4Check dump.RowProcessingFuncType = func(
-			cancelContext context.Context,
-			config *dump.DumperConfigType,
-			currentLineNumber uint64,
-			currentStreamPosition uint64,
-			cellsBytes [][]byte,
-			rawLineBytes []byte,
-		) (err error) {
-			for _, cols := range rows {
-				var found bool
-				for _, jc := range cols {
-					found = false
-					if len(cellsBytes) <= jc.colpos {
-						panic(
-							fmt.Sprintf(
-								"# of columns in %v is less than current column zeroed-position %v",
-								t.TableName, jc.colpos,
-							),
-						)
-					}
-					cellBytes := cellsBytes[jc.colpos]
-					if len(cellBytes)-2 >= len(jc.val) {
-						strippedCellBytes := cellBytes[1 : len(cellBytes)-1]
-						if jc.fcolsize == 1 {
-							if bytes.Compare(strippedCellBytes, jc.val) == 0 {
-								found = true
-							}
-						} else {
-							fcellsBytes := bytes.Split(strippedCellBytes, []byte(conf.FusionSeparatorChar))
-							if len(fcellsBytes) == jc.fcolsize+conf.FusionColumnSizeAlignment {
-								if bytes.Compare(fcellsBytes[jc.fcolpos-1], jc.val) == 0 {
-									found = true
-								}
+		dc, err := conf.dumperConfig(t)
+		if err != nil {
+			err = errors.Wrapf(err, "could not read dump config")
+			panic(err)
+		}
+
+		dmp, err := dump.NewDumper(dc)
+		if err != nil {
+			err = errors.Wrapf(err, "could not create dumper")
+			panic(err)
+		}
+
+		//Parquet outputs have the provenance of a row typed, followed by columns of the table
+		columns, err := t.outputColumns(dmp)
+		if err != nil {
+			panic(err)
+		}
+		if columns != nil {
+			integer := &pgColumnType{name: "integer"}
+			columns = append([]*outputColumnType{
+				textColumn("IOTahoe_file_name"),
+				{name: "IOTahoe_file_line", columnType: integer},
+				textColumn("GE_source_file_name"),
+				{name: "GE_source_file_line", columnType: integer},
+			}, columns...)
+			fileSuffix = strings.TrimSuffix(fileSuffix, ".tsv")
+		}
+
+		type checkState struct {
+			outputStateType
+			Found []int `json:"found"`
+		}
+		state := &checkState{}
+		cp, err := newCheckpoint("c"+strings.TrimSuffix(fileSuffix, ".tsv")+outputExtension(), t)
+		if err != nil {
+			panic(err)
+		}
+		err = cp.restoreState(state)
+		if err != nil {
+			panic(err)
+		}
+		for _, index := range state.Found {
+			for _, v := range rows[index] {
+				v.found = true
+			}
+		}
+		var output outputType
+		cp.flush = func() (interface{}, error) {
+			if output != nil {
+				outputState, err := output.flush()
+				if err != nil {
+					return nil, err
+				}
+				state.outputStateType = *outputState
+			}
+			state.Found = state.Found[:0]
+			for index, cols := range rows {
+				if len(cols) > 0 && cols[0].found {
+					state.Found = append(state.Found, index)
+				}
+			}
+			return state, nil
+		}
+
+		var mutex sync.Mutex
+		newProc4Check := func(dumpFile string) dump.RowProcessingFuncType {
+			return func(
+				cancelContext context.Context,
+				config *dump.DumperConfigType,
+				currentLineNumber uint64,
+				currentStreamPosition uint64,
+				cellsBytes [][]byte,
+				rawLineBytes []byte,
+			) (err error) {
+				for _, cols := range rows {
+					var found bool
+					for _, jc := range cols {
+						found = false
+						if len(cellsBytes) <= jc.colpos {
+							panic(
+								fmt.Sprintf(
+									"# of columns in %v is less than current column zeroed-position %v",
+									t.TableName, jc.colpos,
+								),
+							)
+						}
+						cellBytes := cellsBytes[jc.colpos]
+						if len(cellBytes) >= len(jc.val) {
+							if fcellBytes, ok := fusion.Field(cellBytes, jc.fcolpos, jc.fcolsize); ok {
+								found = bytes.Equal(fcellBytes, jc.val)
 							}
 						}
+						if !found {
+							break
+						}
 					}
-					if !found {
-						break
-					}
-				}
-				if found {
-					for _, v := range cols {
-						v.found = true
-					}
+					if found {
+						mutex.Lock()
+						for _, v := range cols {
+							v.found = true
+						}
 
-					if t.writer == nil {
-						if *pfout == "" {
-							t.writer = os.Stdout
-						} else {
-							err = os.MkdirAll(*pfout, 0777)
+						if output == nil {
+							if *pfout == "" {
+								output = newTextOutput(os.Stdout)
+							} else {
+								err = os.MkdirAll(*pfout, 0777)
+								if err != nil {
+									panic(err)
+								}
+								s := path.Join(*pfout, t.TableName+fileSuffix+outputExtension())
+								output, err = newOutput(s, columns, &state.outputStateType)
+							}
 							if err != nil {
 								panic(err)
 							}
-							s := path.Join(*pfout, t.TableName+fileSuffix)
-							t.writer, err = os.Create(s)
+
+							//the header has already been written to the output being resumed
+							if state.Output == nil && columns == nil {
+								err = output.write(nil,
+									bytes.Join([][]byte{
+										[]byte("\"IOTahoe_file_name\""),
+										[]byte("\"IOTahoe_file_line\""),
+										[]byte("\"GE_source_file_name\""),
+										[]byte("\"GE_source_file_line\""),
+										bytes.Replace(
+											t.allHeaderBytes,
+											[]byte(conf.HeaderColumnSeparatorChar),
+											[]byte{byte(conf.ResultColumnSeparatorByte)},
+											-1,
+										)},
+										[]byte{byte(conf.ResultColumnSeparatorByte)},
+									))
+								if err != nil {
+									panic(err)
+								}
+							}
 						}
-						if err != nil {
-							panic(err)
+						line := bytes.Join([][]byte{
+							[]byte("\"" + cols[0].jsonFileName + "\""),
+							[]byte("\"" + cols[0].matchedRow + "\""),
+							[]byte("\"" + dumpFile + "\""),
+							[]byte(fmt.Sprintf("\"%v\"", currentLineNumber)),
+							bytes.Replace(
+								rawLineBytes,
+								[]byte{byte(conf.DataColumnSeparatorByte)},
+								[]byte{byte(conf.ResultColumnSeparatorByte)},
+								-1,
+							)},
+							[]byte{byte(conf.ResultColumnSeparatorByte)},
+						)
+
+						var cells [][]byte
+						if columns != nil {
+							cells = append([][]byte{
+								[]byte(cols[0].jsonFileName),
+								[]byte(cols[0].matchedRow),
+								[]byte(dumpFile),
+								[]byte(strconv.FormatUint(currentLineNumber, 10)),
+							}, cellsBytes...)
 						}
+						err = output.write(cells, line)
 
-						_, err = t.writer.Write(
-							bytes.Join([][]byte{
-								[]byte("\"IOTahoe_file_name\""),
-								[]byte("\"IOTahoe_file_line\""),
-								[]byte("\"GE_source_file_name\""),
-								[]byte("\"GE_source_file_line\""),
-								bytes.Replace(
-									t.allHeaderBytes,
-									[]byte(conf.HeaderColumnSeparatorChar),
-									[]byte{byte(conf.ResultColumnSeparatorByte)},
-									-1,
-								)},
-								[]byte{byte(conf.ResultColumnSeparatorByte)},
-							))
 						if err != nil {
 							panic(err)
 						}
-					}
-					line := bytes.Join([][]byte{
-						[]byte("\"" + cols[0].jsonFileName + "\""),
-						[]byte("\"" + cols[0].matchedRow + "\""),
-						[]byte("\"" + dumpFile + "\""),
-						[]byte(fmt.Sprintf("\"%v\"", currentLineNumber)),
-						bytes.Replace(
-							rawLineBytes,
-							[]byte{byte(conf.DataColumnSeparatorByte)},
-							[]byte{byte(conf.ResultColumnSeparatorByte)},
-							-1,
-						)},
-						[]byte{byte(conf.ResultColumnSeparatorByte)},
-					)
-
-					_, err = t.writer.Write(line)
-
-					if err != nil {
-						panic(err)
+						mutex.Unlock()
 					}
 				}
+				return
 			}
-			return
 		}
 
-		for _, filePath := range allFiles(t.PathToData, ".gz") {
-			log.Printf("%v...", filePath)
-			_, dumpFile = split(filePath)
-			_, err = dmp.ReadFromFile(
-				context.Background(),
-				filePath,
-				proc4Check,
-			)
-
+		err = t.readData(dmp, false, cp, func(filePath string) dump.RowProcessingFuncType {
+			_, dumpFile := split(filePath)
+			return newProc4Check(dumpFile)
+		}, nil)
+		if err != nil {
+			panic(err)
+		}
+		if output != nil {
+			err = output.Close()
 			if err != nil {
-				err = errors.Wrapf(err, "could not read %v", filePath)
 				panic(err)
 			}
 		}
-		if t.writer != nil {
-			t.writer.Close()
-		}
 
 	}
 
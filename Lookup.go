@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ovlad32/geq/dump"
+	"github.com/pkg/errors"
+)
+
+var tableToLookup = flag.String("lkt", "", "")
+var fileToLookup = flag.String("lkf", "", "")
+var linesToLookup = flag.String("lkl", "", "")
+var lookupInput = flag.String("lki", "", "")
+
+var errLinesFound = errors.New("all lines found")
+
+//Lookup prints rows of table data files by their line numbers.
+//Lines are given with -lkf and -lkl or taken from GE_source_file_name and GE_source_file_line of a JsonCheck output
+func Lookup() {
+	var table *TableMap = nil
+
+	if *tableToLookup == "" {
+		panic("specify table name to lookup rows")
+	}
+	if *lookupInput == "" && (*fileToLookup == "" || *linesToLookup == "") {
+		panic("specify data file and line numbers to lookup or a JsonCheck output file: -lkf=part00.gz -lkl=10,20 or -lki=result.tsv")
+	}
+
+	conf, err := readConfig()
+	if err != nil {
+		err = errors.Wrapf(err, "could not read config")
+		panic(err)
+	}
+
+	for _, tb := range conf.Tables {
+		if strings.ToLower(tb.TableName) == strings.ToLower(*tableToLookup) {
+			table = tb
+			break
+		}
+	}
+	if table == nil {
+		panic(fmt.Sprintf("table %v not found in config file", *tableToLookup))
+	}
+
+	table.readHeader([]byte(conf.HeaderColumnSeparatorChar))
+
+	dc, err := conf.dumperConfig(table)
+	if err != nil {
+		err = errors.Wrapf(err, "could not read dump config")
+		panic(err)
+	}
+
+	var wanted map[string][]uint64
+	if *lookupInput != "" {
+		wanted, err = readLookupInput(conf, *lookupInput)
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		wanted = make(map[string][]uint64)
+		for _, s := range strings.Split(*linesToLookup, ",") {
+			line, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				panic(fmt.Sprintf("line number %v is not valid: %v", s, err))
+			}
+			wanted[*fileToLookup] = append(wanted[*fileToLookup], line)
+		}
+	}
+
+	fileNames := make([]string, 0, len(wanted))
+	for fileName := range wanted {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+
+	dataFiles := table.dataFiles()
+	for _, fileName := range fileNames {
+		pathToFile, err := findDataFile(dataFiles, fileName)
+		if err != nil {
+			panic(errors.Wrapf(err, "could not lookup rows of %v", table.TableName))
+		}
+		err = table.lookupLines(dc, pathToFile, wanted[fileName])
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+//readLookupInput collects source file names and line numbers of rows found by JsonCheck
+func readLookupInput(conf *TableMaps, pathToFile string) (result map[string][]uint64, err error) {
+	dmp, err := dump.NewDumper(&dump.DumperConfigType{
+		ColumnSeparator: byte(conf.ResultColumnSeparatorByte),
+		LineSeparator:   dump.LineFeedByte,
+		Codec:           dump.CodecNone,
+		QuoteChar:       dump.DoubleQuoteByte,
+		EscapeStyle:     dump.EscapeDoubled,
+		//JsonCheck writes raw data rows which may span several lines
+		MultiLineRecords: true,
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "could not create dumper")
+		return
+	}
+	result = make(map[string][]uint64)
+	_, err = dmp.ReadFromFile(context.Background(), pathToFile, func(
+		cancelContext context.Context,
+		config *dump.DumperConfigType,
+		currentLineNumber uint64,
+		currentStreamPosition uint64,
+		cellsBytes [][]byte,
+		rawLineBytes []byte,
+	) (err error) {
+		if currentLineNumber == 0 && len(cellsBytes) > 0 && string(cellsBytes[0]) == "IOTahoe_file_name" {
+			return
+		}
+		if len(cellsBytes) < 4 {
+			return errors.Errorf("line %v of %v has less than 4 columns", currentLineNumber, pathToFile)
+		}
+		line, err := strconv.ParseUint(string(cellsBytes[3]), 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "line %v of %v has invalid GE_source_file_line", currentLineNumber, pathToFile)
+		}
+		fileName := string(cellsBytes[2])
+		result[fileName] = append(result[fileName], line)
+		return
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "could not read lookup input %v", pathToFile)
+		return nil, err
+	}
+	return
+}
+
+//findDataFile matches a path or a file name, as JsonCheck writes it, to a table data file
+func findDataFile(dataFiles []string, fileName string) (result string, err error) {
+	for _, pathToFile := range dataFiles {
+		_, name := split(pathToFile)
+		if pathToFile != fileName && name != fileName {
+			continue
+		}
+		if result != "" {
+			err = errors.Errorf("data file name %v is ambiguous: %v, %v", fileName, result, pathToFile)
+			return "", err
+		}
+		result = pathToFile
+	}
+	if result == "" {
+		err = errors.Errorf("data file %v not found", fileName)
+	}
+	return
+}
+
+//lookupLines prints rows of a data file with the given line numbers.
+//When the file has a seek index, every row is read starting from the closest line mark,
+//otherwise all rows are fetched in one pass
+func (t *TableMap) lookupLines(dc *dump.DumperConfigType, pathToFile string, lines []uint64) (err error) {
+	sort.Slice(lines, func(i, j int) bool { return lines[i] < lines[j] })
+	unique := lines[:0]
+	for index, line := range lines {
+		if index == 0 || line != lines[index-1] {
+			unique = append(unique, line)
+		}
+	}
+	lines = unique
+
+	index, err := dump.LoadIndex(pathToFile)
+	if err != nil {
+		return
+	}
+	batches := [][]uint64{lines}
+	if index != nil {
+		batches = make([][]uint64, 0, len(lines))
+		for i := range lines {
+			batches = append(batches, lines[i:i+1])
+		}
+	}
+
+	for _, batch := range batches {
+		config := *dc
+		config.StartFromLine = &dump.DumperStartFromLine{Line: batch[0]}
+		dmp, err := dump.NewDumper(&config)
+		if err != nil {
+			err = errors.Wrapf(err, "could not create dumper")
+			return err
+		}
+		next := 0
+		_, err = dmp.ReadFromFile(context.Background(), pathToFile, func(
+			cancelContext context.Context,
+			config *dump.DumperConfigType,
+			currentLineNumber uint64,
+			currentStreamPosition uint64,
+			cellsBytes [][]byte,
+			rawLineBytes []byte,
+		) (err error) {
+			if currentLineNumber != batch[next] {
+				return
+			}
+			t.printRow(pathToFile, currentLineNumber, currentStreamPosition, cellsBytes)
+			next++
+			if next == len(batch) {
+				return errLinesFound
+			}
+			return
+		})
+		if err != nil && err != errLinesFound {
+			err = errors.Wrapf(err, "could not read %v", pathToFile)
+			return err
+		}
+		for ; next < len(batch); next++ {
+			log.Printf("line %v not found in %v", batch[next], pathToFile)
+		}
+	}
+	return nil
+}
+
+//printRow writes cells of a row to stdout, one per line, prefixed by their column names
+func (t *TableMap) printRow(pathToFile string, lineNumber, streamPosition uint64, cellsBytes [][]byte) {
+	names := make([]string, len(cellsBytes))
+	width := 0
+	for index := range cellsBytes {
+		if index < len(t.headers) {
+			names[index] = strings.TrimSpace(string(t.headers[index]))
+		} else {
+			names[index] = fmt.Sprintf("#%v", index+1)
+		}
+		if len(names[index]) > width {
+			width = len(names[index])
+		}
+	}
+	fmt.Fprintf(os.Stdout, "%v, line %v, position %v:\n", pathToFile, lineNumber, streamPosition)
+	for index, cellBytes := range cellsBytes {
+		fmt.Fprintf(os.Stdout, "  %-*v : %s\n", width, names[index], cellBytes)
+	}
+	if len(cellsBytes) < len(t.headers) {
+		fmt.Fprintf(os.Stdout, "  %v column(s) missing\n", len(t.headers)-len(cellsBytes))
+	}
+	fmt.Fprintln(os.Stdout)
+}
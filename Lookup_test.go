@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ovlad32/geq/dump"
+)
+
+//captureStdout returns what f writes to stdout
+func captureStdout(t *testing.T, f func()) string {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(reader)
+		output <- string(data)
+	}()
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() {
+		os.Stdout = stdout
+	}()
+	f()
+	writer.Close()
+	return <-output
+}
+
+func TestReadLookupInput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lookup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pathToInput := path.Join(dir, "result.tsv")
+	input := strings.Join([]string{
+		`"IOTahoe_file_name"	"GE_key"	"GE_source_file_name"	"GE_source_file_line"	"raw"`,
+		`"x.json"	"k1"	"part1.gz"	"7"	"a"`,
+		`"x.json"	"k2"	"part2.gz"	"3"	"a ""multi-line`,
+		`raw"" row"`,
+		`"y.json"	"k3"	"part1.gz"	"2"	"b"`,
+	}, "\n") + "\n"
+	if err = ioutil.WriteFile(pathToInput, []byte(input), 0666); err != nil {
+		t.Fatal(err)
+	}
+	wanted, err := readLookupInput(&TableMaps{ResultColumnSeparatorByte: '\t'}, pathToInput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]uint64{"part1.gz": {7, 2}, "part2.gz": {3}}
+	if !reflect.DeepEqual(wanted, expected) {
+		t.Fatalf("lines %v wanted, %v expected", wanted, expected)
+	}
+
+	if err = ioutil.WriteFile(pathToInput, []byte("\"x.json\"\t\"k1\"\t\"part1.gz\"\t\"seven\"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = readLookupInput(&TableMaps{ResultColumnSeparatorByte: '\t'}, pathToInput); err == nil {
+		t.Fatalf("invalid GE_source_file_line is read")
+	}
+}
+
+func TestFindDataFile(t *testing.T) {
+	dataFiles := []string{"/data/a/part1.gz", "/data/a/part2.gz", "/data/b/part2.gz"}
+	tests := []struct {
+		fileName string
+		result   string
+	}{
+		{"part1.gz", "/data/a/part1.gz"},
+		{"/data/b/part2.gz", "/data/b/part2.gz"},
+		{"part2.gz", ""},
+		{"part3.gz", ""},
+	}
+	for _, test := range tests {
+		result, err := findDataFile(dataFiles, test.fileName)
+		if result != test.result || (err == nil) != (test.result != "") {
+			t.Errorf("%v found as %v: %v", test.fileName, result, err)
+		}
+	}
+}
+
+func TestLookupLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lookup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var data bytes.Buffer
+	zipped := gzip.NewWriter(&data)
+	for line := 0; line < 5000; line++ {
+		fmt.Fprintf(zipped, "\"%v\",\"value %v\"\n", line, strings.Repeat("x", line%100))
+	}
+	if err = zipped.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pathToFile := path.Join(dir, "part1.gz")
+	if err = ioutil.WriteFile(pathToFile, data.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	table := &TableMap{TableName: "t", headers: [][]byte{[]byte("id"), []byte(" name "), []byte("extra")}}
+	conf := &TableMaps{DataColumnSeparatorByte: ','}
+	dc, err := conf.dumperConfig(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			index, err := dump.BuildIndex(pathToFile, 4096, dump.LineFeedByte)
+			if err == nil {
+				err = index.Save(dump.IndexFileName(pathToFile))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		var err error
+		output := captureStdout(t, func() {
+			err = table.lookupLines(dc, pathToFile, []uint64{4321, 2, 4321, 0, 9999})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := strings.Split(output, pathToFile+", line ")
+		if len(rows) != 4 {
+			t.Fatalf("indexed %v: rows %q printed", indexed, output)
+		}
+		for index, line := range []int{0, 2, 4321} {
+			if !strings.HasPrefix(rows[index+1], fmt.Sprintf("%v, position ", line)) ||
+				!strings.Contains(rows[index+1], fmt.Sprintf("  id   : %v\n", line)) ||
+				!strings.Contains(rows[index+1], fmt.Sprintf("  name : value %v\n", strings.Repeat("x", line%100))) ||
+				!strings.Contains(rows[index+1], "  1 column(s) missing\n") {
+				t.Errorf("indexed %v: line %v printed as %q", indexed, line, rows[index+1])
+			}
+		}
+	}
+}
@@ -1,23 +1,442 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/ovlad32/geq/dump"
 	"github.com/pkg/errors"
 )
 
 var conn = flag.String("conn", "user=postgres password=postgres dbname=postgres host=localhost port=5432 sslmode=disable", "")
+var sinkName = flag.String("sink", "postgres", "")
 
 var targetTable = flag.String("targetTable", "", "")
 var sourceTable = flag.String("sourceTable", "", "")
+var pgInsert = flag.Bool("pginsert", false, "")
+var pgBatch = flag.Int("pgbatch", 0, "")
+var pgCreate = flag.Bool("pgcreate", false, "")
+var pgDrop = flag.Bool("pgdrop", false, "")
+var pgTruncate = flag.Bool("pgtruncate", false, "")
+var pgSample = flag.Int("pgsample", 10000, "")
+var pgTyped = flag.Bool("pgtyped", false, "")
+var pgNull = flag.String("pgnull", "", "")
+var pgWorkers = flag.Int("pgworkers", 1, "")
+var pgLoads = flag.String("pgloads", "", "")
+var pgFileColumn = flag.String("pgfilecolumn", "", "")
+var pgLineColumn = flag.String("pglinecolumn", "", "")
+var pgPositionColumn = flag.String("pgpositioncolumn", "", "")
+var pgBatchColumn = flag.String("pgbatchcolumn", "", "")
+var pgTimeColumn = flag.String("pgtimecolumn", "", "")
 
+//default numbers of rows committed in a transaction
+const insertBatch = 1000
+const copyBatch = 100000
+
+var errSampled = errors.New("enough rows sampled")
+
+//pgTypes map types inferred by columnProfileType and those of column_types to PostgreSQL ones
+var pgTypes = map[string]string{
+	"integer":   "bigint",
+	"numeric":   "numeric",
+	"date":      "date",
+	"timestamp": "timestamp",
+	"text":      "text",
+}
+
+//pgReservedWords are keywords which can't be column names unquoted
+var pgReservedWords = map[string]bool{
+	"all": true, "analyse": true, "analyze": true, "and": true, "any": true, "array": true, "as": true, "asc": true,
+	"asymmetric": true, "both": true, "case": true, "cast": true, "check": true, "collate": true, "column": true,
+	"constraint": true, "create": true, "current_date": true, "current_role": true, "current_time": true,
+	"current_timestamp": true, "current_user": true, "default": true, "deferrable": true, "desc": true,
+	"distinct": true, "do": true, "else": true, "end": true, "except": true, "false": true, "fetch": true,
+	"for": true, "foreign": true, "from": true, "grant": true, "group": true, "having": true, "in": true,
+	"initially": true, "intersect": true, "into": true, "lateral": true, "leading": true, "limit": true,
+	"localtime": true, "localtimestamp": true, "not": true, "null": true, "offset": true, "on": true,
+	"only": true, "or": true, "order": true, "placing": true, "primary": true, "references": true,
+	"returning": true, "select": true, "session_user": true, "some": true, "symmetric": true, "table": true,
+	"then": true, "to": true, "trailing": true, "true": true, "union": true, "unique": true, "user": true,
+	"using": true, "variadic": true, "when": true, "where": true, "window": true, "with": true,
+}
+
+var plainIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*$`)
+
+//pgColumnName quotes a header name unless it is a plain identifier,
+//which is left unquoted to be folded to lower case as it has always been
+func pgColumnName(name string) string {
+	if plainIdentifier.MatchString(name) && !pgReservedWords[strings.ToLower(name)] {
+		return name
+	}
+	return pq.QuoteIdentifier(name)
+}
+
+//inferColumnTypes profiles up to sample rows of every data file, all of them if sample is 0,
+//and returns types of columns in the header order
+func (t *TableMap) inferColumnTypes(dmp *dump.DumperType, sample int) (types []string, err error) {
+	profile := newTableProfile(len(t.headers), 1)
+	for _, pathToFile := range t.dataFiles() {
+		rows := 0
+		_, err = dmp.ReadFromFile(context.Background(), pathToFile, func(
+			cancelContext context.Context,
+			config *dump.DumperConfigType,
+			currentLineNumber uint64,
+			currentStreamPosition uint64,
+			cellsBytes [][]byte,
+			rawLineBytes []byte,
+		) error {
+			profile.add(cellsBytes)
+			rows++
+			if sample > 0 && rows >= sample {
+				return errSampled
+			}
+			return nil
+		})
+		if err != nil && err != errSampled {
+			return nil, errors.Wrapf(err, "could not sample %v", pathToFile)
+		}
+	}
+	for _, column := range profile.Columns {
+		types = append(types, column.inferredType())
+	}
+	return types, nil
+}
+
+//pgColumnTypes returns types of columns in the header order, those of column_types,
+//inferred from data if infer is set or text otherwise
+func (t *TableMap) pgColumnTypes(dmp *dump.DumperType, infer bool) (result []*pgColumnType, err error) {
+	result = make([]*pgColumnType, len(t.headers))
+	for index := range result {
+		result[index] = &pgColumnType{name: "text"}
+	}
+	if infer {
+		types, err := t.inferColumnTypes(dmp, *pgSample)
+		if err != nil {
+			return nil, err
+		}
+		for index, name := range types {
+			result[index].name = name
+		}
+	}
+	for name, spec := range t.ColumnTypes {
+		index, err := t.columnPosition(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "column_types of %v", t.TableName)
+		}
+		result[index], err = parsePgColumnType(spec)
+		if err != nil {
+			return nil, errors.Wrapf(err, "column_types of %v", t.TableName)
+		}
+	}
+	return
+}
+
+//prepareTarget drops, creates or truncates the target table as requested.
+//types are the sink types of columns, keys make the primary key of a table created
+func prepareTarget(db *sql.DB, sink sinkType, columns, types, keys []string) (err error) {
+	var statements []string
+	if *pgDrop {
+		statements = append(statements, fmt.Sprintf("drop table if exists %v", *targetTable))
+	}
+	if *pgCreate || *pgDrop {
+		definitions := make([]string, len(columns))
+		for index, column := range columns {
+			definitions[index] = column + " " + types[index]
+		}
+		if len(keys) > 0 {
+			definitions = append(definitions, fmt.Sprintf("primary key(%v)", strings.Join(keys, ", ")))
+		}
+		statements = append(statements, fmt.Sprintf(
+			"create table if not exists %v(\n\t%v\n)",
+			*targetTable,
+			strings.Join(definitions, ",\n\t"),
+		))
+	}
+	if *pgTruncate {
+		statements = append(statements, sink.truncate(*targetTable))
+	}
+	for _, statement := range statements {
+		log.Printf("%v", statement)
+		_, err = db.Exec(statement)
+		if err != nil {
+			return errors.Wrapf(err, "could not prepare target table %v", *targetTable)
+		}
+	}
+	return
+}
+
+//pgRowSourceType tells where a row being loaded comes from
+type pgRowSourceType struct {
+	//file is relative to path_to_data
+	file string
+	//line and position are the ones passed to the row processing function, as JsonCheck writes them
+	line     uint64
+	position uint64
+	//batch identifies the load, it is kept when the load is resumed
+	batch string
+	//loadedAt is the time the transaction has begun
+	loadedAt string
+}
+
+//pgProvenanceColumnType is a column of the target table telling where rows come from,
+//flag is the column name, the column is not loaded if it's empty
+type pgProvenanceColumnType struct {
+	flag       *string
+	columnType string
+	value      func(source *pgRowSourceType) string
+}
+
+var pgProvenanceColumns = []*pgProvenanceColumnType{
+	{pgFileColumn, "text", func(source *pgRowSourceType) string {
+		return source.file
+	}},
+	{pgLineColumn, "integer", func(source *pgRowSourceType) string {
+		return strconv.FormatUint(source.line, 10)
+	}},
+	{pgPositionColumn, "integer", func(source *pgRowSourceType) string {
+		return strconv.FormatUint(source.position, 10)
+	}},
+	{pgBatchColumn, "text", func(source *pgRowSourceType) string {
+		return source.batch
+	}},
+	{pgTimeColumn, "timestamp", func(source *pgRowSourceType) string {
+		return source.loadedAt
+	}},
+}
+
+//pgStatementsType are statements of a sink loading rows in a transaction
+type pgStatementsType struct {
+	//setup is executed when a transaction begins
+	setup string
+	//dml is prepared and executed for every row, it is a COPY FROM STDIN if copying
+	dml     string
+	copying bool
+	//apply is executed once the dml is finished
+	apply string
+}
+
+//pgBatchSize returns the number of rows committed in a transaction, -pgbatch if given or the default of the statements
+func pgBatchSize(batch int, statements *pgStatementsType) int {
+	if batch > 0 {
+		return batch
+	}
+	if statements.copying {
+		return copyBatch
+	}
+	return insertBatch
+}
+
+//pgLoaderType loads rows through a transaction of its own connection
+type pgLoaderType struct {
+	worker     int
+	db         *sql.DB
+	statements *pgStatementsType
+	tx         *sql.Tx
+	stmt       *sql.Stmt
+	values     []*sql.NullString
+	valueRefs  []interface{}
+	//pending are rows loaded per file since the transaction began
+	pending  map[string]uint64
+	rowCount int
+	//rows and files are loaded by the worker during the run
+	rows  uint64
+	files int
+	//loadedAt is the time the transaction has begun
+	loadedAt string
+	//loads, if given, records files of pending rows and files done with the transaction
+	loads *pgLoadsType
+	table *TableMap
+	mutex sync.Mutex
+	done  []string
+	//paths are files read by the worker
+	paths map[string]bool
+}
+
+func newPgLoader(worker int, db *sql.DB, statements *pgStatementsType, columns int) *pgLoaderType {
+	l := &pgLoaderType{
+		worker:     worker,
+		db:         db,
+		statements: statements,
+		values:     make([]*sql.NullString, columns),
+		valueRefs:  make([]interface{}, columns),
+	}
+	for index := range l.values {
+		l.values[index] = &sql.NullString{}
+		l.valueRefs[index] = l.values[index]
+	}
+	return l
+}
+
+//begin starts a transaction and prepares the statement
+func (l *pgLoaderType) begin() (err error) {
+	l.tx, err = l.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return errors.Wrapf(err, "worker %v could not begin a transaction", l.worker)
+	}
+	if l.statements.setup != "" {
+		_, err = l.tx.Exec(l.statements.setup)
+		if err != nil {
+			l.tx.Rollback()
+			l.tx = nil
+			return errors.Wrapf(err, "worker %v could not execute %v", l.worker, l.statements.setup)
+		}
+	}
+	l.stmt, err = l.tx.Prepare(l.statements.dml)
+	if err != nil {
+		l.tx.Rollback()
+		l.tx = nil
+		return errors.Wrapf(err, "worker %v could not prepare %v", l.worker, l.statements.dml)
+	}
+	l.pending = make(map[string]uint64)
+	l.rowCount = 0
+	l.loadedAt = time.Now().Format("2006-01-02 15:04:05.999999")
+	return
+}
+
+//load adds values to the transaction
+func (l *pgLoaderType) load(pathToFile string) (err error) {
+	_, err = l.stmt.Exec(l.valueRefs...)
+	if err != nil {
+		return errors.Wrapf(err, "worker %v could not load a row of %v", l.worker, pathToFile)
+	}
+	l.pending[pathToFile]++
+	l.rowCount++
+	return
+}
+
+//end finishes the statement, lib/pq reports errors of rows streamed through COPY here at the latest.
+//Rows loaded and files done are recorded then
+func (l *pgLoaderType) end() (err error) {
+	if l.statements.copying {
+		//Exec without arguments ends the stream
+		_, err = l.stmt.Exec()
+	}
+	if closeErr := l.stmt.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "worker %v could not finish %v", l.worker, l.statements.dml)
+	}
+	if l.statements.apply != "" {
+		_, err = l.tx.Exec(l.statements.apply)
+		if err != nil {
+			return errors.Wrapf(err, "worker %v could not execute %v", l.worker, l.statements.apply)
+		}
+	}
+	if l.loads == nil {
+		return
+	}
+	l.mutex.Lock()
+	done := l.done
+	l.done = nil
+	l.mutex.Unlock()
+	statuses := make(map[string]string)
+	for pathToFile := range l.pending {
+		statuses[pathToFile] = pgLoading
+	}
+	for _, pathToFile := range done {
+		statuses[pathToFile] = pgLoaded
+	}
+	for pathToFile, status := range statuses {
+		err = l.loads.record(l.tx, l.table, pathToFile, l.pending[pathToFile], status)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+//read makes the file to be read by the worker
+func (l *pgLoaderType) read(pathToFile string) {
+	l.mutex.Lock()
+	if l.paths == nil {
+		l.paths = make(map[string]bool)
+	}
+	l.paths[pathToFile] = true
+	l.files++
+	l.mutex.Unlock()
+}
+
+//reads tells if the file is read by the worker
+func (l *pgLoaderType) reads(pathToFile string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.paths[pathToFile]
+}
+
+//fileDone makes the file recorded as loaded with the transaction
+func (l *pgLoaderType) fileDone(pathToFile string) {
+	l.mutex.Lock()
+	l.done = append(l.done, pathToFile)
+	l.mutex.Unlock()
+}
+
+func (l *pgLoaderType) commit() (err error) {
+	err = l.tx.Commit()
+	l.tx = nil
+	if err != nil {
+		return errors.Wrapf(err, "worker %v could not commit", l.worker)
+	}
+	l.rows += uint64(l.rowCount)
+	return
+}
+
+//rollback closes the statement first, lib/pq doesn't take other commands until a COPY stream is ended
+func (l *pgLoaderType) rollback() {
+	if l.tx != nil {
+		l.stmt.Close()
+		l.tx.Rollback()
+		l.tx = nil
+	}
+}
+
+//writeReject writes the file name, the line number, the column and the reason followed by the raw line
+func writeReject(writer io.Writer, separator []byte, fileName string, lineNumber uint64, column string, reason error, rawLineBytes []byte) (err error) {
+	_, err = writer.Write(bytes.Join([][]byte{
+		[]byte(fileName),
+		[]byte(strconv.FormatUint(lineNumber, 10)),
+		[]byte(column),
+		[]byte(reason.Error()),
+		rawLineBytes,
+	}, separator))
+	if err == nil && !bytes.HasSuffix(rawLineBytes, []byte("\n")) {
+		_, err = writer.Write([]byte("\n"))
+	}
+	return
+}
+
+//Pgu loads a table into PostgreSQL streaming rows through COPY FROM STDIN, or with row by row INSERTs given -pginsert.
+//Another database may be chosen with -sink, like sqlite with the database file given by -conn.
+//Rows are committed every -pgbatch rows along with a checkpoint.
+//The target table may be dropped with -pgdrop, created with -pgcreate of types inferred by sampling -pgsample rows
+//of every data file, and truncated with -pgtruncate, unless the load is resumed.
+//Cells are converted to column_types of the table, or to inferred types with -pgtyped or when the table is created.
+//Cells equal to one of -pgnull markers, the empty string by default, are loaded as NULL.
+//Rows failing conversion are written to <table>.<targetTable>.rejected along with the reason.
+//Data files are distributed across -pgworkers connections, each loading in a transaction of its own.
+//At a checkpoint, transactions are committed one after another once all of them have ended without errors,
+//otherwise all of them are rolled back. The checkpoint is written after every commit, so when a commit fails
+//the load can be resumed from the rows committed.
+//Rows of the same key_columns of the table are updated rather than duplicated.
+//Loads are recorded in the -pgloads table, if given, so a new run skips files loaded before and unchanged,
+//and deletes rows of files loaded partially or changed by the -pgfilecolumn holding the data file name.
+//Where rows come from may be loaded to columns named by -pgfilecolumn, -pglinecolumn and -pgpositioncolumn,
+//their values match GE_source_file_name and GE_source_file_line of JsonCheck for files in path_to_data itself.
+//-pgbatchcolumn gets the id of the load kept when it is resumed, -pgtimecolumn the time the transaction of the row has begun
 func Pgu() {
 	if *sourceTable == "" {
 		panic("sourceTable is empty")
@@ -26,8 +445,28 @@ func Pgu() {
 	if *targetTable == "" {
 		panic("targetTable is empty")
 	}
+	if *pgBatch < 0 {
+		panic("pgbatch must not be negative")
+	}
+	if *pgWorkers < 1 {
+		panic("pgworkers must be positive")
+	}
+	sink, err := newSink(*sinkName)
+	if err != nil {
+		panic(err)
+	}
+	if !sink.concurrent() && *pgWorkers > 1 {
+		panic(fmt.Sprintf("%v sink is loaded by a single worker", *sinkName))
+	}
+	connGiven := false
+	flag.Visit(func(f *flag.Flag) {
+		connGiven = connGiven || f.Name == "conn"
+	})
+	if sink.driver() != "postgres" && !connGiven {
+		panic(fmt.Sprintf("specify -conn of %v sink", *sinkName))
+	}
 
-	db, err := sql.Open("postgres", *conn)
+	db, err := sql.Open(sink.driver(), *conn)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -49,13 +488,10 @@ func Pgu() {
 	}
 
 	table.readHeader([]byte(conf.HeaderColumnSeparatorChar))
-	dcs := byte(conf.DataColumnSeparatorByte)
-
-	dc := &dump.DumperConfigType{
-		ColumnSeparator: dcs,
-		LineSeparator:   dump.LineFeedByte,
-		GZip:            true,
-		BufferSize:      4096,
+	dc, err := conf.dumperConfig(table)
+	if err != nil {
+		err = errors.Wrapf(err, "could not read dump config")
+		panic(err)
 	}
 
 	dmp, err := dump.NewDumper(dc)
@@ -64,97 +500,270 @@ func Pgu() {
 		panic(err)
 	}
 	headers := make([]string, len(table.headers))
-	placeholders := make([]string, len(table.headers))
-	values := make([]*sql.NullString, 0, len(table.headers))
-	valueRefs := make([]interface{}, len(table.headers))
+	names := make([]string, len(table.headers))
 
 	for index := range table.headers {
-		headers[index] = string(table.headers[index])
-		v := sql.NullString{Valid: true}
-		values = append(values, &v)
-		valueRefs[index] = &v
-		placeholders[index] = fmt.Sprintf("$%v", index+1)
-	}
-	var tx *sql.Tx
-	var stmt *sql.Stmt
-	rowCount := 0
-
-	newTx := func() {
-		if tx != nil {
-			err = tx.Commit()
-			if err != nil {
-				log.Fatal(err)
-			}
+		names[index] = strings.TrimSpace(string(table.headers[index]))
+		headers[index] = sink.quote(names[index])
+	}
+	types, err := table.pgColumnTypes(dmp, *pgTyped || *pgCreate || *pgDrop)
+	if err != nil {
+		panic(err)
+	}
+	columns := append([]string(nil), headers...)
+	columnTypes := make([]string, 0, len(columns))
+	for _, columnType := range types {
+		columnTypes = append(columnTypes, sink.columnType(columnType.name))
+	}
+	var provenance []*pgProvenanceColumnType
+	for _, column := range pgProvenanceColumns {
+		if *column.flag != "" {
+			provenance = append(provenance, column)
+			columns = append(columns, sink.quote(*column.flag))
+			columnTypes = append(columnTypes, sink.columnType(column.columnType))
 		}
-		tx, err = db.BeginTx(context.Background(), nil)
+	}
+	fileColumn := ""
+	if *pgFileColumn != "" {
+		fileColumn = sink.quote(*pgFileColumn)
+	}
+	var keys []string
+	for _, key := range table.KeyColumns {
+		index, err := table.columnPosition(key)
 		if err != nil {
-			log.Fatal(err)
+			panic(errors.Wrapf(err, "key_columns of %v", table.TableName))
 		}
-		rowCount = 0
-		dml := fmt.Sprintf(
-			"insert into %v(%v) values(%v)",
-			*targetTable,
-			strings.Join(headers, ","),
-			strings.Join(placeholders, ","),
-		)
-		stmt, err = tx.Prepare(dml)
+		keys = append(keys, headers[index])
+	}
+	nullMarkers := parsePgNullMarkers(*pgNull)
+
+	type loadState struct {
+		RowsLoaded   uint64 `json:"rows_loaded"`
+		RowsRejected uint64 `json:"rows_rejected"`
+		Rejects      *int64 `json:"rejects"`
+		//Files are rows loaded per data file
+		Files map[string]uint64 `json:"files"`
+		Batch string            `json:"batch"`
+	}
+	state := &loadState{
+		Files: make(map[string]uint64),
+		Batch: time.Now().Format("20060102T150405.000000"),
+	}
+	cp, err := newCheckpoint("u."+*targetTable, table)
+	if err != nil {
+		panic(err)
+	}
+	err = cp.restoreState(state)
+	if err != nil {
+		panic(err)
+	}
+	var loads *pgLoadsType
+	if *pgLoads != "" {
+		loads, err = newPgLoads(db, sink, *pgLoads, *targetTable)
 		if err != nil {
-			log.Fatal(err.Error() + ": " + dml)
+			panic(err)
 		}
-
 	}
-	newTx()
-
-	var proc4Extract dump.RowProcessingFuncType = func(
-		cancelContext context.Context,
-		config *dump.DumperConfigType,
-		currentLineNumber uint64,
-		currentStreamPosition uint64,
-		cellsBytes [][]byte,
-		rawLineBytes []byte,
-	) (err error) {
-		maxIndex := len(cellsBytes) - 1
-		for index := range headers {
-			if index > maxIndex {
-				values[index].String = ""
-				continue
-			}
-			cb := cellsBytes[index]
-			if len(cb) < 2 {
-				values[index].String = ""
-				continue
+	if !cp.resumed() {
+		err = prepareTarget(db, sink, columns, columnTypes, keys)
+		if err == nil && loads != nil {
+			if *pgTruncate || *pgDrop {
+				err = loads.clear(db)
+			} else {
+				err = loads.skipLoaded(db, table, cp, fileColumn, len(keys) > 0)
 			}
-			values[index].String = strings.TrimSpace(string(cb[1 : len(cb)-1]))
 		}
-		_, err = stmt.Exec(valueRefs...)
 		if err != nil {
-			log.Fatal(err)
+			panic(err)
+		}
+	}
+
+	statements := sink.statements(*targetTable, columns, keys, *pgInsert)
+	batch := pgBatchSize(*pgBatch, statements)
+	loaders := make([]*pgLoaderType, *pgWorkers)
+	for worker := range loaders {
+		loaders[worker] = newPgLoader(worker, db, statements, len(columns))
+		loaders[worker].loads = loads
+		loaders[worker].table = table
+		err = loaders[worker].begin()
+		if err != nil {
+			panic(err)
 		}
-		rowCount++
-		if rowCount >= 1000 {
-			newTx()
+	}
+	var failure error
+	var failureMutex sync.Mutex
+	//fail records the first error of the workers and returns it, which stops their transactions from being committed
+	fail := func(err error) error {
+		failureMutex.Lock()
+		defer failureMutex.Unlock()
+		if failure == nil {
+			failure = err
 		}
+		return failure
+	}
 
+	pathToRejects := path.Join(*pfout, fmt.Sprintf("%v.%v.rejected", table.TableName, *targetTable))
+	var rejects io.WriteCloser
+	var rejectsMutex sync.Mutex
+	separator := []byte{byte(conf.ResultColumnSeparatorByte)}
+	reject := func(pathToFile string, lineNumber uint64, column int, reason error, rawLineBytes []byte) (err error) {
+		rejectsMutex.Lock()
+		defer rejectsMutex.Unlock()
+		if rejects == nil {
+			err = os.MkdirAll(*pfout, 0777)
+			if err == nil {
+				rejects, err = openOutput(pathToRejects, state.Rejects)
+			}
+			if err != nil {
+				return
+			}
+		}
+		_, fileName := split(pathToFile)
+		err = writeReject(rejects, separator, fileName, lineNumber, names[column], reason, rawLineBytes)
+		state.RowsRejected++
 		return
 	}
 
-	for _, filePath := range allFiles(table.PathToData, ".gz") {
+	//rows are committed right before the checkpoint is written, so a crash in between makes a resumed load
+	//insert rows of the last batch again. Rows are loaded at least once then, key_columns of the table make them upserted
+	//rather than duplicated.
+	//Statements of all the workers are ended before any commit, so rows failing on the server are not committed.
+	//The checkpoint is written after the commit of every worker with files of the workers not committed yet
+	//at their progress of the checkpoint written last. Rows of these files rejected since then are rejected again
+	//by a resumed load
+	cp.flush = func() (interface{}, error) {
+		if err := fail(nil); err != nil {
+			return nil, err
+		}
+		for _, l := range loaders {
+			if err := l.end(); err != nil {
+				return nil, fail(err)
+			}
+		}
+		for index, l := range loaders {
+			if err := l.commit(); err != nil {
+				return nil, fail(err)
+			}
+			for pathToFile, rows := range l.pending {
+				state.Files[pathToFile] += rows
+				state.RowsLoaded += rows
+			}
+			var err error
+			state.Rejects, err = outputSize(rejects)
+			if err == nil {
+				err = cp.saveFlushed(state, func(pathToFile string) bool {
+					for _, pending := range loaders[index+1:] {
+						if pending.reads(pathToFile) {
+							return true
+						}
+					}
+					return false
+				})
+			}
+			if err != nil {
+				return nil, fail(errors.Wrapf(err, "rows of worker %v are committed, but not saved in the checkpoint", l.worker))
+			}
+		}
+		for _, l := range loaders {
+			if err := l.begin(); err != nil {
+				return nil, fail(err)
+			}
+		}
+		return state, nil
+	}
 
-		log.Printf("%v...", filePath)
-		_, err = dmp.ReadFromFile(
-			context.Background(),
-			filePath,
-			proc4Extract,
-		)
+	newProcessor := func(worker int, pathToFile string) dump.RowProcessingFuncType {
+		l := loaders[worker]
+		l.read(pathToFile)
+		source := &pgRowSourceType{
+			file:  table.dataFileName(pathToFile),
+			batch: state.Batch,
+		}
+		return func(
+			cancelContext context.Context,
+			config *dump.DumperConfigType,
+			currentLineNumber uint64,
+			currentStreamPosition uint64,
+			cellsBytes [][]byte,
+			rawLineBytes []byte,
+		) (err error) {
+			for index := range headers {
+				var cell []byte
+				if index < len(cellsBytes) {
+					cell = bytes.TrimSpace(cellsBytes[index])
+				}
+				if nullMarkers[string(cell)] {
+					l.values[index].Valid = false
+					continue
+				}
+				l.values[index].Valid = true
+				l.values[index].String, err = types[index].convert(cell)
+				if err != nil {
+					return reject(pathToFile, currentLineNumber, index, err, rawLineBytes)
+				}
+			}
+			source.line, source.position, source.loadedAt = currentLineNumber, currentStreamPosition, l.loadedAt
+			for index, column := range provenance {
+				value := l.values[len(headers)+index]
+				value.String, value.Valid = column.value(source), true
+			}
+			err = l.load(pathToFile)
+			if err != nil {
+				return fail(err)
+			}
+			if l.rowCount >= batch {
+				cp.requestSave()
+			}
+			return
+		}
+	}
 
-		if err != nil {
-			err = errors.Wrapf(err, "could not read %v", filePath)
-			panic(err)
+	//a single worker loads rows in the file order, several ones read a file each
+	readers := *workers
+	if *pgWorkers > 1 {
+		readers = *pgWorkers
+	}
+	var fileDone func(worker int, pathToFile string)
+	if loads != nil {
+		fileDone = func(worker int, pathToFile string) {
+			loaders[worker].fileDone(pathToFile)
+		}
+	}
+	err = table.readDataByWorkers(dmp, *pgWorkers == 1, readers, cp, newProcessor, fileDone)
+	if err == nil {
+		for _, l := range loaders {
+			if err = l.end(); err == nil {
+				err = l.commit()
+			}
+			if err != nil {
+				break
+			}
 		}
 	}
-	err = tx.Commit()
 	if err != nil {
-		log.Fatal(err)
+		//nothing is loaded past the last checkpoint
+		for _, l := range loaders {
+			l.rollback()
+		}
+		panic(err)
+	}
+	if rejects != nil {
+		rejects.Close()
+	}
+	files := make([]string, 0, len(state.Files))
+	for pathToFile := range state.Files {
+		files = append(files, pathToFile)
+	}
+	sort.Strings(files)
+	for _, pathToFile := range files {
+		log.Printf("%v: %v row(s) loaded", pathToFile, state.Files[pathToFile])
+	}
+	for _, l := range loaders {
+		log.Printf("worker %v: %v row(s) of %v file(s) loaded", l.worker, l.rows, l.files)
+	}
+	log.Printf("%v row(s) loaded into %v", state.RowsLoaded, *targetTable)
+	if state.RowsRejected > 0 {
+		log.Printf("%v row(s) rejected to %v", state.RowsRejected, pathToRejects)
 	}
 	db.Close()
 
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ovlad32/geq/dump"
+)
+
+func TestPgBatchSize(t *testing.T) {
+	copying := postgresSink{}.statements("target", []string{"id"}, nil, false)
+	inserting := postgresSink{}.statements("target", []string{"id"}, nil, true)
+	if pgBatchSize(0, copying) != copyBatch || pgBatchSize(0, inserting) != insertBatch ||
+		pgBatchSize(5, copying) != 5 || pgBatchSize(5, inserting) != 5 {
+		t.Errorf("batch sizes %v, %v", pgBatchSize(0, copying), pgBatchSize(0, inserting))
+	}
+}
+
+//TestPgLoaderBatches checks rows of a batch are applied when the statement is ended
+//and are kept only once the batch is committed
+func TestPgLoaderBatches(t *testing.T) {
+	test := newSinkTest(t)
+	defer test.close()
+	test.prepare(t, true, false, nil)
+
+	//rows are staged and applied the way COPY of keyed rows is
+	statements := &pgStatementsType{
+		setup: `create temporary table stage(id, name, src)`,
+		dml:   `insert into stage values(?, ?, ?)`,
+		apply: `insert into target select id, name, src from stage; drop table stage`,
+	}
+	l := newPgLoader(0, test.db, statements, 3)
+	load := func(rows ...[3]string) {
+		for _, row := range rows {
+			for index, value := range row {
+				l.values[index].String, l.values[index].Valid = value, true
+			}
+			if err := l.load(row[2]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := l.begin(); err != nil {
+		t.Fatal(err)
+	}
+	load([3]string{"1", "a", "a.txt"}, [3]string{"2", "b", "a.txt"}, [3]string{"3", "c", "b.txt"})
+	if l.rowCount != 3 || l.pending["a.txt"] != 2 || l.pending["b.txt"] != 1 {
+		t.Errorf("%v row(s) pending %v", l.rowCount, l.pending)
+	}
+	err := l.end()
+	if err == nil {
+		err = l.commit()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = l.begin(); err != nil {
+		t.Fatal(err)
+	}
+	if l.rowCount != 0 || len(l.pending) != 0 {
+		t.Errorf("%v row(s) pending %v in a new batch", l.rowCount, l.pending)
+	}
+	load([3]string{"4", "d", "b.txt"})
+	if err = l.end(); err != nil {
+		t.Fatal(err)
+	}
+	l.rollback()
+
+	if rows := strings.Join(test.rows(t), ","); rows != "1:a,2:b,3:c" || l.rows != 3 {
+		t.Errorf("rows %v of %v loaded", rows, l.rows)
+	}
+}
+
+func TestPgColumnName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"id":         "id",
+		"Amount_1":   "Amount_1",
+		"order":      `"order"`,
+		"Select":     `"Select"`,
+		"first name": `"first name"`,
+		"1st":        `"1st"`,
+		`a"b`:        `"a""b"`,
+	} {
+		if quoted := pgColumnName(name); quoted != expected {
+			t.Errorf("%v is quoted as %v", name, quoted)
+		}
+	}
+}
+
+//pgTypesTest is a table of data files to infer column types from
+func pgTypesTest(t *testing.T) (table *TableMap, dmp *dump.DumperType, cleanup func()) {
+	dir, err := ioutil.TempDir("", "pgu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	table = &TableMap{
+		TableName:          "source",
+		PathToData:         dir,
+		DataFileExtensions: []string{".txt"},
+		headers:            [][]byte{[]byte("id"), []byte("amount"), []byte("created"), []byte("code"), []byte("note")},
+	}
+	files := map[string]string{
+		"a.txt": "1,2.5,2020-01-01,001,x\n2,3,2020-01-02 10:00:00,002\n",
+		"b.txt": "3,-4,2020-01-03,003,\n",
+	}
+	for name, data := range files {
+		if err == nil {
+			err = ioutil.WriteFile(path.Join(dir, name), []byte(data), 0666)
+		}
+	}
+	var dc *dump.DumperConfigType
+	if err == nil {
+		dc, err = (&TableMaps{DataColumnSeparatorByte: ','}).dumperConfig(table)
+	}
+	if err == nil {
+		dmp, err = dump.NewDumper(dc)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return table, dmp, func() { os.RemoveAll(dir) }
+}
+
+func TestInferColumnTypes(t *testing.T) {
+	table, dmp, cleanup := pgTypesTest(t)
+	defer cleanup()
+
+	types, err := table.inferColumnTypes(dmp, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"integer", "numeric", "timestamp", "text", "text"}; !reflect.DeepEqual(types, expected) {
+		t.Errorf("types %v inferred", types)
+	}
+	//the timestamp is past the first row of a.txt
+	types, err = table.inferColumnTypes(dmp, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"integer", "numeric", "date", "text", "text"}; !reflect.DeepEqual(types, expected) {
+		t.Errorf("types %v inferred from a row of every file", types)
+	}
+}
+
+func TestPgColumnTypes(t *testing.T) {
+	table, dmp, cleanup := pgTypesTest(t)
+	defer cleanup()
+	sample := *pgSample
+	defer func() {
+		*pgSample = sample
+	}()
+	*pgSample = 0
+
+	names := func(types []*pgColumnType) (result []string) {
+		for _, columnType := range types {
+			result = append(result, columnType.name+":"+columnType.layout)
+		}
+		return
+	}
+	types, err := table.pgColumnTypes(dmp, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"text:", "text:", "text:", "text:", "text:"}; !reflect.DeepEqual(names(types), expected) {
+		t.Errorf("types %v", names(types))
+	}
+
+	//column_types take precedence over types inferred
+	table.ColumnTypes = map[string]string{"AMOUNT": "numeric:,.", "code": "integer"}
+	types, err = table.pgColumnTypes(dmp, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"integer:", "numeric:,.", "timestamp:", "integer:", "text:"}; !reflect.DeepEqual(names(types), expected) {
+		t.Errorf("types %v", names(types))
+	}
+
+	for _, columnTypes := range []map[string]string{{"price": "numeric"}, {"id": "money"}} {
+		table.ColumnTypes = columnTypes
+		if _, err = table.pgColumnTypes(dmp, false); err == nil {
+			t.Errorf("column_types %v are taken", columnTypes)
+		}
+	}
+}
+
+func TestPrepareTargetDrop(t *testing.T) {
+	test := newSinkTest(t)
+	defer test.close()
+	test.prepare(t, true, false, nil)
+	test.load(t, nil, [3]string{"1", "a", "a.txt"})
+
+	table, created, dropped := *targetTable, *pgCreate, *pgDrop
+	defer func() {
+		*targetTable, *pgCreate, *pgDrop = table, created, dropped
+	}()
+	*targetTable, *pgCreate, *pgDrop = "target", false, true
+	columns := []string{test.sink.quote("id"), test.sink.quote("created")}
+	types := []string{test.sink.columnType("integer"), test.sink.columnType("date")}
+	if err := prepareTarget(test.db, test.sink, columns, types, columns[:1]); err != nil {
+		t.Fatal(err)
+	}
+	var definition string
+	err := test.db.QueryRow(`select sql from sqlite_master where name = 'target'`).Scan(&definition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if definition != "CREATE TABLE target(\n\t\"id\" integer,\n\t\"created\" text,\n\tprimary key(\"id\")\n)" {
+		t.Errorf("table created as %q", definition)
+	}
+}
+
+func TestWriteReject(t *testing.T) {
+	var rejects bytes.Buffer
+	columnType, _ := parsePgColumnType("integer")
+	_, reason := columnType.convert([]byte("x"))
+	err := writeReject(&rejects, []byte("\t"), "a.txt", 7, "id", reason, []byte("x,a\n"))
+	if err == nil {
+		err = writeReject(&rejects, []byte("\t"), "b.txt", 0, "id", reason, []byte("y,b"))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "a.txt\t7\tid\t\"x\" is not integer\tx,a\nb.txt\t0\tid\t\"x\" is not integer\ty,b\n"; rejects.String() != expected {
+		t.Errorf("rejects %q written", rejects.String())
+	}
+}
+
+func TestPgProvenanceColumns(t *testing.T) {
+	source := &pgRowSourceType{
+		file:     "sub/a.gz",
+		line:     7,
+		position: 120,
+		batch:    "20200101T000000.000000",
+		loadedAt: "2020-01-01 10:00:00.5",
+	}
+	expected := []struct {
+		flag       *string
+		columnType string
+		value      string
+	}{
+		{pgFileColumn, "text", "sub/a.gz"},
+		{pgLineColumn, "integer", "7"},
+		{pgPositionColumn, "integer", "120"},
+		{pgBatchColumn, "text", "20200101T000000.000000"},
+		{pgTimeColumn, "timestamp", "2020-01-01 10:00:00.5"},
+	}
+	if len(pgProvenanceColumns) != len(expected) {
+		t.Fatalf("%v provenance column(s)", len(pgProvenanceColumns))
+	}
+	for index, column := range pgProvenanceColumns {
+		if column.flag != expected[index].flag || column.columnType != expected[index].columnType ||
+			column.value(source) != expected[index].value {
+			t.Errorf("provenance column %v of type %v has %q", index, column.columnType, column.value(source))
+		}
+	}
+
+	//file names are relative to path_to_data, as JsonCheck writes them for files in path_to_data itself
+	table := &TableMap{PathToData: "/data/t/"}
+	for pathToFile, name := range map[string]string{"/data/t/a.gz": "a.gz", "/data/t/sub/a.gz": "sub/a.gz"} {
+		if fileName := table.dataFileName(pathToFile); fileName != name {
+			t.Errorf("%v is named %v", pathToFile, fileName)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ovlad32/geq/dump"
+	"github.com/ovlad32/geq/expr"
+	"github.com/ovlad32/geq/sketch"
+	"github.com/pkg/errors"
+)
+
+var tableToProfile = flag.String("pt", "", "")
+var profileTop = flag.Int("ptop", 10, "")
+
+//topCapacityFactor makes top-N values of a column to be chosen among more counters to improve their accuracy
+const topCapacityFactor = 10
+const minTopCapacity = 1000
+
+//columnProfileType accumulates statistics of column values
+type columnProfileType struct {
+	//Values is a number of rows having the column, Nulls is a number of rows too short to have it
+	Values uint64 `json:"values"`
+	Nulls  uint64 `json:"nulls"`
+	//Empty is a number of blank values
+	Empty     uint64 `json:"empty"`
+	MinLength int    `json:"min_length"`
+	MaxLength int    `json:"max_length"`
+	//Integers, Numbers, Dates are numbers of non-blank values recognized as such,
+	//Timestamps are dates having time of day
+	Integers   uint64 `json:"integers"`
+	Numbers    uint64 `json:"numbers"`
+	Dates      uint64 `json:"dates"`
+	Timestamps uint64 `json:"timestamps"`
+	//ZeroPadded is a number of numbers having leading zeros, which are codes rather than numbers
+	ZeroPadded uint64                  `json:"zero_padded"`
+	MinNumber  *float64                `json:"min_number,omitempty"`
+	MaxNumber  *float64                `json:"max_number,omitempty"`
+	MinTime    *time.Time              `json:"min_time,omitempty"`
+	MaxTime    *time.Time              `json:"max_time,omitempty"`
+	Distinct   *sketch.HyperLogLogType `json:"distinct"`
+	//Top is filled from top before the profile is saved
+	Top []sketch.TopItemType `json:"top,omitempty"`
+	top *sketch.TopKType
+}
+
+func newColumnProfile(topCapacity int, precision uint8) *columnProfileType {
+	distinct, _ := sketch.NewHyperLogLog(precision)
+	return &columnProfileType{
+		Distinct: distinct,
+		top:      sketch.NewTopK(topCapacity),
+	}
+}
+
+//restore rebuilds top values of a profile read from a checkpoint
+func (p *columnProfileType) restore(topCapacity int) {
+	if p.top == nil {
+		p.top = sketch.NewTopK(topCapacity)
+	}
+	p.top.Restore(p.Top)
+	p.Top = nil
+}
+
+//prepareSave fills Top to be saved with a checkpoint
+func (p *columnProfileType) prepareSave() {
+	p.Top = p.top.Top(0)
+}
+
+//add accounts a value, present is false when a row has no such column
+func (p *columnProfileType) add(value []byte, present bool) {
+	if !present {
+		p.Nulls++
+		return
+	}
+	p.Values++
+	trimmed := bytes.TrimSpace(value)
+	if len(trimmed) == 0 {
+		p.Empty++
+		return
+	}
+	nonEmpty := p.Values - p.Empty
+	if nonEmpty == 1 || len(value) < p.MinLength {
+		p.MinLength = len(value)
+	}
+	if len(value) > p.MaxLength {
+		p.MaxLength = len(value)
+	}
+	p.Distinct.Add(value)
+	p.top.Add(value)
+
+	//ParseFloat takes signed infinities and NaN, which are neither ordered nor written to JSON
+	if number, ok := expr.ParseNumber(trimmed); ok && !math.IsInf(number, 0) && !math.IsNaN(number) {
+		p.Numbers++
+		digits := bytes.TrimLeft(trimmed, "+-")
+		if len(digits) > 1 && digits[0] == '0' && digits[1] >= '0' && digits[1] <= '9' {
+			p.ZeroPadded++
+		}
+		if _, err := strconv.ParseInt(string(trimmed), 10, 64); err == nil {
+			p.Integers++
+		}
+		if p.MinNumber == nil {
+			p.MinNumber, p.MaxNumber = new(float64), new(float64)
+			*p.MinNumber, *p.MaxNumber = number, number
+		} else if number < *p.MinNumber {
+			*p.MinNumber = number
+		} else if number > *p.MaxNumber {
+			*p.MaxNumber = number
+		}
+	}
+	if t, ok := expr.ParseTime(trimmed); ok {
+		p.Dates++
+		if t.Hour() != 0 || t.Minute() != 0 || t.Second() != 0 || t.Nanosecond() != 0 {
+			p.Timestamps++
+		}
+		if p.MinTime == nil {
+			p.MinTime, p.MaxTime = new(time.Time), new(time.Time)
+			*p.MinTime, *p.MaxTime = t, t
+		} else if t.Before(*p.MinTime) {
+			*p.MinTime = t
+		} else if t.After(*p.MaxTime) {
+			*p.MaxTime = t
+		}
+	}
+}
+
+func (p *columnProfileType) merge(other *columnProfileType) {
+	if other.Values-other.Empty > 0 {
+		if p.Values-p.Empty == 0 || other.MinLength < p.MinLength {
+			p.MinLength = other.MinLength
+		}
+		if other.MaxLength > p.MaxLength {
+			p.MaxLength = other.MaxLength
+		}
+	}
+	p.Values += other.Values
+	p.Nulls += other.Nulls
+	p.Empty += other.Empty
+	p.Integers += other.Integers
+	p.Numbers += other.Numbers
+	p.Dates += other.Dates
+	p.Timestamps += other.Timestamps
+	p.ZeroPadded += other.ZeroPadded
+	if other.MinNumber != nil {
+		if p.MinNumber == nil {
+			p.MinNumber, p.MaxNumber = new(float64), new(float64)
+			*p.MinNumber, *p.MaxNumber = *other.MinNumber, *other.MaxNumber
+		}
+		if *other.MinNumber < *p.MinNumber {
+			*p.MinNumber = *other.MinNumber
+		}
+		if *other.MaxNumber > *p.MaxNumber {
+			*p.MaxNumber = *other.MaxNumber
+		}
+	}
+	if other.MinTime != nil {
+		if p.MinTime == nil {
+			p.MinTime, p.MaxTime = new(time.Time), new(time.Time)
+			*p.MinTime, *p.MaxTime = *other.MinTime, *other.MaxTime
+		}
+		if other.MinTime.Before(*p.MinTime) {
+			*p.MinTime = *other.MinTime
+		}
+		if other.MaxTime.After(*p.MaxTime) {
+			*p.MaxTime = *other.MaxTime
+		}
+	}
+	p.Distinct.Merge(other.Distinct)
+	p.top.Merge(other.top)
+}
+
+//inferredType returns the narrowest type of non-blank values: integer, numeric, date, timestamp or text.
+//Zero-padded numbers are text not to lose their zeros
+func (p *columnProfileType) inferredType() string {
+	nonEmpty := p.Values - p.Empty
+	switch {
+	case nonEmpty == 0:
+		return "text"
+	case p.ZeroPadded > 0 && p.Numbers == nonEmpty:
+		return "text"
+	case p.Integers == nonEmpty:
+		return "integer"
+	case p.Numbers == nonEmpty:
+		return "numeric"
+	case p.Dates == nonEmpty && p.Timestamps == 0:
+		return "date"
+	case p.Dates == nonEmpty:
+		return "timestamp"
+	}
+	return "text"
+}
+
+//tableProfileType is a profile of table columns in the header order
+type tableProfileType struct {
+	Rows    uint64               `json:"rows"`
+	Columns []*columnProfileType `json:"columns"`
+}
+
+func newTableProfile(columnCount, topCapacity int) *tableProfileType {
+	result := &tableProfileType{Columns: make([]*columnProfileType, columnCount)}
+	for index := range result.Columns {
+		result.Columns[index] = newColumnProfile(topCapacity, sketch.DefaultPrecision)
+	}
+	return result
+}
+
+func (p *tableProfileType) add(cellsBytes [][]byte) {
+	p.Rows++
+	for index, column := range p.Columns {
+		if index < len(cellsBytes) {
+			column.add(cellsBytes[index], true)
+		} else {
+			column.add(nil, false)
+		}
+	}
+}
+
+func (p *tableProfileType) merge(partial mergeableType) {
+	other := partial.(*tableProfileType)
+	p.Rows += other.Rows
+	for index, column := range p.Columns {
+		column.merge(other.Columns[index])
+	}
+}
+
+//columnReportType is a row of the profile report
+type columnReportType struct {
+	Column    string               `json:"column"`
+	Type      string               `json:"type"`
+	Values    uint64               `json:"values"`
+	Nulls     uint64               `json:"nulls"`
+	Empty     uint64               `json:"empty"`
+	Distinct  uint64               `json:"distinct"`
+	MinLength int                  `json:"min_length"`
+	MaxLength int                  `json:"max_length"`
+	Min       string               `json:"min,omitempty"`
+	Max       string               `json:"max,omitempty"`
+	Top       []sketch.TopItemType `json:"top"`
+}
+
+func (t *TableMap) profileReport(profile *tableProfileType, top int) (result []*columnReportType) {
+	for index, column := range profile.Columns {
+		result = append(result, column.report(strings.TrimSpace(string(t.headers[index])), top))
+	}
+	return
+}
+
+func (p *columnProfileType) report(name string, top int) *columnReportType {
+	report := &columnReportType{
+		Column:    name,
+		Type:      p.inferredType(),
+		Values:    p.Values,
+		Nulls:     p.Nulls,
+		Empty:     p.Empty,
+		Distinct:  p.Distinct.Count(),
+		MinLength: p.MinLength,
+		MaxLength: p.MaxLength,
+	}
+	//values of unreliable counts, inherited from values seen once, are not frequent
+	for _, item := range p.top.Top(top) {
+		if item.Count-item.Error > 1 {
+			report.Top = append(report.Top, item)
+		}
+	}
+	switch report.Type {
+	case "integer", "numeric":
+		report.Min = strconv.FormatFloat(*p.MinNumber, 'f', -1, 64)
+		report.Max = strconv.FormatFloat(*p.MaxNumber, 'f', -1, 64)
+	case "date":
+		report.Min = p.MinTime.Format("2006-01-02")
+		report.Max = p.MaxTime.Format("2006-01-02")
+	case "timestamp":
+		report.Min = p.MinTime.Format("2006-01-02 15:04:05.999999999")
+		report.Max = p.MaxTime.Format("2006-01-02 15:04:05.999999999")
+	}
+	//distinct count estimation error must not contradict exact top counts
+	if uint64(len(report.Top)) > report.Distinct {
+		report.Distinct = uint64(len(report.Top))
+	}
+	return report
+}
+
+//Profile scans a table once and reports statistics of every column to <table>.profile.json and <table>.profile.tsv
+func Profile() {
+	var table *TableMap = nil
+
+	if *tableToProfile == "" {
+		panic("specify table name to profile")
+	}
+	if *profileTop < 0 {
+		panic("number of top values must not be negative")
+	}
+
+	conf, err := readConfig()
+	if err != nil {
+		err = errors.Wrapf(err, "could not read config")
+		panic(err)
+	}
+
+	for _, tb := range conf.Tables {
+		if strings.ToLower(tb.TableName) == strings.ToLower(*tableToProfile) {
+			table = tb
+			break
+		}
+	}
+	if table == nil {
+		panic(fmt.Sprintf("table %v not found in config file", *tableToProfile))
+	}
+
+	table.readHeader([]byte(conf.HeaderColumnSeparatorChar))
+
+	dc, err := conf.dumperConfig(table)
+	if err != nil {
+		err = errors.Wrapf(err, "could not read dump config")
+		panic(err)
+	}
+
+	dmp, err := dump.NewDumper(dc)
+	if err != nil {
+		err = errors.Wrapf(err, "could not create dumper")
+		panic(err)
+	}
+
+	topCapacity := *profileTop * topCapacityFactor
+	if topCapacity < minTopCapacity {
+		topCapacity = minTopCapacity
+	}
+	total := newTableProfile(len(table.headers), topCapacity)
+
+	cp, err := newCheckpoint("profile", table)
+	if err != nil {
+		panic(err)
+	}
+	err = cp.restoreState(total)
+	if err != nil {
+		panic(err)
+	}
+	if len(total.Columns) != len(table.headers) {
+		panic(fmt.Sprintf("checkpoint has %v column(s), but header of %v has %v", len(total.Columns), table.TableName, len(table.headers)))
+	}
+	for _, column := range total.Columns {
+		column.restore(topCapacity)
+	}
+
+	err = table.readDataMerging(dmp, cp, total, func() mergeableType {
+		return newTableProfile(len(table.headers), topCapacity)
+	}, func() interface{} {
+		for _, column := range total.Columns {
+			column.prepareSave()
+		}
+		return total
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	report := table.profileReport(total, *profileTop)
+	err = os.MkdirAll(*pfout, 0777)
+	if err != nil {
+		panic(err)
+	}
+	pathToReport := path.Join(*pfout, table.TableName+".profile")
+	err = writeProfileJSON(pathToReport+".json", table.TableName, total.Rows, report)
+	if err == nil {
+		err = writeProfileTSV(pathToReport+".tsv", byte(conf.ResultColumnSeparatorByte), report)
+	}
+	if err != nil {
+		panic(err)
+	}
+	log.Printf("%v row(s) of %v profiled to %v.json and %v.tsv", total.Rows, table.TableName, pathToReport, pathToReport)
+}
+
+func writeProfileJSON(pathToFile string, tableName string, rows uint64, report []*columnReportType) (err error) {
+	data, err := json.MarshalIndent(struct {
+		Table   string              `json:"table"`
+		Rows    uint64              `json:"rows"`
+		Columns []*columnReportType `json:"columns"`
+	}{tableName, rows, report}, "", " ")
+	if err == nil {
+		err = ioutil.WriteFile(pathToFile, data, 0666)
+	}
+	return errors.Wrapf(err, "could not write profile %v", pathToFile)
+}
+
+func writeProfileTSV(pathToFile string, separator byte, report []*columnReportType) (err error) {
+	file, err := os.Create(pathToFile)
+	if err != nil {
+		return errors.Wrapf(err, "could not create profile %v", pathToFile)
+	}
+	writer := bufio.NewWriter(file)
+	sep := string(separator)
+	writer.WriteString(strings.Join([]string{
+		"column", "type", "values", "nulls", "empty", "distinct", "min_length", "max_length", "min", "max", "top",
+	}, sep) + "\n")
+	for _, column := range report {
+		top := make([]string, 0, len(column.Top))
+		for _, item := range column.Top {
+			top = append(top, fmt.Sprintf("%v:%v", item.Value, item.Count))
+		}
+		writer.WriteString(strings.Join([]string{
+			column.Column,
+			column.Type,
+			strconv.FormatUint(column.Values, 10),
+			strconv.FormatUint(column.Nulls, 10),
+			strconv.FormatUint(column.Empty, 10),
+			strconv.FormatUint(column.Distinct, 10),
+			strconv.Itoa(column.MinLength),
+			strconv.Itoa(column.MaxLength),
+			column.Min,
+			column.Max,
+			strings.Join(top, ";"),
+		}, sep) + "\n")
+	}
+	err = writer.Flush()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Wrapf(err, "could not write profile %v", pathToFile)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/ovlad32/geq/sketch"
+)
+
+func profileColumn(values ...string) *columnProfileType {
+	result := newColumnProfile(100, 10)
+	for _, value := range values {
+		result.add([]byte(value), true)
+	}
+	return result
+}
+
+func TestColumnProfile(t *testing.T) {
+	tests := []struct {
+		values   []string
+		typeName string
+		min, max string
+	}{
+		{[]string{"3", " -12 ", "", "7"}, "integer", "-12", "7"},
+		{[]string{"3", "2.5", "-1e3"}, "numeric", "-1000", "3"},
+		{[]string{"001", "002"}, "text", "", ""},
+		{[]string{"2020-05-01", "2019-01-31"}, "date", "2019-01-31", "2020-05-01"},
+		{[]string{"2020-05-01", "2020-05-01 10:30:00"}, "timestamp", "2020-05-01 00:00:00", "2020-05-01 10:30:00"},
+		{[]string{"", " "}, "text", "", ""},
+		//infinities and NaN are not numbers of a column
+		{[]string{"-inf", "+Infinity", "+NaN", "5", "-2"}, "text", "", ""},
+		{[]string{"5", "-2"}, "integer", "-2", "5"},
+	}
+	for _, test := range tests {
+		column := profileColumn(test.values...)
+		report := column.report("c", 10)
+		if report.Type != test.typeName || report.Min != test.min || report.Max != test.max {
+			t.Errorf("%q reported as %v of %v..%v", test.values, report.Type, report.Min, report.Max)
+		}
+	}
+
+	column := profileColumn("-inf", "+Infinity", "+NaN", "5", "-2", "5")
+	if column.Numbers != 3 || column.Integers != 3 || *column.MinNumber != -2 || *column.MaxNumber != 5 {
+		t.Errorf("%v number(s) profiled within %v..%v", column.Numbers, *column.MinNumber, *column.MaxNumber)
+	}
+	column.prepareSave()
+	if _, err := json.Marshal(column); err != nil {
+		t.Error(err)
+	}
+	if _, err := json.Marshal(column.report("c", 10)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTableProfileMerge(t *testing.T) {
+	rows := [][]string{{"1", "a"}, {"2", ""}, {"x"}, {"10", "a"}, {"-3", "b", "extra"}}
+	whole := newTableProfile(2, 100)
+	first, second := newTableProfile(2, 100), newTableProfile(2, 100)
+	for index, row := range rows {
+		cells := make([][]byte, len(row))
+		for column, cell := range row {
+			cells[column] = []byte(cell)
+		}
+		whole.add(cells)
+		if index < 2 {
+			first.add(cells)
+		} else {
+			second.add(cells)
+		}
+	}
+	first.merge(second)
+	table := &TableMap{headers: [][]byte{[]byte(" id "), []byte("name")}}
+	report := table.profileReport(first, 10)
+	if !reflect.DeepEqual(report, table.profileReport(whole, 10)) {
+		t.Errorf("merged profile differs from the whole one")
+	}
+	expected := []*columnReportType{
+		{Column: "id", Type: "text", Values: 5, Distinct: 5, MinLength: 1, MaxLength: 2},
+		{Column: "name", Type: "text", Values: 4, Nulls: 1, Empty: 1, Distinct: 2, MinLength: 1, MaxLength: 1,
+			Top: []sketch.TopItemType{{Value: "a", Count: 2}}},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		for _, column := range report {
+			t.Errorf("%+v", *column)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ovlad32/geq/dump"
+	"github.com/ovlad32/geq/expr"
+	"github.com/pkg/errors"
+)
+
+var tableToSelect = flag.String("st", "", "")
+var columnsToSelect = flag.String("sc", "", "")
+var selectExpression = flag.String("sx", "", "")
+var selectSeparator = flag.Int("ssep", -1, "")
+var selectNoHeader = flag.Bool("snoheader", false, "")
+var selectGzip = flag.Bool("sgz", false, "")
+var selectPartSize = flag.Int("spart", 0, "")
+
+//countingWriterType counts bytes written through it
+type countingWriterType struct {
+	writer io.Writer
+	count  int64
+}
+
+func (w *countingWriterType) Write(data []byte) (n int, err error) {
+	n, err = w.writer.Write(data)
+	w.count += int64(n)
+	return
+}
+
+//partWriterType writes rows to an output, or to numbered parts of it of about limit bytes each,
+//every part starts with the header. Compressed output is written as gzip members ended at every flush,
+//so its size saved with a checkpoint is a valid end of the file.
+//With columns given parts are Parquet files instead
+type partWriterType struct {
+	base    string
+	gzipped bool
+	limit   int64
+	header  []byte
+	columns []*outputColumnType
+	part    int
+	file    *os.File
+	counter *countingWriterType
+	gz      *gzip.Writer
+	parquet *parquetOutputType
+}
+
+func (w *partWriterType) path() string {
+	result := w.base
+	if w.limit > 0 {
+		result = fmt.Sprintf("%v.part%04d", result, w.part)
+	}
+	if w.gzipped {
+		result += ".gz"
+	}
+	if w.columns != nil {
+		result += ".parquet"
+	}
+	return result
+}
+
+//open opens the current part, state is the one saved with a checkpoint to continue the part
+func (w *partWriterType) open(state *outputStateType) (err error) {
+	if w.columns != nil {
+		output, err := newOutput(w.path(), w.columns, state)
+		if err == nil {
+			w.parquet = output.(*parquetOutputType)
+		}
+		return err
+	}
+	var size *int64
+	if state != nil {
+		size = state.Output
+	}
+	w.file, err = openOutput(w.path(), size)
+	if err != nil {
+		return
+	}
+	w.counter = &countingWriterType{writer: w.file}
+	if size != nil {
+		w.counter.count = *size
+	}
+	if w.counter.count == 0 && len(w.header) > 0 {
+		err = w.writeText(w.header)
+	}
+	return
+}
+
+//write writes a row, which is the line of a text part or the cells of a Parquet one
+func (w *partWriterType) write(row []byte, cells [][]byte) (err error) {
+	if w.columns != nil {
+		return w.writeParquet(cells)
+	}
+	if w.file == nil {
+		err = w.open(nil)
+		if err != nil {
+			return
+		}
+	}
+	err = w.writeText(row)
+	if err == nil && w.limit > 0 && w.counter.count >= w.limit {
+		err = w.Close()
+		w.part++
+	}
+	return
+}
+
+//writeText writes data to the open text part, starting a gzip member if there is none
+func (w *partWriterType) writeText(data []byte) (err error) {
+	var writer io.Writer = w.counter
+	if w.gzipped {
+		if w.gz == nil {
+			w.gz = gzip.NewWriter(w.counter)
+		}
+		writer = w.gz
+	}
+	_, err = writer.Write(data)
+	if err != nil {
+		err = errors.Wrapf(err, "could not write %v", w.path())
+	}
+	return
+}
+
+func (w *partWriterType) writeParquet(cells [][]byte) (err error) {
+	if w.parquet == nil {
+		err = w.open(nil)
+		if err != nil {
+			return
+		}
+	}
+	err = w.parquet.write(cells, nil)
+	if err == nil && w.limit > 0 && w.parquet.size() >= w.limit {
+		err = w.Close()
+		w.part++
+	}
+	return
+}
+
+//flush ends a gzip member or completes a Parquet part and returns the current part and its state,
+//which has no size if the part is not open
+func (w *partWriterType) flush() (part int, state *outputStateType, err error) {
+	state = &outputStateType{}
+	if w.parquet != nil {
+		state, err = w.parquet.flush()
+		return w.part, state, err
+	}
+	if w.gz != nil {
+		err = w.gz.Close()
+		w.gz = nil
+	}
+	if w.file != nil {
+		state.Output = new(int64)
+		*state.Output = w.counter.count
+	}
+	return w.part, state, err
+}
+
+//Close closes the current part
+func (w *partWriterType) Close() (err error) {
+	if w.parquet != nil {
+		err = w.parquet.Close()
+		w.parquet = nil
+		return
+	}
+	if w.file == nil {
+		return
+	}
+	_, _, err = w.flush()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	w.file = nil
+	if err != nil {
+		err = errors.Wrapf(err, "could not close %v", w.path())
+	}
+	return
+}
+
+//appendRow appends cells separated by separator as a line, quoting cells the way data files of dc are quoted
+func appendRow(row []byte, cells [][]byte, separator byte, dc *dump.DumperConfigType) ([]byte, error) {
+	for index, cell := range cells {
+		if index > 0 {
+			row = append(row, separator)
+		}
+		var ok bool
+		row, ok = dc.AppendCell(row, cell, separator)
+		if !ok {
+			return nil, errors.Errorf("value %q has the separator or a line break, it can't be written unquoted: set data_quote_char", cell)
+		}
+	}
+	return append(row, '\n'), nil
+}
+
+//Select writes columns listed by -sc, of rows matching the -sx expression if given, separated by -ssep or
+//result_column_separator_byte to <table>.select.<columns>, which may be gzip-compressed with -sgz
+//and split to parts of -spart megabytes. Cells are quoted the way data files are, if they need to.
+//With -format parquet parts are Parquet files of typed columns
+func Select() {
+	var table *TableMap = nil
+
+	if *tableToSelect == "" {
+		panic("specify table name to select data")
+	}
+	if *columnsToSelect == "" {
+		panic("specify columns to select")
+	}
+	if *selectPartSize < 0 {
+		panic("part size must not be negative")
+	}
+	if *selectGzip && parquetOutput() {
+		panic("Parquet output is compressed with -pqcompression rather than -sgz")
+	}
+
+	conf, err := readConfig()
+	if err != nil {
+		err = errors.Wrapf(err, "could not read config")
+		panic(err)
+	}
+
+	for _, tb := range conf.Tables {
+		if strings.ToLower(tb.TableName) == strings.ToLower(*tableToSelect) {
+			table = tb
+			break
+		}
+	}
+	if table == nil {
+		panic(fmt.Sprintf("table %v not found in config file", *tableToSelect))
+	}
+
+	table.readHeader([]byte(conf.HeaderColumnSeparatorChar))
+
+	columns, err := table.parseExtractColumns(*columnsToSelect, 1, 1)
+	if err != nil {
+		panic(err)
+	}
+	separator := byte(conf.ResultColumnSeparatorByte)
+	if *selectSeparator >= 0 {
+		separator = byte(*selectSeparator)
+	}
+	names := make([]string, 0, len(columns))
+	for _, column := range columns {
+		names = append(names, column.name)
+	}
+
+	outputName := "select." + shortName(strings.Join(names, "+"))
+	var matches func(cellsBytes [][]byte) bool
+	if *selectExpression != "" {
+		expression, err := expr.Compile(*selectExpression, &expr.ConfigType{
+			Columns: table.columnPosition,
+			Fusion:  conf.fusion(),
+		})
+		if err != nil {
+			panic(err)
+		}
+		outputName += ".fx." + shortHash(expression.String())
+		log.Printf("selecting rows of %v by %v", table.TableName, expression)
+		matches = expression.Match
+	}
+
+	dc, err := conf.dumperConfig(table)
+	if err != nil {
+		err = errors.Wrapf(err, "could not read dump config")
+		panic(err)
+	}
+
+	dmp, err := dump.NewDumper(dc)
+	if err != nil {
+		err = errors.Wrapf(err, "could not create dumper")
+		panic(err)
+	}
+
+	tableColumns, err := table.outputColumns(dmp)
+	if err != nil {
+		panic(err)
+	}
+	var outputColumns []*outputColumnType
+	var header []byte
+	if tableColumns != nil {
+		for _, column := range columns {
+			outputColumns = append(outputColumns, column.outputColumn(table, tableColumns))
+		}
+	} else {
+		//cells are quoted as they are in data files
+		if dc.QuoteChar != 0 && dc.QuoteChar == separator {
+			panic(fmt.Sprintf("output separator %q is the quote char of %v", separator, table.TableName))
+		}
+		if !*selectNoHeader {
+			titles := make([][]byte, 0, len(columns))
+			for _, column := range columns {
+				titles = append(titles, []byte(column.title(table)))
+			}
+			header, err = appendRow(nil, titles, separator, dc)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	type selectState struct {
+		Part int `json:"part"`
+		outputStateType
+		Rows uint64 `json:"rows"`
+	}
+	state := &selectState{Part: 1}
+	cp, err := newCheckpoint("s."+outputName+outputExtension(), table)
+	if err != nil {
+		panic(err)
+	}
+	err = cp.restoreState(state)
+	if err != nil {
+		panic(err)
+	}
+
+	err = os.MkdirAll(*pfout, 0777)
+	if err != nil {
+		panic(err)
+	}
+	writer := &partWriterType{
+		base:    path.Join(*pfout, table.TableName+"."+outputName),
+		gzipped: *selectGzip,
+		limit:   int64(*selectPartSize) << 20,
+		header:  header,
+		columns: outputColumns,
+		part:    state.Part,
+	}
+	if state.Output != nil {
+		err = writer.open(&state.outputStateType)
+		if err != nil {
+			panic(err)
+		}
+	}
+	cp.flush = func() (interface{}, error) {
+		part, outputState, err := writer.flush()
+		if err != nil {
+			return nil, err
+		}
+		state.Part, state.outputStateType = part, *outputState
+		return state, nil
+	}
+
+	fusionSeparator := []byte(conf.FusionSeparatorChar)
+	var row []byte
+	cells := make([][]byte, len(columns))
+	var proc4Select dump.RowProcessingFuncType = func(
+		cancelContext context.Context,
+		config *dump.DumperConfigType,
+		currentLineNumber uint64,
+		currentStreamPosition uint64,
+		cellsBytes [][]byte,
+		rawLineBytes []byte,
+	) (err error) {
+		if matches != nil && !matches(cellsBytes) {
+			return
+		}
+		for index, column := range columns {
+			cells[index] = column.value(cellsBytes, fusionSeparator)
+		}
+		if outputColumns == nil {
+			row, err = appendRow(row[:0], cells, separator, dc)
+			if err != nil {
+				return errors.Wrapf(err, "could not select line %v", currentLineNumber)
+			}
+		}
+		state.Rows++
+		return writer.write(row, cells)
+	}
+
+	err = table.readData(dmp, true, cp, func(string) dump.RowProcessingFuncType {
+		return proc4Select
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+	err = writer.Close()
+	if err != nil {
+		panic(err)
+	}
+	log.Printf("%v row(s) of %v selected to %v", state.Rows, table.TableName, writer.base)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/ovlad32/geq/dump"
+)
+
+func TestAppendRow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "select")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rows := [][]string{
+		{"id", "name", "note"},
+		{"1", "a\tb", "plain"},
+		{"2", "first line\nsecond line", `say "hi"`},
+		{"3", "", "x"},
+	}
+	dc, err := (&TableMaps{DataColumnSeparatorByte: ','}).dumperConfig(&TableMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	for _, row := range rows {
+		cells := make([][]byte, len(row))
+		for index, cell := range row {
+			cells[index] = []byte(cell)
+		}
+		data, err = appendRow(data, cells, '\t', dc)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := "id\tname\tnote\n1\t\"a\tb\"\tplain\n2\t\"first line\nsecond line\"\t\"say \"\"hi\"\"\"\n3\t\tx\n"
+	if string(data) != expected {
+		t.Fatalf("rows written as %q", data)
+	}
+
+	//rows are read back as they are by a dumper of the output separator
+	pathToFile := path.Join(dir, "t.select")
+	if err = ioutil.WriteFile(pathToFile, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	config := *dc
+	config.ColumnSeparator, config.Codec, config.MultiLineRecords = '\t', dump.CodecNone, true
+	dmp, err := dump.NewDumper(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read [][]string
+	_, err = dmp.ReadFromFile(context.Background(), pathToFile, func(
+		cancelContext context.Context,
+		config *dump.DumperConfigType,
+		currentLineNumber uint64,
+		currentStreamPosition uint64,
+		cellsBytes [][]byte,
+		rawLineBytes []byte,
+	) (err error) {
+		row := make([]string, len(cellsBytes))
+		for index, cell := range cellsBytes {
+			row[index] = string(cell)
+		}
+		read = append(read, row)
+		return
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, rows) {
+		t.Errorf("rows %q read back", read)
+	}
+
+	//with quoting off cells needing quotes are rejected
+	dc.QuoteChar = 0
+	if _, err = appendRow(nil, [][]byte{[]byte("1"), []byte("a\tb")}, '\t', dc); err == nil {
+		t.Errorf("cell of the separator is written unquoted")
+	}
+	if _, err = appendRow(nil, [][]byte{[]byte("a\nb")}, '\t', dc); err == nil {
+		t.Errorf("cell of a line break is written unquoted")
+	}
+	if data, err = appendRow(nil, [][]byte{[]byte(`"a"`), []byte("b")}, '\t', dc); err != nil || string(data) != "\"a\"\tb\n" {
+		t.Errorf("row written as %q: %v", data, err)
+	}
+}
+
+func readGzipped(t *testing.T, pathToFile string) string {
+	data, err := ioutil.ReadFile(pathToFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+//TestPartWriterResume checks a gzipped output cut to the size of the last flush is continued with a new gzip member
+func TestPartWriterResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "select")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newWriter := func() *partWriterType {
+		return &partWriterType{base: path.Join(dir, "t.select"), gzipped: true, header: []byte("id\n"), part: 1}
+	}
+	writer := newWriter()
+	for _, row := range []string{"1\n", "2\n"} {
+		if err = writer.write([]byte(row), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	part, state, err := writer.flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if part != 1 || state.Output == nil {
+		t.Fatalf("part %v of size %v flushed", part, state.Output)
+	}
+	//rows written after the flush are lost with an unfinished gzip member
+	if err = writer.write([]byte("lost\n"), nil); err != nil {
+		t.Fatal(err)
+	}
+	writer.file.Close()
+
+	writer = newWriter()
+	if err = writer.open(state); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []string{"3\n", "4\n"} {
+		if err = writer.write([]byte(row), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data := readGzipped(t, writer.path()); data != "id\n1\n2\n3\n4\n" {
+		t.Errorf("resumed output has %q", data)
+	}
+	if path.Base(writer.path()) != "t.select.gz" {
+		t.Errorf("output is named %v", writer.path())
+	}
+}
+
+func TestPartWriterParts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "select")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, gzipped := range []bool{false, true} {
+		//every row fills a part up
+		writer := &partWriterType{base: path.Join(dir, "t.select"), gzipped: gzipped, limit: 4, header: []byte("id\n"), part: 1}
+		for _, row := range []string{"1\n", "2\n", "3\n"} {
+			if err = writer.write([]byte(row), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if writer.part != 4 {
+			t.Errorf("gzipped %v: part %v is current", gzipped, writer.part)
+		}
+		for part, row := range []string{"1\n", "2\n", "3\n"} {
+			writer.part = part + 1
+			var data string
+			if gzipped {
+				data = readGzipped(t, writer.path())
+			} else {
+				content, err := ioutil.ReadFile(writer.path())
+				if err != nil {
+					t.Fatal(err)
+				}
+				data = string(content)
+			}
+			if data != "id\n"+row {
+				t.Errorf("%v has %q", writer.path(), data)
+			}
+		}
+		if _, err = os.Stat(path.Join(dir, "t.select.part0004")); !os.IsNotExist(err) {
+			t.Errorf("gzipped %v: part not written is created: %v", gzipped, err)
+		}
+	}
+}
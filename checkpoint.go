@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ovlad32/geq/dump"
+	"github.com/pkg/errors"
+)
+
+var resume = flag.Bool("resume", false, "")
+var checkpointInterval = flag.Duration("cpinterval", time.Minute, "")
+
+type fileCheckpoint struct {
+	LineNumber     uint64 `json:"line_number"`
+	StreamPosition uint64 `json:"stream_position"`
+}
+
+//checkpoint keeps progress of a table scan persisted in the output directory.
+//Rows are processed under a read lock, so saving a checkpoint waits for rows being processed
+//and the command state flushed along with the checkpoint is consistent with the progress recorded
+type checkpoint struct {
+	Command        string                     `json:"command"`
+	TableName      string                     `json:"table_name"`
+	CompletedFiles map[string]uint64          `json:"completed_files"`
+	Files          map[string]*fileCheckpoint `json:"files"`
+	State          json.RawMessage            `json:"state,omitempty"`
+	SavedAt        time.Time                  `json:"saved_at"`
+
+	pathToFile    string
+	lock          sync.RWMutex
+	saveRequested int32
+	//flush makes the command output durable and returns the command state to store
+	flush func() (state interface{}, err error)
+	//savedFiles and savedCompletedFiles are progress of files of the checkpoint written last
+	savedFiles          map[string]*fileCheckpoint
+	savedCompletedFiles map[string]uint64
+}
+
+//newCheckpoint reads a checkpoint of the previous run of the command when -resume is given
+func newCheckpoint(command string, t *TableMap) (cp *checkpoint, err error) {
+	cp = &checkpoint{
+		Command:        command,
+		TableName:      t.TableName,
+		CompletedFiles: make(map[string]uint64),
+		Files:          make(map[string]*fileCheckpoint),
+		pathToFile:     path.Join(*pfout, fmt.Sprintf("%v.%v.checkpoint.json", t.TableName, command)),
+	}
+	if !*resume {
+		cp.keepSaved(cp.Files, cp.CompletedFiles)
+		return cp, nil
+	}
+	data, err := ioutil.ReadFile(cp.pathToFile)
+	if os.IsNotExist(err) {
+		log.Printf("checkpoint %v not found, starting from scratch", cp.pathToFile)
+		cp.keepSaved(cp.Files, cp.CompletedFiles)
+		return cp, nil
+	} else if err != nil {
+		err = errors.Wrapf(err, "could not read checkpoint %v", cp.pathToFile)
+		return nil, err
+	}
+	err = json.Unmarshal(data, cp)
+	if err != nil {
+		err = errors.Wrapf(err, "could not decode checkpoint %v", cp.pathToFile)
+		return nil, err
+	}
+	if cp.Command != command || cp.TableName != t.TableName {
+		err = errors.Errorf("checkpoint %v belongs to command %v on %v",
+			cp.pathToFile, cp.Command, cp.TableName)
+		return nil, err
+	}
+	log.Printf("resuming from checkpoint %v saved at %v: %v file(s) completed, %v in progress",
+		cp.pathToFile, cp.SavedAt, len(cp.CompletedFiles), len(cp.Files))
+	cp.keepSaved(cp.Files, cp.CompletedFiles)
+	return cp, nil
+}
+
+//resumed tells if a command state has been restored from a previous run
+func (cp *checkpoint) resumed() bool {
+	return len(cp.State) > 0
+}
+
+//restoreState decodes the command state saved by the previous run
+func (cp *checkpoint) restoreState(state interface{}) (err error) {
+	if !cp.resumed() {
+		return nil
+	}
+	err = json.Unmarshal(cp.State, state)
+	if err != nil {
+		err = errors.Wrapf(err, "could not decode state of checkpoint %v", cp.pathToFile)
+	}
+	return
+}
+
+//pendingFiles filters out files completed by the previous run
+func (cp *checkpoint) pendingFiles(files []string) (result []string) {
+	result = make([]string, 0, len(files))
+	for _, pathToFile := range files {
+		if _, completed := cp.CompletedFiles[pathToFile]; !completed {
+			result = append(result, pathToFile)
+		}
+	}
+	return
+}
+
+func (cp *checkpoint) startPositions() map[string]*dump.DumperStartFromByte {
+	result := make(map[string]*dump.DumperStartFromByte)
+	for pathToFile, progress := range cp.Files {
+		result[pathToFile] = &dump.DumperStartFromByte{
+			Position:  int(progress.StreamPosition),
+			FirstLine: progress.LineNumber,
+		}
+	}
+	return result
+}
+
+//track records progress of a file after each row processed
+func (cp *checkpoint) track(pathToFile string, rowProcessingFunc dump.RowProcessingFuncType) dump.RowProcessingFuncType {
+	cp.lock.Lock()
+	progress, found := cp.Files[pathToFile]
+	if !found {
+		progress = &fileCheckpoint{}
+		cp.Files[pathToFile] = progress
+	}
+	cp.lock.Unlock()
+
+	return func(
+		cancelContext context.Context,
+		config *dump.DumperConfigType,
+		currentLineNumber uint64,
+		currentStreamPosition uint64,
+		cellsBytes [][]byte,
+		rawLineBytes []byte,
+	) (err error) {
+		cp.lock.RLock()
+		err = rowProcessingFunc(cancelContext, config, currentLineNumber, currentStreamPosition, cellsBytes, rawLineBytes)
+		if err == nil {
+			progress.LineNumber = currentLineNumber + 1
+			progress.StreamPosition = currentStreamPosition + uint64(len(rawLineBytes))
+		}
+		cp.lock.RUnlock()
+		if err == nil && cp.takeSaveRequest() {
+			err = cp.save()
+		}
+		return
+	}
+}
+
+//requestSave makes a checkpoint to be saved right after the row being processed
+func (cp *checkpoint) requestSave() {
+	atomic.StoreInt32(&cp.saveRequested, 1)
+}
+
+func (cp *checkpoint) takeSaveRequest() bool {
+	return atomic.CompareAndSwapInt32(&cp.saveRequested, 1, 0)
+}
+
+//fileDone marks the file completed
+func (cp *checkpoint) fileDone(pathToFile string, lineCount uint64) {
+	cp.lock.Lock()
+	delete(cp.Files, pathToFile)
+	cp.CompletedFiles[pathToFile] = lineCount
+	cp.lock.Unlock()
+}
+
+//save waits for rows being processed, flushes the command state and writes the checkpoint
+func (cp *checkpoint) save() (err error) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	if cp.flush != nil {
+		var state interface{}
+		state, err = cp.flush()
+		if err != nil {
+			err = errors.Wrapf(err, "could not flush state for checkpoint %v", cp.pathToFile)
+			return
+		}
+		cp.State, err = json.Marshal(state)
+		if err != nil {
+			err = errors.Wrapf(err, "could not encode state for checkpoint %v", cp.pathToFile)
+			return
+		}
+	}
+	return cp.write(cp.Files, cp.CompletedFiles)
+}
+
+//saveFlushed writes the checkpoint from flush, which has made only a part of the command output durable so far.
+//Files of rows not durable yet, told by pending, are kept at their progress of the checkpoint written last
+func (cp *checkpoint) saveFlushed(state interface{}, pending func(pathToFile string) bool) (err error) {
+	cp.State, err = json.Marshal(state)
+	if err != nil {
+		return errors.Wrapf(err, "could not encode state for checkpoint %v", cp.pathToFile)
+	}
+	files := make(map[string]*fileCheckpoint)
+	completedFiles := make(map[string]uint64)
+	for pathToFile, progress := range cp.Files {
+		if !pending(pathToFile) {
+			files[pathToFile] = progress
+		}
+	}
+	for pathToFile, lineCount := range cp.CompletedFiles {
+		if !pending(pathToFile) {
+			completedFiles[pathToFile] = lineCount
+		}
+	}
+	for pathToFile, progress := range cp.savedFiles {
+		if pending(pathToFile) {
+			files[pathToFile] = progress
+		}
+	}
+	for pathToFile, lineCount := range cp.savedCompletedFiles {
+		if pending(pathToFile) {
+			completedFiles[pathToFile] = lineCount
+		}
+	}
+	return cp.write(files, completedFiles)
+}
+
+//write replaces the checkpoint file with the one of progress of files given
+func (cp *checkpoint) write(files map[string]*fileCheckpoint, completedFiles map[string]uint64) (err error) {
+	cp.SavedAt = time.Now()
+	data, err := json.MarshalIndent(&checkpoint{
+		Command:        cp.Command,
+		TableName:      cp.TableName,
+		CompletedFiles: completedFiles,
+		Files:          files,
+		State:          cp.State,
+		SavedAt:        cp.SavedAt,
+	}, "", " ")
+	if err != nil {
+		err = errors.Wrapf(err, "could not encode checkpoint %v", cp.pathToFile)
+		return
+	}
+	err = os.MkdirAll(*pfout, 0777)
+	if err != nil {
+		return
+	}
+	tmp := cp.pathToFile + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0666)
+	if err != nil {
+		err = errors.Wrapf(err, "could not write checkpoint %v", tmp)
+		return
+	}
+	err = os.Rename(tmp, cp.pathToFile)
+	if err != nil {
+		err = errors.Wrapf(err, "could not replace checkpoint %v", cp.pathToFile)
+		return
+	}
+	cp.keepSaved(files, completedFiles)
+	return
+}
+
+//keepSaved copies progress of files written, as progress is updated in place while rows are processed
+func (cp *checkpoint) keepSaved(files map[string]*fileCheckpoint, completedFiles map[string]uint64) {
+	cp.savedFiles = make(map[string]*fileCheckpoint, len(files))
+	for pathToFile, progress := range files {
+		saved := *progress
+		cp.savedFiles[pathToFile] = &saved
+	}
+	cp.savedCompletedFiles = make(map[string]uint64, len(completedFiles))
+	for pathToFile, lineCount := range completedFiles {
+		cp.savedCompletedFiles[pathToFile] = lineCount
+	}
+}
+
+//saveRegularly saves the checkpoint every -cpinterval until stop is closed
+func (cp *checkpoint) saveRegularly(stop <-chan struct{}) {
+	if *checkpointInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(*checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := cp.save(); err != nil {
+				log.Printf("%v", err)
+			}
+		}
+	}
+}
+
+//openOutput creates an output file or, when resuming, opens the existing one cut to the size saved
+func openOutput(pathToFile string, size *int64) (file *os.File, err error) {
+	if size == nil {
+		return os.Create(pathToFile)
+	}
+	file, err = os.OpenFile(pathToFile, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return
+	}
+	err = file.Truncate(*size)
+	if err == nil {
+		_, err = file.Seek(*size, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		err = errors.Wrapf(err, "could not restore output %v to size %v", pathToFile, *size)
+		return nil, err
+	}
+	return
+}
+
+//outputSize returns a number of bytes written to an output file, nil for stdout or no output
+func outputSize(writer io.Writer) (*int64, error) {
+	file, ok := writer.(*os.File)
+	if !ok || file == os.Stdout {
+		return nil, nil
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	return &size, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+//TestCheckpointSaveFlushed checks files pending a commit are saved at their progress of the last checkpoint
+func TestCheckpointSaveFlushed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	*pfout = dir
+
+	cp, err := newCheckpoint("u.target", &TableMap{TableName: "source"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.Files["a"] = &fileCheckpoint{LineNumber: 10, StreamPosition: 100}
+	cp.Files["b"] = &fileCheckpoint{LineNumber: 20, StreamPosition: 200}
+	if err = cp.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	cp.Files["a"].LineNumber, cp.Files["a"].StreamPosition = 11, 110
+	cp.Files["b"].LineNumber, cp.Files["b"].StreamPosition = 21, 210
+	cp.fileDone("a", 12)
+	cp.Files["c"] = &fileCheckpoint{LineNumber: 30, StreamPosition: 300}
+	cp.fileDone("d", 40)
+	pending := map[string]bool{"a": true, "c": true}
+	err = cp.saveFlushed(map[string]int{"rows": 1}, func(pathToFile string) bool {
+		return pending[pathToFile]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(cp.pathToFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := &checkpoint{}
+	if err = json.Unmarshal(data, saved); err != nil {
+		t.Fatal(err)
+	}
+	if progress := saved.Files["a"]; progress == nil || progress.LineNumber != 10 || progress.StreamPosition != 100 {
+		t.Errorf("pending file a saved at %+v, its last progress expected", progress)
+	}
+	if _, found := saved.CompletedFiles["a"]; found {
+		t.Errorf("pending file a saved completed")
+	}
+	if progress := saved.Files["b"]; progress == nil || progress.LineNumber != 21 {
+		t.Errorf("committed file b saved at %+v", progress)
+	}
+	if _, found := saved.Files["c"]; found {
+		t.Errorf("pending file c started since the last checkpoint is saved")
+	}
+	if saved.CompletedFiles["d"] != 40 {
+		t.Errorf("committed file d is not saved completed")
+	}
+	state := make(map[string]int)
+	if err = json.Unmarshal(saved.State, &state); err != nil || state["rows"] != 1 {
+		t.Errorf("state %s saved", saved.State)
+	}
+
+	//the checkpoint written last is the base of the next one
+	err = cp.saveFlushed(nil, func(pathToFile string) bool {
+		return pathToFile == "b"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadFile(cp.pathToFile)
+	if err == nil {
+		saved = &checkpoint{}
+		err = json.Unmarshal(data, saved)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress := saved.Files["b"]; progress == nil || progress.LineNumber != 21 {
+		t.Errorf("pending file b saved at %+v, its last progress expected", progress)
+	}
+	if saved.CompletedFiles["a"] != 12 {
+		t.Errorf("committed file a is not saved completed")
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ovlad32/geq/sketch"
+	"github.com/pkg/errors"
+)
+
+//valueCountOverhead approximates memory taken by a map entry besides the value itself
+const valueCountOverhead = 64
+
+//maxMergeRuns is a number of run files merged at once
+const maxMergeRuns = 64
+
+//valueCountsType counts occurrences of distinct values.
+//Counts exceeding the memory budget are spilled to run files sorted by value, which are merged at the end
+type valueCountsType struct {
+	budget int
+	size   int
+	counts map[string]*uint64
+	runs   *countRunsType
+}
+
+//newValueCounts starts counting within budget bytes, spilling to dir.
+//files are runs spilled before the checkpoint being resumed, the other files of dir are removed
+func newValueCounts(dir string, budget int, files []string) (result *valueCountsType, err error) {
+	err = os.MkdirAll(dir, 0777)
+	if err != nil {
+		err = errors.Wrapf(err, "could not create spill directory %v", dir)
+		return
+	}
+	kept := make(map[string]bool)
+	for _, file := range files {
+		kept[file] = true
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		err = errors.Wrapf(err, "could not read spill directory %v", dir)
+		return
+	}
+	for _, entry := range entries {
+		if !kept[entry.Name()] {
+			err = os.Remove(path.Join(dir, entry.Name()))
+			if err != nil {
+				return
+			}
+		}
+	}
+	result = &valueCountsType{
+		budget: budget,
+		counts: make(map[string]*uint64),
+		runs: &countRunsType{
+			dir:    dir,
+			prefix: "v",
+			less:   byValue,
+			names:  append([]string(nil), files...),
+		},
+	}
+	return
+}
+
+func byValue(a, b *sketch.TopItemType) bool {
+	return a.Value < b.Value
+}
+
+//byFrequency orders the most frequent values first
+func byFrequency(a, b *sketch.TopItemType) bool {
+	if a.Count != b.Count {
+		return a.Count > b.Count
+	}
+	return a.Value < b.Value
+}
+
+func (c *valueCountsType) add(value []byte) (err error) {
+	if count, found := c.counts[string(value)]; found {
+		*count++
+		return
+	}
+	count := new(uint64)
+	*count = 1
+	c.counts[string(value)] = count
+	c.size += len(value) + valueCountOverhead
+	if c.size > c.budget {
+		err = c.spill()
+	}
+	return
+}
+
+//spill writes counts in memory to a run file, nothing is written if no value has been added since the last spill
+func (c *valueCountsType) spill() (err error) {
+	if len(c.counts) == 0 {
+		return
+	}
+	err = c.runs.spill(c.items())
+	c.counts = make(map[string]*uint64)
+	c.size = 0
+	return
+}
+
+func (c *valueCountsType) items() []sketch.TopItemType {
+	result := make([]sketch.TopItemType, 0, len(c.counts))
+	for value, count := range c.counts {
+		result = append(result, sketch.TopItemType{Value: value, Count: *count})
+	}
+	return result
+}
+
+//files returns names of spilled runs
+func (c *valueCountsType) files() []string {
+	return c.runs.names
+}
+
+//ordered calls fn for every distinct value with its count, the most frequent first.
+//Distinct values exceeding the budget are ordered by spilling them again
+func (c *valueCountsType) ordered(fn func(item sketch.TopItemType) error) (err error) {
+	frequencies := &countRunsType{
+		dir:    c.runs.dir,
+		prefix: "f",
+		less:   byFrequency,
+	}
+	var items []sketch.TopItemType
+	size := 0
+	var last *sketch.TopItemType
+	collect := func() (err error) {
+		items = append(items, *last)
+		size += len(last.Value) + valueCountOverhead
+		if size > c.budget {
+			err = frequencies.spill(items)
+			items, size = items[:0], 0
+		}
+		return
+	}
+	//runs are ordered by value, so counts of a value from different runs come one after another
+	err = c.runs.merge(c.items(), func(item sketch.TopItemType) (err error) {
+		if last != nil && last.Value == item.Value {
+			last.Count += item.Count
+			return
+		}
+		if last != nil {
+			err = collect()
+		}
+		last = &item
+		return
+	})
+	if err == nil && last != nil {
+		err = collect()
+	}
+	if err != nil {
+		return
+	}
+	return frequencies.merge(items, fn)
+}
+
+//remove deletes the spill directory
+func (c *valueCountsType) remove() error {
+	return os.RemoveAll(c.runs.dir)
+}
+
+//countRunsType is a set of run files of values with counts, every run is sorted by less
+type countRunsType struct {
+	dir    string
+	prefix string
+	less   func(a, b *sketch.TopItemType) bool
+	names  []string
+	//merged counts runs made by merge passes
+	merged int
+}
+
+//spill sorts items and writes them to a new run file.
+//A record is the count and the value length as uvarints followed by the value
+func (r *countRunsType) spill(items []sketch.TopItemType) (err error) {
+	sort.Slice(items, func(i, j int) bool {
+		return r.less(&items[i], &items[j])
+	})
+	name := fmt.Sprintf("%v%06d.run", r.prefix, len(r.names))
+	writer, err := r.create(name)
+	if err != nil {
+		return
+	}
+	for _, item := range items {
+		writer.write(item)
+	}
+	err = writer.close()
+	if err == nil {
+		r.names = append(r.names, name)
+	}
+	return
+}
+
+//runWriterType writes records to a run file
+type runWriterType struct {
+	pathToFile string
+	file       *os.File
+	writer     *bufio.Writer
+	buffer     [2 * binary.MaxVarintLen64]byte
+}
+
+func (r *countRunsType) create(name string) (*runWriterType, error) {
+	pathToFile := path.Join(r.dir, name)
+	file, err := os.Create(pathToFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create run %v", pathToFile)
+	}
+	return &runWriterType{pathToFile: pathToFile, file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+//write buffers a record, errors are reported by close
+func (w *runWriterType) write(item sketch.TopItemType) error {
+	n := binary.PutUvarint(w.buffer[:], item.Count)
+	n += binary.PutUvarint(w.buffer[n:], uint64(len(item.Value)))
+	w.writer.Write(w.buffer[:n])
+	w.writer.WriteString(item.Value)
+	return nil
+}
+
+func (w *runWriterType) close() (err error) {
+	err = w.writer.Flush()
+	if err == nil {
+		err = w.file.Sync()
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "could not write run %v", w.pathToFile)
+	}
+	return
+}
+
+type runCursorType struct {
+	item sketch.TopItemType
+	next func() (item sketch.TopItemType, ok bool, err error)
+}
+
+type runHeapType struct {
+	cursors []*runCursorType
+	less    func(a, b *sketch.TopItemType) bool
+}
+
+func (h *runHeapType) Len() int           { return len(h.cursors) }
+func (h *runHeapType) Less(i, j int) bool { return h.less(&h.cursors[i].item, &h.cursors[j].item) }
+func (h *runHeapType) Swap(i, j int)      { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+func (h *runHeapType) Push(x interface{}) { h.cursors = append(h.cursors, x.(*runCursorType)) }
+func (h *runHeapType) Pop() interface{} {
+	cursor := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return cursor
+}
+
+//merge calls fn for items of all the runs and items in memory in the order of less.
+//Runs are merged into fewer ones in passes of maxMergeRuns until there are no more of them than that.
+//Runs spilled are kept for a resumed run, those merged in passes are removed once merged again
+func (r *countRunsType) merge(items []sketch.TopItemType, fn func(item sketch.TopItemType) error) (err error) {
+	names := r.names
+	merged := make(map[string]bool)
+	defer func() {
+		for name := range merged {
+			os.Remove(path.Join(r.dir, name))
+		}
+	}()
+	for len(names) > maxMergeRuns {
+		var next []string
+		for start := 0; start < len(names); start += maxMergeRuns {
+			group := names[start:]
+			if len(group) > maxMergeRuns {
+				group = group[:maxMergeRuns]
+			}
+			if len(group) == 1 {
+				next = append(next, group[0])
+				continue
+			}
+			name := fmt.Sprintf("%vm%06d.run", r.prefix, r.merged)
+			r.merged++
+			writer, err := r.create(name)
+			if err != nil {
+				return err
+			}
+			merged[name] = true
+			err = r.mergeRuns(group, nil, writer.write)
+			if closeErr := writer.close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+			for _, name := range group {
+				if merged[name] {
+					os.Remove(path.Join(r.dir, name))
+					delete(merged, name)
+				}
+			}
+			next = append(next, name)
+		}
+		names = next
+	}
+	return r.mergeRuns(names, items, fn)
+}
+
+//mergeRuns merges runs of names with items in memory, a run file is closed as soon as it's read to the end
+func (r *countRunsType) mergeRuns(names []string, items []sketch.TopItemType, fn func(item sketch.TopItemType) error) (err error) {
+	sort.Slice(items, func(i, j int) bool {
+		return r.less(&items[i], &items[j])
+	})
+	h := &runHeapType{less: r.less}
+	push := func(next func() (sketch.TopItemType, bool, error)) error {
+		item, ok, err := next()
+		if ok {
+			heap.Push(h, &runCursorType{item: item, next: next})
+		}
+		return err
+	}
+	index := 0
+	err = push(func() (item sketch.TopItemType, ok bool, err error) {
+		if index < len(items) {
+			item, ok = items[index], true
+			index++
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+	open := make(map[*os.File]bool)
+	defer func() {
+		for file := range open {
+			file.Close()
+		}
+	}()
+	for _, name := range names {
+		pathToFile := path.Join(r.dir, name)
+		file, err := os.Open(pathToFile)
+		if err != nil {
+			return errors.Wrapf(err, "could not open run %v", pathToFile)
+		}
+		open[file] = true
+		reader := bufio.NewReader(file)
+		err = push(func() (item sketch.TopItemType, ok bool, err error) {
+			item.Count, err = binary.ReadUvarint(reader)
+			if err == io.EOF {
+				delete(open, file)
+				return item, false, file.Close()
+			}
+			var length uint64
+			if err == nil {
+				length, err = binary.ReadUvarint(reader)
+			}
+			if err == nil {
+				var value strings.Builder
+				_, err = io.CopyN(&value, reader, int64(length))
+				item.Value = value.String()
+			}
+			if err != nil {
+				return item, false, errors.Wrapf(err, "could not read run %v", pathToFile)
+			}
+			return item, true, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for h.Len() > 0 {
+		cursor := h.cursors[0]
+		err = fn(cursor.item)
+		if err != nil {
+			return
+		}
+		var ok bool
+		cursor.item, ok, err = cursor.next()
+		if err != nil {
+			return
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/ovlad32/geq/sketch"
+)
+
+//TestValueCountsMergePasses spills far more runs than are merged at once
+func TestValueCountsMergePasses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "counts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//every new value exceeds the budget and is spilled to a run of its own
+	counts, err := newValueCounts(path.Join(dir, "spill"), 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make(map[string]uint64)
+	for n := 0; n < 10*maxMergeRuns; n++ {
+		value := fmt.Sprintf("v%03d", n%300)
+		expected[value]++
+		if err = counts.add([]byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spilled := len(counts.files())
+	if spilled <= maxMergeRuns {
+		t.Fatalf("%v run(s) spilled", spilled)
+	}
+	if err = counts.spill(); err != nil {
+		t.Fatal(err)
+	}
+	if len(counts.files()) != spilled {
+		t.Fatalf("spill of no new values has written %v run(s)", len(counts.files())-spilled)
+	}
+
+	var last *sketch.TopItemType
+	err = counts.ordered(func(item sketch.TopItemType) error {
+		if last != nil && byFrequency(&item, last) {
+			return fmt.Errorf("%v follows %v", item, *last)
+		}
+		if expected[item.Value] != item.Count {
+			return fmt.Errorf("%v counted %v times, %v expected", item.Value, item.Count, expected[item.Value])
+		}
+		delete(expected, item.Value)
+		last = &item
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expected) > 0 {
+		t.Fatalf("%v value(s) missing", len(expected))
+	}
+
+	entries, err := ioutil.ReadDir(path.Join(dir, "spill"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := make(map[string]bool)
+	for _, name := range counts.files() {
+		kept[name] = true
+	}
+	for _, entry := range entries {
+		if entry.Name()[0] == 'v' && !kept[entry.Name()] {
+			t.Errorf("run %v merged in a pass is not removed", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package dump
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/ulikunitz/xz"
+)
+
+//CodecType names a compression format of a dump stream
+type CodecType string
+
+const (
+	//CodecAuto detects a format by the magic bytes a stream starts with
+	CodecAuto  CodecType = "auto"
+	CodecNone  CodecType = "none"
+	CodecGZip  CodecType = "gzip"
+	CodecZstd  CodecType = "zstd"
+	CodecBZip2 CodecType = "bzip2"
+	CodecXZ    CodecType = "xz"
+	CodecLZ4   CodecType = "lz4"
+	//CodecZip reads all file entries of an archive one after another.
+	//The stream must implement io.ReaderAt and io.Seeker, as *os.File does
+	CodecZip CodecType = "zip"
+)
+
+var codecMagics = []struct {
+	codec CodecType
+	magic []byte
+}{
+	{CodecGZip, []byte{0x1F, 0x8B}},
+	{CodecZstd, []byte{0x28, 0xB5, 0x2F, 0xFD}},
+	{CodecBZip2, []byte("BZh")},
+	{CodecXZ, []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}},
+	{CodecLZ4, []byte{0x04, 0x22, 0x4D, 0x18}},
+	{CodecZip, []byte("PK\x03\x04")},
+}
+
+//codecExtensions maps file extensions of dump files to their codecs
+var codecExtensions = map[string]CodecType{
+	".gz":  CodecGZip,
+	".zst": CodecZstd,
+	".bz2": CodecBZip2,
+	".xz":  CodecXZ,
+	".lz4": CodecLZ4,
+	".zip": CodecZip,
+	".txt": CodecNone,
+	".csv": CodecNone,
+	".dat": CodecNone,
+}
+
+//DataFileExtensions returns extensions of compressed files the dumper is able to read.
+//Plain files are left out, as readmes and sidecar files of plain extensions often lie next to dumps
+func DataFileExtensions() (result []string) {
+	result = make([]string, 0, len(codecExtensions))
+	for ext, codec := range codecExtensions {
+		if codec != CodecNone {
+			result = append(result, ext)
+		}
+	}
+	sort.Strings(result)
+	return
+}
+
+//CodecByFileName returns a codec by the extension of the file name or CodecAuto if the extension is unknown
+func CodecByFileName(fileName string) CodecType {
+	if codec, found := codecExtensions[strings.ToLower(path.Ext(fileName))]; found {
+		return codec
+	}
+	return CodecAuto
+}
+
+//ParseCodec validates a codec name, empty name means CodecAuto
+func ParseCodec(name string) (codec CodecType, err error) {
+	codec = CodecType(strings.ToLower(strings.TrimSpace(name)))
+	switch codec {
+	case "":
+		return CodecAuto, nil
+	case CodecAuto, CodecNone, CodecGZip, CodecZstd, CodecBZip2, CodecXZ, CodecLZ4, CodecZip:
+		return codec, nil
+	}
+	return "", fmt.Errorf("codec %v is not supported", name)
+}
+
+//DetectCodec recognizes a format by the magic bytes in the head of a stream
+func DetectCodec(head []byte) CodecType {
+	for _, cm := range codecMagics {
+		if bytes.HasPrefix(head, cm.magic) {
+			return cm.codec
+		}
+	}
+	return CodecNone
+}
+
+func (config *DumperConfigType) streamCodec() CodecType {
+	if config.Codec != "" {
+		return config.Codec
+	}
+	if config.GZip {
+		return CodecGZip
+	}
+	return CodecNone
+}
+
+//decompress wraps the stream with a reader of the configured format.
+//The closer returned releases resources of the decompressor, it is never nil
+func (dumper *DumperType) decompress(stream io.Reader) (result io.Reader, closer func(), err error) {
+	closer = func() {}
+	codec := dumper.config.streamCodec()
+	if codec == CodecAuto {
+		sniffer := bufio.NewReader(stream)
+		var head []byte
+		head, err = sniffer.Peek(8)
+		if err != nil && err != io.EOF {
+			err = fmt.Errorf("couldn't read stream head: %v", err)
+			return
+		}
+		codec = DetectCodec(head)
+		if codec != CodecZip {
+			stream = sniffer
+		}
+	}
+
+	switch codec {
+	case CodecNone:
+		return stream, closer, nil
+	case CodecGZip:
+		var zipped *gzip.Reader
+		zipped, err = gzip.NewReader(stream)
+		if err != nil {
+			err = fmt.Errorf("couldn't create gzip reader from stream: %v", err)
+			return
+		}
+		return zipped, func() { zipped.Close() }, nil
+	case CodecZstd:
+		var decoder *zstd.Decoder
+		decoder, err = zstd.NewReader(stream)
+		if err != nil {
+			err = fmt.Errorf("couldn't create zstd reader from stream: %v", err)
+			return
+		}
+		return decoder, decoder.Close, nil
+	case CodecBZip2:
+		return bzip2.NewReader(stream), closer, nil
+	case CodecXZ:
+		result, err = xz.NewReader(stream)
+		if err != nil {
+			err = fmt.Errorf("couldn't create xz reader from stream: %v", err)
+			return
+		}
+		return result, closer, nil
+	case CodecLZ4:
+		return lz4.NewReader(stream), closer, nil
+	case CodecZip:
+		return newZipEntriesReader(stream)
+	}
+	err = fmt.Errorf("codec %v is not supported", codec)
+	return
+}
+
+type zipEntriesReader struct {
+	files   []*zip.File
+	current io.ReadCloser
+}
+
+func newZipEntriesReader(stream io.Reader) (result io.Reader, closer func(), err error) {
+	archive, ok := stream.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	if !ok {
+		err = fmt.Errorf("zip archive can be read from a file only")
+		return
+	}
+	size, err := archive.Seek(0, io.SeekEnd)
+	if err != nil {
+		err = fmt.Errorf("couldn't get size of zip archive: %v", err)
+		return
+	}
+	zipped, err := zip.NewReader(archive, size)
+	if err != nil {
+		err = fmt.Errorf("couldn't create zip reader from stream: %v", err)
+		return
+	}
+	reader := &zipEntriesReader{}
+	for _, file := range zipped.File {
+		if !file.FileInfo().IsDir() {
+			reader.files = append(reader.files, file)
+		}
+	}
+	return reader, reader.close, nil
+}
+
+func (z *zipEntriesReader) Read(p []byte) (n int, err error) {
+	for {
+		if z.current == nil {
+			if len(z.files) == 0 {
+				return 0, io.EOF
+			}
+			z.current, err = z.files[0].Open()
+			if err != nil {
+				return 0, fmt.Errorf("couldn't open zip entry %v: %v", z.files[0].Name, err)
+			}
+			z.files = z.files[1:]
+		}
+		n, err = z.current.Read(p)
+		if err == io.EOF {
+			z.current.Close()
+			z.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return
+	}
+}
+
+func (z *zipEntriesReader) close() {
+	if z.current != nil {
+		z.current.Close()
+	}
+}
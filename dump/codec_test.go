@@ -0,0 +1,205 @@
+package dump
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/ulikunitz/xz"
+)
+
+const codecTestData = "1,a\n2,\"b,c\"\n3,d\n"
+
+//bzip2TestData is codecTestData compressed by bzip2 -9, as there is no bzip2 writer in the standard library
+var bzip2TestData = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xc7, 0xc1,
+	0xda, 0x80, 0x00, 0x00, 0x04, 0xd9, 0x00, 0x00, 0x10, 0x10, 0x04, 0x38,
+	0x00, 0x3c, 0x00, 0x20, 0x00, 0x31, 0x00, 0x30, 0x20, 0x06, 0x9e, 0xa0,
+	0xa8, 0xc4, 0x80, 0x7c, 0x50, 0xde, 0x2e, 0xe4, 0x8a, 0x70, 0xa1, 0x21,
+	0x8f, 0x83, 0xb5, 0x00,
+}
+
+//compressTestData compresses data by a codec writer
+func compressTestData(t *testing.T, codec CodecType, data string) []byte {
+	var result bytes.Buffer
+	var writer io.WriteCloser
+	var err error
+	switch codec {
+	case CodecNone:
+		return []byte(data)
+	case CodecBZip2:
+		if data != codecTestData {
+			t.Fatalf("bzip2 of %q is not prepared", data)
+		}
+		return bzip2TestData
+	case CodecGZip:
+		writer = gzip.NewWriter(&result)
+	case CodecZstd:
+		writer, err = zstd.NewWriter(&result)
+	case CodecXZ:
+		writer, err = xz.NewWriter(&result)
+	case CodecLZ4:
+		writer = lz4.NewWriter(&result)
+	case CodecZip:
+		//the data is split into two entries around a directory
+		archive := zip.NewWriter(&result)
+		middle := len(data) / 2
+		for _, entry := range []struct{ name, data string }{
+			{"part1.txt", data[:middle]},
+			{"dir/", ""},
+			{"dir/part2.txt", data[middle:]},
+		} {
+			if writer, err := archive.Create(entry.name); err != nil {
+				t.Fatal(err)
+			} else if _, err = writer.Write([]byte(entry.data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = archive.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return result.Bytes()
+	default:
+		t.Fatalf("codec %v", codec)
+	}
+	if err == nil {
+		_, err = writer.Write([]byte(data))
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.Bytes()
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	expected := [][]string{{"1", "a"}, {"2", "b,c"}, {"3", "d"}}
+	for ext, codec := range codecExtensions {
+		pathToFile := path.Join(dir, "data"+ext)
+		compressed := compressTestData(t, codec, codecTestData)
+		if err = ioutil.WriteFile(pathToFile, compressed, 0666); err != nil {
+			t.Fatal(err)
+		}
+		if codec != CodecNone && DetectCodec(compressed) != codec {
+			t.Errorf("%v detected as %v", codec, DetectCodec(compressed))
+		}
+		if CodecByFileName(pathToFile) != codec {
+			t.Errorf("codec of %v is %v", pathToFile, CodecByFileName(pathToFile))
+		}
+		for _, configured := range []CodecType{codec, CodecAuto} {
+			dumper, err := NewDumper(&DumperConfigType{
+				ColumnSeparator: ',',
+				LineSeparator:   LineFeedByte,
+				Codec:           configured,
+				QuoteChar:       DoubleQuoteByte,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows [][]string
+			lineCount, err := dumper.ReadFromFile(context.Background(), pathToFile, func(
+				cancelContext context.Context,
+				config *DumperConfigType,
+				currentLineNumber uint64,
+				currentStreamPosition uint64,
+				cellsBytes [][]byte,
+				rawLineBytes []byte,
+			) error {
+				var row []string
+				for _, cell := range cellsBytes {
+					row = append(row, string(cell))
+				}
+				rows = append(rows, row)
+				return nil
+			})
+			if err != nil {
+				t.Errorf("%v read as %v: %v", pathToFile, configured, err)
+			} else if lineCount != 3 || !reflect.DeepEqual(rows, expected) {
+				t.Errorf("%v read as %v: rows %q of %v line(s)", pathToFile, configured, rows, lineCount)
+			}
+		}
+	}
+}
+
+func TestDetectCodec(t *testing.T) {
+	tests := []struct {
+		head  []byte
+		codec CodecType
+	}{
+		{[]byte{0x1F, 0x8B, 0x08}, CodecGZip},
+		{[]byte{0x28, 0xB5, 0x2F, 0xFD, 0x00}, CodecZstd},
+		{[]byte("BZh9"), CodecBZip2},
+		{[]byte{0xFD, '7', 'z', 'X', 'Z', 0x00, 0x00}, CodecXZ},
+		{[]byte{0x04, 0x22, 0x4D, 0x18}, CodecLZ4},
+		{[]byte("PK\x03\x04"), CodecZip},
+		{[]byte("PK,1\n"), CodecNone},
+		{[]byte{0x1F}, CodecNone},
+		{nil, CodecNone},
+	}
+	for _, test := range tests {
+		if codec := DetectCodec(test.head); codec != test.codec {
+			t.Errorf("%q detected as %v, %v expected", test.head, codec, test.codec)
+		}
+	}
+}
+
+func TestParseCodec(t *testing.T) {
+	for name, expected := range map[string]CodecType{"": CodecAuto, " GZip ": CodecGZip, "none": CodecNone, "zip": CodecZip} {
+		if codec, err := ParseCodec(name); err != nil || codec != expected {
+			t.Errorf("%q parsed as %v: %v", name, codec, err)
+		}
+	}
+	if _, err := ParseCodec("rar"); err == nil {
+		t.Errorf("rar is parsed")
+	}
+	if _, err := NewDumper(&DumperConfigType{Codec: "rar"}); err == nil {
+		t.Errorf("dumper of rar is created")
+	}
+}
+
+func TestDataFileExtensions(t *testing.T) {
+	extensions := DataFileExtensions()
+	expected := []string{".bz2", ".gz", ".lz4", ".xz", ".zip", ".zst"}
+	if !reflect.DeepEqual(extensions, expected) {
+		t.Errorf("extensions %v, %v expected", extensions, expected)
+	}
+}
+
+func TestZipStream(t *testing.T) {
+	dumper, err := NewDumper(&DumperConfigType{Codec: CodecZip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a zip archive is read from a stream that is not a file
+	stream := io.MultiReader(bytes.NewReader(compressTestData(t, CodecZip, codecTestData)))
+	_, err = dumper.ReadFromStream(context.Background(), stream, func(
+		cancelContext context.Context,
+		config *DumperConfigType,
+		currentLineNumber uint64,
+		currentStreamPosition uint64,
+		cellsBytes [][]byte,
+		rawLineBytes []byte,
+	) error {
+		return nil
+	})
+	if err == nil {
+		t.Errorf("zip archive is read from a stream")
+	}
+}
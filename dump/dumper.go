@@ -3,7 +3,6 @@ package dump
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
@@ -20,7 +19,7 @@ const CarriageReturnByte = byte('\r')
 
 func TruncateFromCRLF(line []byte) []byte {
 	for _, value := range []byte{LineFeedByte, CarriageReturnByte} {
-		if line[len(line)-1] == value {
+		if len(line) > 0 && line[len(line)-1] == value {
 			line = line[:len(line)-1]
 		}
 	}
@@ -41,13 +40,29 @@ type DumperStartFromByte struct {
 }
 
 type DumperConfigType struct {
+	//GZip is used when Codec is not set
 	GZip                   bool
+	Codec                  CodecType
 	ColumnSeparator        byte
 	LineSeparator          byte
 	BufferSize             int
 	StartFromLine          *DumperStartFromLine
 	StartFromByte          *DumperStartFromByte
 	FusionColumnSeparators []byte
+	//QuoteChar wraps cell values; 0 switches quoting off and cells are split as is
+	QuoteChar byte
+	//EscapeStyle defines how QuoteChar is escaped inside a quoted cell
+	EscapeStyle EscapeStyleType
+	//MultiLineRecords lets a quoted cell contain LineSeparator
+	MultiLineRecords bool
+	//ParsingWorkers > 1 makes a stream to be split into lines and cells on that many goroutines
+	//while decompression goes on its own one. It is ignored for MultiLineRecords
+	ParsingWorkers int
+	//ChunkSize is a size of a decompressed piece of a stream handed to a parsing worker
+	ChunkSize int
+	//UseIndex makes ReadFromFile to start reading a gzip file from StartFromByte or StartFromLine
+	//using the seek index next to the file, when there is one
+	UseIndex bool
 }
 
 type DumperType struct {
@@ -78,6 +93,8 @@ type ErrorAbortedByRowProcessing errorAbortedByType
 
 type ErrorAbortedByContext errorAbortedByType
 
+//RowProcessingFuncType is called for every record, currentLineNumber is the number of the physical line
+//the record starts at, so a multi-line record advances the following numbers by the lines it spans
 type RowProcessingFuncType func(
 	cancelContext context.Context,
 	config *DumperConfigType,
@@ -136,10 +153,34 @@ func validateDumperConfig(cfg *DumperConfigType) (err error) {
 		cfg.LineSeparator = defaultLineSeparatorByte
 	}
 
-	if cfg.LineSeparator == 0 {
+	if cfg.BufferSize == 0 {
 		cfg.BufferSize = defaultBufferSize
 	}
 
+	if cfg.ChunkSize == 0 {
+		cfg.ChunkSize = defaultChunkSize
+	}
+
+	if cfg.Codec != "" {
+		if _, err = ParseCodec(string(cfg.Codec)); err != nil {
+			err = fmt.Errorf("wrong parameters: %v", err)
+			return
+		}
+	}
+
+	if cfg.QuoteChar != 0 && (cfg.QuoteChar == cfg.ColumnSeparator || cfg.QuoteChar == cfg.LineSeparator) {
+		err = fmt.Errorf(
+			"wrong parameters: quote char %q coincides with a separator",
+			cfg.QuoteChar,
+		)
+		return
+	}
+
+	if cfg.MultiLineRecords && cfg.QuoteChar == 0 {
+		err = fmt.Errorf("wrong parameters: multi-line records require quote char")
+		return
+	}
+
 	return
 }
 func (dumper *DumperType) Config() DumperConfigType {
@@ -166,65 +207,101 @@ func (dumper *DumperType) ReadFromStream(
 		return
 	}
 
-	if dumper.config.GZip {
-		var zipped *gzip.Reader
-		zipped, err = gzip.NewReader(stream)
-		if err != nil {
-			err = fmt.Errorf("couldn't create zip reader from stream: %v", err)
-			return
-		}
-		stream = zipped
-	}
-
-	buffered := bufio.NewReaderSize(stream, dumper.config.BufferSize)
+	stream, closeStream, err := dumper.decompress(stream)
 	if err != nil {
-		err = fmt.Errorf("couldn't create buffer from stream: %v", err)
 		return
 	}
+	defer closeStream()
 
+	skip := 0
 	if dumper.config.StartFromByte != nil && dumper.config.StartFromByte.Position > 0 {
-		var discarded int
-		discarded, err = buffered.Discard(dumper.config.StartFromByte.Position)
+		skip = dumper.config.StartFromByte.Position
+		lineNumber = dumper.config.StartFromByte.FirstLine
+		streamPosition = uint64(skip)
+	}
+	return dumper.readDecompressed(ctx, stream, lineNumber, streamPosition, skip, rowProcessingFunc)
+}
+
+//readDecompressed skips skip bytes of the stream and feeds the following rows to rowProcessingFunc.
+//lineNumber and streamPosition are the ones of the first row after skipping
+func (dumper *DumperType) readDecompressed(
+	ctx context.Context,
+	stream io.Reader,
+	lineNumber uint64,
+	streamPosition uint64,
+	skip int,
+	rowProcessingFunc RowProcessingFuncType,
+) (uint64, error) {
+	buffered := bufio.NewReaderSize(stream, dumper.config.BufferSize)
+
+	if skip > 0 {
+		discarded, err := buffered.Discard(skip)
 		if err != nil {
 			err = fmt.Errorf("could not discard stream to position %v: %v",
-				dumper.config.StartFromByte.Position,
+				skip,
 				err,
 			)
-			return
+			return lineNumber, err
 		}
 
-		if discarded != dumper.config.StartFromByte.Position {
+		if discarded != skip {
 			err = fmt.Errorf("discarded position mismatch %v, expected: %v",
-				dumper.config.StartFromByte.Position,
+				skip,
 				uint64(discarded),
 			)
-			return
+			return lineNumber, err
 		}
-		lineNumber = dumper.config.StartFromByte.FirstLine
-		streamPosition = uint64(discarded)
 	}
+
+	if dumper.config.ParsingWorkers > 1 && !dumper.config.MultiLineRecords {
+		return dumper.readPipelined(ctx, buffered, lineNumber, streamPosition, rowProcessingFunc)
+	}
+
+	splitter := newCellSplitter(&dumper.config)
+	var record []byte
 	for {
 		select {
 		case <-ctx.Done():
-			err = &ErrorAbortedByContext{}
-			return
+			return lineNumber, &ErrorAbortedByContext{}
 		default:
-			var originalLine []byte
-			originalLine, err = buffered.ReadSlice(dumper.config.LineSeparator)
-			if err == io.EOF {
-				return lineNumber, nil
+			originalLine, err := buffered.ReadSlice(dumper.config.LineSeparator)
+			if err == bufio.ErrBufferFull {
+				record = append(record, originalLine...)
+				continue
+			} else if err == io.EOF {
+				if len(originalLine) == 0 && len(record) == 0 {
+					return lineNumber, nil
+				}
 			} else if err != nil {
 				return lineNumber, fmt.Errorf(
 					"couldn't read data from stream: %v",
 					err,
 				)
 			}
-			if dumper.config.StartFromLine == nil || lineNumber >= dumper.config.StartFromLine.Line {
-
-				originalLineLength := len(originalLine)
+			atEOF := err == io.EOF
+			if record != nil {
+				record = append(record, originalLine...)
+				originalLine = record
+			}
 
-				lineColumns := SplitDumpLine(originalLine, dumper.config.ColumnSeparator)
+			lineColumns, complete := splitter.split(originalLine)
+			if !complete && dumper.config.MultiLineRecords && !atEOF {
+				if record == nil {
+					record = append(make([]byte, 0, 2*len(originalLine)), originalLine...)
+				}
+				continue
+			}
+			if !complete {
+				return lineNumber, fmt.Errorf(
+					"unterminated quoted cell at line %v, position %v",
+					lineNumber,
+					streamPosition,
+				)
+			}
+			record = nil
 
+			originalLineLength := len(originalLine)
+			if dumper.config.StartFromLine == nil || lineNumber >= dumper.config.StartFromLine.Line {
 				err = rowProcessingFunc(ctx,
 					&dumper.config,
 					lineNumber,
@@ -234,17 +311,28 @@ func (dumper *DumperType) ReadFromStream(
 				)
 
 				if err != nil {
-					return
+					return lineNumber, err
 				}
-
-				lineNumber++
-				streamPosition += uint64(originalLineLength)
+			}
+			lineNumber += recordLines(originalLine, dumper.config.LineSeparator)
+			streamPosition += uint64(originalLineLength)
+			if atEOF {
+				return lineNumber, nil
 			}
 		}
 	}
 
 }
 
+//recordLines counts the physical lines of a record, the last of which may have no separator at the end of a stream
+func recordLines(record []byte, lineSeparator byte) uint64 {
+	lines := uint64(bytes.Count(record, []byte{lineSeparator}))
+	if len(record) > 0 && record[len(record)-1] != lineSeparator {
+		lines++
+	}
+	return lines
+}
+
 func (dumper *DumperType) ReadFromFile(
 	ctx context.Context,
 	pathToFile string,
@@ -270,6 +358,14 @@ func (dumper *DumperType) ReadFromFile(
 
 	defer file.Close()
 
+	if dumper.config.UseIndex {
+		var indexed bool
+		lineNumber, indexed, err = dumper.readIndexed(ctx, file, pathToFile, rowProcessingFunc)
+		if indexed || err != nil {
+			return
+		}
+	}
+
 	return dumper.ReadFromStream(ctx, file, rowProcessingFunc)
 
 }
@@ -0,0 +1,310 @@
+package dump
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+//FileProcessorFactoryFuncType returns the row processing function for a file a worker is about to read
+type FileProcessorFactoryFuncType func(
+	worker int,
+	pathToFile string,
+) (rowProcessingFunc RowProcessingFuncType, err error)
+
+//FileDoneFuncType is called when all rows of a file have been processed
+type FileDoneFuncType func(
+	worker int,
+	pathToFile string,
+	lineCount uint64,
+) (err error)
+
+//FilesConfigType holds parameters of reading a list of files
+type FilesConfigType struct {
+	//Workers is a number of files read concurrently
+	Workers int
+	//Ordered makes rows to be processed on a single goroutine following the order of the file list,
+	//while files are still read and parsed concurrently. Worker index passed to the callbacks is always 0
+	Ordered bool
+	//OrderedBatchSize is a number of rows passed from a reading worker at once in Ordered mode
+	OrderedBatchSize int
+	NewFileProcessor FileProcessorFactoryFuncType
+	FileDone         FileDoneFuncType
+	//FileStartPositions lets reading of the files start from a given position
+	FileStartPositions map[string]*DumperStartFromByte
+}
+
+const (
+	defaultOrderedBatchSize = 1024
+	orderedBatchesInAdvance = 4
+)
+
+type bufferedRowType struct {
+	lineNumber     uint64
+	streamPosition uint64
+	cellsBytes     [][]byte
+	rawLineBytes   []byte
+}
+
+type fileResultType struct {
+	lineCount uint64
+	err       error
+}
+
+//ReadFromFiles reads files concurrently with a bounded number of workers.
+//StartFromLine and StartFromByte parameters of the dumper are not applied to the files,
+//use FileStartPositions instead.
+func (dumper *DumperType) ReadFromFiles(
+	ctx context.Context,
+	pathsToFiles []string,
+	cfg *FilesConfigType,
+) (lineCount uint64, err error) {
+	if cfg == nil || cfg.NewFileProcessor == nil {
+		err = fmt.Errorf("file processor factory must be defined")
+		return
+	}
+
+	err = validateDumperConfig(&dumper.config)
+	if err != nil {
+		err = fmt.Errorf("could not process files: %v", err)
+		return
+	}
+
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > len(pathsToFiles) {
+		workers = len(pathsToFiles)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var firstErr error
+	var errOnce sync.Once
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	if cfg.Ordered {
+		lineCount = dumper.readFilesOrdered(ctx, pathsToFiles, workers, cfg, fail)
+	} else {
+		lineCount = dumper.readFilesUnordered(ctx, pathsToFiles, workers, cfg, fail)
+	}
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = &ErrorAbortedByContext{}
+	}
+	return lineCount, firstErr
+}
+
+func (dumper *DumperType) readFilesUnordered(
+	ctx context.Context,
+	pathsToFiles []string,
+	workers int,
+	cfg *FilesConfigType,
+	fail func(error),
+) (lineCount uint64) {
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for pathToFile := range jobs {
+				rowProcessingFunc, err := cfg.NewFileProcessor(worker, pathToFile)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				fileLineCount, err := dumper.fileDumper(cfg, pathToFile).ReadFromFile(ctx, pathToFile, rowProcessingFunc)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				atomic.AddUint64(&lineCount, fileLineCount)
+				if cfg.FileDone != nil {
+					err = cfg.FileDone(worker, pathToFile, fileLineCount)
+					if err != nil {
+						fail(err)
+					}
+				}
+			}
+		}(worker)
+	}
+
+feeding:
+	for _, pathToFile := range pathsToFiles {
+		select {
+		case jobs <- pathToFile:
+		case <-ctx.Done():
+			break feeding
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return
+}
+
+func (dumper *DumperType) readFilesOrdered(
+	ctx context.Context,
+	pathsToFiles []string,
+	workers int,
+	cfg *FilesConfigType,
+	fail func(error),
+) (lineCount uint64) {
+	batchSize := cfg.OrderedBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultOrderedBatchSize
+	}
+
+	batches := make([]chan []*bufferedRowType, len(pathsToFiles))
+	results := make([]fileResultType, len(pathsToFiles))
+	for index := range pathsToFiles {
+		batches[index] = make(chan []*bufferedRowType, orderedBatchesInAdvance)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				results[index] = dumper.fileDumper(cfg, pathsToFiles[index]).readFileInBatches(
+					ctx, pathsToFiles[index], batchSize, batches[index],
+				)
+				close(batches[index])
+			}
+		}()
+	}
+	go func() {
+		for index := range pathsToFiles {
+			jobs <- index
+		}
+		close(jobs)
+	}()
+
+	config := dumper.config
+	for index, pathToFile := range pathsToFiles {
+		var rowProcessingFunc RowProcessingFuncType
+		err := ctx.Err()
+		if err == nil {
+			rowProcessingFunc, err = cfg.NewFileProcessor(0, pathToFile)
+			if err != nil {
+				fail(err)
+			}
+		}
+		for batch := range batches[index] {
+			if err != nil {
+				continue
+			}
+			for _, row := range batch {
+				err = rowProcessingFunc(ctx, &config,
+					row.lineNumber,
+					row.streamPosition,
+					row.cellsBytes,
+					row.rawLineBytes,
+				)
+				if err != nil {
+					fail(err)
+					break
+				}
+			}
+		}
+		if err != nil {
+			continue
+		}
+		if results[index].err != nil {
+			fail(results[index].err)
+			continue
+		}
+		lineCount += results[index].lineCount
+		if cfg.FileDone != nil {
+			err = cfg.FileDone(0, pathToFile, results[index].lineCount)
+			if err != nil {
+				fail(err)
+			}
+		}
+	}
+	wg.Wait()
+	return
+}
+
+func (dumper *DumperType) readFileInBatches(
+	ctx context.Context,
+	pathToFile string,
+	batchSize int,
+	output chan<- []*bufferedRowType,
+) (result fileResultType) {
+	batch := make([]*bufferedRowType, 0, batchSize)
+	send := func() error {
+		select {
+		case output <- batch:
+			batch = make([]*bufferedRowType, 0, batchSize)
+			return nil
+		case <-ctx.Done():
+			return &ErrorAbortedByContext{}
+		}
+	}
+
+	result.lineCount, result.err = dumper.ReadFromFile(ctx, pathToFile,
+		func(
+			ctx context.Context,
+			config *DumperConfigType,
+			currentLineNumber uint64,
+			currentStreamPosition uint64,
+			cellsBytes [][]byte,
+			rawLineBytes []byte,
+		) error {
+			batch = append(batch, copyRow(currentLineNumber, currentStreamPosition, cellsBytes, rawLineBytes))
+			if len(batch) == batchSize {
+				return send()
+			}
+			return nil
+		})
+	if result.err == nil && len(batch) > 0 {
+		result.err = send()
+	}
+	return
+}
+
+//fileDumper returns a copy of the dumper for reading one of many files
+func (dumper *DumperType) fileDumper(cfg *FilesConfigType, pathToFile string) *DumperType {
+	config := dumper.config
+	config.StartFromLine = nil
+	config.StartFromByte = cfg.FileStartPositions[pathToFile]
+	return &DumperType{config: config}
+}
+
+//copyRow detaches a row from buffers the dumper reuses
+func copyRow(
+	lineNumber uint64,
+	streamPosition uint64,
+	cellsBytes [][]byte,
+	rawLineBytes []byte,
+) *bufferedRowType {
+	size := len(rawLineBytes)
+	for _, cell := range cellsBytes {
+		size += len(cell)
+	}
+	data := make([]byte, 0, size)
+	data = append(data, rawLineBytes...)
+	row := &bufferedRowType{
+		lineNumber:     lineNumber,
+		streamPosition: streamPosition,
+		rawLineBytes:   data[:len(rawLineBytes):len(rawLineBytes)],
+		cellsBytes:     make([][]byte, len(cellsBytes)),
+	}
+	for index, cell := range cellsBytes {
+		start := len(data)
+		data = append(data, cell...)
+		row.cellsBytes[index] = data[start:len(data):len(data)]
+	}
+	return row
+}
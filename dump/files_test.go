@@ -0,0 +1,196 @@
+package dump
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+)
+
+//writeTestFiles writes files of lines "<file>,<line>" and returns their paths
+func writeTestFiles(t *testing.T, dir string, lineCounts ...int) (pathsToFiles []string) {
+	for file, lineCount := range lineCounts {
+		var data []byte
+		for line := 0; line < lineCount; line++ {
+			data = append(data, fmt.Sprintf("%v,%v\n", file, line)...)
+		}
+		pathToFile := path.Join(dir, fmt.Sprintf("file%v.txt", file))
+		if err := ioutil.WriteFile(pathToFile, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		pathsToFiles = append(pathsToFiles, pathToFile)
+	}
+	return
+}
+
+func testFilesDumper(t *testing.T) *DumperType {
+	dumper, err := NewDumper(&DumperConfigType{
+		ColumnSeparator: ',',
+		LineSeparator:   LineFeedByte,
+		Codec:           CodecNone,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dumper
+}
+
+func TestReadFromFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lineCounts := []int{100, 0, 2500, 1, 700}
+	pathsToFiles := writeTestFiles(t, dir, lineCounts...)
+
+	for _, ordered := range []bool{false, true} {
+		var mutex sync.Mutex
+		var rows []string
+		done := make(map[string]uint64)
+		workers := make(map[int]bool)
+		lineCount, err := testFilesDumper(t).ReadFromFiles(context.Background(), pathsToFiles, &FilesConfigType{
+			Workers:          3,
+			Ordered:          ordered,
+			OrderedBatchSize: 64,
+			NewFileProcessor: func(worker int, pathToFile string) (RowProcessingFuncType, error) {
+				return func(
+					cancelContext context.Context,
+					config *DumperConfigType,
+					currentLineNumber uint64,
+					currentStreamPosition uint64,
+					cellsBytes [][]byte,
+					rawLineBytes []byte,
+				) error {
+					row := string(cellsBytes[0]) + "," + string(cellsBytes[1])
+					if expected := path.Join(dir, "file"+string(cellsBytes[0])+".txt"); expected != pathToFile ||
+						fmt.Sprint(currentLineNumber) != string(cellsBytes[1]) ||
+						string(rawLineBytes) != row+"\n" {
+						return fmt.Errorf("row %v of %v at line %v", row, pathToFile, currentLineNumber)
+					}
+					mutex.Lock()
+					rows = append(rows, row)
+					workers[worker] = true
+					mutex.Unlock()
+					return nil
+				}, nil
+			},
+			FileDone: func(worker int, pathToFile string, lineCount uint64) error {
+				mutex.Lock()
+				done[pathToFile] = lineCount
+				mutex.Unlock()
+				return nil
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lineCount != 3301 || len(rows) != 3301 {
+			t.Fatalf("ordered %v: %v line(s) counted, %v row(s) processed", ordered, lineCount, len(rows))
+		}
+		for file, pathToFile := range pathsToFiles {
+			if done[pathToFile] != uint64(lineCounts[file]) {
+				t.Errorf("ordered %v: %v done with %v line(s)", ordered, pathToFile, done[pathToFile])
+			}
+		}
+		if ordered {
+			//rows follow the file list and the lines
+			index := 0
+			for file, count := range lineCounts {
+				for line := 0; line < count; line++ {
+					if expected := fmt.Sprintf("%v,%v", file, line); rows[index] != expected {
+						t.Fatalf("row %v is %v, %v expected", index, rows[index], expected)
+					}
+					index++
+				}
+			}
+			if len(workers) != 1 || !workers[0] {
+				t.Errorf("ordered rows processed by workers %v", workers)
+			}
+		}
+	}
+}
+
+func TestReadFromFilesStartPositions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pathsToFiles := writeTestFiles(t, dir, 10, 10)
+
+	//line 3 of the second file starts at byte 12 as lines 0-2 are "1,0\n" and alike
+	var lines []uint64
+	lineCount, err := testFilesDumper(t).ReadFromFiles(context.Background(), pathsToFiles, &FilesConfigType{
+		Ordered:            true,
+		FileStartPositions: map[string]*DumperStartFromByte{pathsToFiles[1]: {Position: 12, FirstLine: 3}},
+		NewFileProcessor: func(worker int, pathToFile string) (RowProcessingFuncType, error) {
+			return func(
+				cancelContext context.Context,
+				config *DumperConfigType,
+				currentLineNumber uint64,
+				currentStreamPosition uint64,
+				cellsBytes [][]byte,
+				rawLineBytes []byte,
+			) error {
+				if pathToFile == pathsToFiles[1] {
+					if fmt.Sprint(currentLineNumber) != string(cellsBytes[1]) || currentStreamPosition != 4*currentLineNumber {
+						return fmt.Errorf("line %v at %v is %q", currentLineNumber, currentStreamPosition, rawLineBytes)
+					}
+					lines = append(lines, currentLineNumber)
+				}
+				return nil
+			}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lineCount != 20 || len(lines) != 7 || lines[0] != 3 {
+		t.Fatalf("%v line(s) counted, lines %v of the second file read", lineCount, lines)
+	}
+}
+
+func TestReadFromFilesError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pathsToFiles := writeTestFiles(t, dir, 1000, 1000, 1000, 1000)
+	failure := fmt.Errorf("failure")
+
+	for _, ordered := range []bool{false, true} {
+		_, err = testFilesDumper(t).ReadFromFiles(context.Background(), pathsToFiles, &FilesConfigType{
+			Workers: 2,
+			Ordered: ordered,
+			NewFileProcessor: func(worker int, pathToFile string) (RowProcessingFuncType, error) {
+				return func(
+					cancelContext context.Context,
+					config *DumperConfigType,
+					currentLineNumber uint64,
+					currentStreamPosition uint64,
+					cellsBytes [][]byte,
+					rawLineBytes []byte,
+				) error {
+					if pathToFile == pathsToFiles[1] && currentLineNumber == 500 {
+						return failure
+					}
+					return nil
+				}, nil
+			},
+			FileDone: func(worker int, pathToFile string, lineCount uint64) error {
+				if pathToFile == pathsToFiles[1] {
+					return fmt.Errorf("failed file is done")
+				}
+				return nil
+			},
+		})
+		if err != failure {
+			t.Errorf("ordered %v: error %v returned", ordered, err)
+		}
+	}
+}
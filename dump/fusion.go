@@ -0,0 +1,33 @@
+package dump
+
+import "bytes"
+
+//FusionType addresses sub-fields of fused cells, values of several columns joined with Separator
+type FusionType struct {
+	Separator []byte
+	//SizeAlignment is a difference between the number of sub-fields of a fused cell and its fusion size
+	SizeAlignment int
+}
+
+//Field returns a sub-field of a fused cell at 1-based position.
+//Fusion size 1 means the cell is not fused and the whole cell is returned for position 1.
+//Otherwise the cell must have size+SizeAlignment sub-fields, size 0 accepts any number of them
+func (fusion *FusionType) Field(cell []byte, position, size int) (field []byte, found bool) {
+	if position < 1 {
+		return nil, false
+	}
+	if size == 1 || len(fusion.Separator) == 0 {
+		return cell, position == 1
+	}
+	count := bytes.Count(cell, fusion.Separator) + 1
+	if size > 1 && count != size+fusion.SizeAlignment || position > count {
+		return nil, false
+	}
+	for ; position > 1; position-- {
+		cell = cell[bytes.Index(cell, fusion.Separator)+len(fusion.Separator):]
+	}
+	if end := bytes.Index(cell, fusion.Separator); end != -1 {
+		cell = cell[:end]
+	}
+	return cell, true
+}
@@ -0,0 +1,40 @@
+package dump
+
+import (
+	"testing"
+)
+
+func TestFusionField(t *testing.T) {
+	tests := []struct {
+		separator string
+		alignment int
+		cell      string
+		position  int
+		size      int
+		field     string
+		found     bool
+	}{
+		{"|", 0, "a|b|c", 2, 3, "b", true},
+		{"|", 0, "a|b|c", 3, 3, "c", true},
+		{"|", 0, "a|b|c", 1, 2, "", false},
+		{"|", 0, "a|b|c", 4, 3, "", false},
+		{"|", 0, "a|b|c", 0, 3, "", false},
+		{"|", 0, "a|b|c", 1, 1, "a|b|c", true},
+		{"|", 0, "a|b|c", 2, 1, "", false},
+		{"|", 0, "a||c", 2, 3, "", true},
+		{"|", 0, "a|b|c|d", 4, 0, "d", true},
+		{"|", 0, "a|b|c|d", 5, 0, "", false},
+		//a trailing separator makes an extra sub-field the alignment accounts for
+		{"|", 1, "a|b|", 2, 2, "b", true},
+		{"|", 1, "a|b", 2, 2, "", false},
+		{"::", 0, "a::b:c", 2, 2, "b:c", true},
+		{"", 0, "a|b", 1, 2, "a|b", true},
+	}
+	for _, test := range tests {
+		fusion := &FusionType{Separator: []byte(test.separator), SizeAlignment: test.alignment}
+		field, found := fusion.Field([]byte(test.cell), test.position, test.size)
+		if found != test.found || found && string(field) != test.field {
+			t.Errorf("%+v: field %q found %v", test, field, found)
+		}
+	}
+}
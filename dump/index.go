@@ -0,0 +1,417 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+//IndexFileExtension is appended to a data file name to get the name of its seek index
+const IndexFileExtension = ".gzx"
+
+//IndexPointType is a place in a gzip file decompression can be started from
+type IndexPointType struct {
+	//CompressedBit is a bit offset of a deflate block header, or of a gzip member header if MemberStart
+	CompressedBit int64
+	Uncompressed  int64
+	Member        int
+	MemberStart   bool
+	//Window holds up to 32K bytes decoded before the point
+	Window []byte
+}
+
+//IndexLineType marks the start of a line
+type IndexLineType struct {
+	Line     uint64
+	Position int64
+}
+
+//IndexType lets a gzip file be read from the middle without decompression of preceding data
+type IndexType struct {
+	DataFileSize    int64
+	DataFileModTime int64
+	LineSeparator   byte
+	Span            int64
+	//Members are byte offsets of gzip member headers
+	Members   []int64
+	Points    []IndexPointType
+	Lines     []IndexLineType
+	LineCount uint64
+	Size      int64
+}
+
+//IndexFileName returns a path to the seek index of a data file
+func IndexFileName(pathToFile string) string {
+	return pathToFile + IndexFileExtension
+}
+
+//BuildIndex decompresses a gzip file recording access points every span decompressed bytes
+//and the starts of the first lines following them
+func BuildIndex(pathToFile string, span int64, lineSeparator byte) (index *IndexType, err error) {
+	file, err := os.Open(pathToFile)
+	if err != nil {
+		err = fmt.Errorf("could not open file %v: %v", pathToFile, err)
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		err = fmt.Errorf("could not get stat of file %v: %v", pathToFile, err)
+		return
+	}
+
+	index = &IndexType{
+		DataFileSize:    stat.Size(),
+		DataFileModTime: stat.ModTime().UnixNano(),
+		LineSeparator:   lineSeparator,
+		Span:            span,
+	}
+
+	inflater := newInflater(file)
+	lastPoint := int64(0)
+	//pendingLine is a position the next line mark is to be recorded from
+	pendingLine := int64(-1)
+	atLineStart := true
+	var lineCount uint64
+	var position int64
+
+	inflater.memberStart = func(offset int64) {
+		index.Members = append(index.Members, offset)
+		index.Points = append(index.Points, IndexPointType{
+			CompressedBit: offset * 8,
+			Uncompressed:  inflater.total,
+			Member:        len(index.Members) - 1,
+			MemberStart:   true,
+		})
+		lastPoint = inflater.total
+		if pendingLine == -1 {
+			pendingLine = inflater.total
+		}
+	}
+	inflater.blockStart = func(bitPosition int64, firstInMember bool) {
+		if firstInMember || inflater.total-lastPoint < span {
+			return
+		}
+		index.Points = append(index.Points, IndexPointType{
+			CompressedBit: bitPosition,
+			Uncompressed:  inflater.total,
+			Member:        len(index.Members) - 1,
+			Window:        append([]byte(nil), inflater.window()...),
+		})
+		lastPoint = inflater.total
+		if pendingLine == -1 {
+			pendingLine = inflater.total
+		}
+	}
+	inflater.output = func(decoded []byte) {
+		for offset, b := range decoded {
+			if atLineStart && pendingLine != -1 && position+int64(offset) >= pendingLine {
+				index.Lines = append(index.Lines, IndexLineType{
+					Line:     lineCount,
+					Position: position + int64(offset),
+				})
+				pendingLine = -1
+			}
+			atLineStart = b == lineSeparator
+			if atLineStart {
+				lineCount++
+			}
+		}
+		position += int64(len(decoded))
+	}
+
+	err = inflater.run()
+	if err != nil {
+		err = fmt.Errorf("could not build index of %v: %v", pathToFile, err)
+		return nil, err
+	}
+	if !atLineStart {
+		lineCount++
+	}
+	index.LineCount = lineCount
+	index.Size = position
+	return index, nil
+}
+
+//Save writes the index to a file
+func (index *IndexType) Save(pathToIndexFile string) (err error) {
+	file, err := os.Create(pathToIndexFile)
+	if err != nil {
+		err = fmt.Errorf("could not create index file %v: %v", pathToIndexFile, err)
+		return
+	}
+	zipped := gzip.NewWriter(file)
+	err = gob.NewEncoder(zipped).Encode(index)
+	if err == nil {
+		err = zipped.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		err = fmt.Errorf("could not write index file %v: %v", pathToIndexFile, err)
+	}
+	return
+}
+
+//LoadIndex reads the seek index of a data file.
+//It returns nil when there is no index or the data file has changed since the index was built
+func LoadIndex(pathToFile string) (index *IndexType, err error) {
+	stat, err := os.Stat(pathToFile)
+	if err != nil {
+		err = fmt.Errorf("could not get stat of file %v: %v", pathToFile, err)
+		return
+	}
+	pathToIndexFile := IndexFileName(pathToFile)
+	file, err := os.Open(pathToIndexFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		err = fmt.Errorf("could not open index file %v: %v", pathToIndexFile, err)
+		return
+	}
+	defer file.Close()
+
+	zipped, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		err = fmt.Errorf("could not read index file %v: %v", pathToIndexFile, err)
+		return
+	}
+	index = &IndexType{}
+	err = gob.NewDecoder(zipped).Decode(index)
+	if err != nil {
+		err = fmt.Errorf("could not decode index file %v: %v", pathToIndexFile, err)
+		return nil, err
+	}
+	if index.DataFileSize != stat.Size() || index.DataFileModTime != stat.ModTime().UnixNano() {
+		return nil, nil
+	}
+	return index, nil
+}
+
+//LineStart returns the last marked line start at or before line
+func (index *IndexType) LineStart(line uint64) (mark IndexLineType) {
+	found := sort.Search(len(index.Lines), func(i int) bool {
+		return index.Lines[i].Line > line
+	})
+	if found == 0 {
+		return IndexLineType{}
+	}
+	return index.Lines[found-1]
+}
+
+//Open returns the decompressed stream of the data file starting at position
+func (index *IndexType) Open(file io.ReaderAt, position int64) (stream io.Reader, closer func(), err error) {
+	if position > index.Size {
+		err = fmt.Errorf("position %v is beyond the decompressed size %v", position, index.Size)
+		return
+	}
+	found := sort.Search(len(index.Points), func(i int) bool {
+		return index.Points[i].Uncompressed > position
+	})
+	if found == 0 {
+		err = fmt.Errorf("index has no access point before position %v", position)
+		return
+	}
+	point := index.Points[found-1]
+	closer = func() {}
+
+	if point.MemberStart {
+		var zipped *gzip.Reader
+		zipped, err = gzip.NewReader(bufio.NewReader(io.NewSectionReader(file, point.CompressedBit/8, 1<<62)))
+		if err != nil {
+			err = fmt.Errorf("couldn't create gzip reader at member %v: %v", point.Member, err)
+			return
+		}
+		stream, closer = zipped, func() { zipped.Close() }
+	} else {
+		var primed io.Reader
+		var discard int64
+		primed, discard, err = primedStream(
+			point.Window,
+			uint(point.CompressedBit%8),
+			bufio.NewReader(io.NewSectionReader(file, point.CompressedBit/8, 1<<62)),
+		)
+		if err != nil {
+			err = fmt.Errorf("couldn't read access point at bit %v: %v", point.CompressedBit, err)
+			return
+		}
+		inflated := flate.NewReader(primed)
+		stream, closer = inflated, func() { inflated.Close() }
+		_, err = io.CopyN(ioutil.Discard, inflated, discard)
+		if err != nil {
+			closer()
+			err = fmt.Errorf("couldn't restore window at bit %v: %v", point.CompressedBit, err)
+			return nil, nil, err
+		}
+		if point.Member+1 < len(index.Members) {
+			stream = io.MultiReader(inflated, &lazyGzipReaderType{
+				open: func() (*gzip.Reader, error) {
+					return gzip.NewReader(bufio.NewReader(
+						io.NewSectionReader(file, index.Members[point.Member+1], 1<<62),
+					))
+				},
+			})
+		}
+	}
+
+	skip := position - point.Uncompressed
+	if skip > 0 {
+		_, err = io.CopyN(ioutil.Discard, stream, skip)
+		if err != nil {
+			closer()
+			err = fmt.Errorf("couldn't skip %v bytes from access point: %v", skip, err)
+			return nil, nil, err
+		}
+	}
+	return stream, closer, nil
+}
+
+//primedStream makes a deflate stream to be decoded from a block header at bit offset shift of the first byte of rest.
+//compress/flate can't start in the middle of a byte, so the stream is prefixed with a fixed Huffman block
+//of window literals: its length keeps bit offsets of the original blocks within bytes,
+//which matters for stored blocks padded to byte boundaries.
+//discard is a number of prefix bytes to be skipped from the decoded stream
+func primedStream(window []byte, shift uint, rest *bufio.Reader) (stream io.Reader, discard int64, err error) {
+	prefixBits := uint(3 + 7)
+	for _, b := range window {
+		prefixBits += literalLength(b)
+	}
+	//extra leading literals of 9 bits adjust the prefix length, they are beyond the 32K window
+	extra := (shift + 8 - prefixBits%8) % 8
+
+	writer := &bitWriterType{data: make([]byte, 0, len(window)+len(window)/8+16)}
+	writer.bits(0, 1)
+	writer.bits(1, 2)
+	for n := uint(0); n < extra; n++ {
+		writer.literal(0xFF)
+	}
+	for _, b := range window {
+		writer.literal(b)
+	}
+	writer.code(0, 7)
+
+	if shift > 0 {
+		var first byte
+		first, err = rest.ReadByte()
+		if err != nil {
+			return
+		}
+		writer.data = append(writer.data, byte(writer.buffer)|first&(0xFF<<shift))
+	}
+	return io.MultiReader(bytes.NewReader(writer.data), rest), int64(extra) + int64(len(window)), nil
+}
+
+//literalLength returns a length of the fixed Huffman code of a literal byte
+func literalLength(b byte) uint {
+	if b < 144 {
+		return 8
+	}
+	return 9
+}
+
+//bitWriterType packs deflate bits starting from the least significant one
+type bitWriterType struct {
+	data     []byte
+	buffer   uint32
+	bitCount uint
+}
+
+func (w *bitWriterType) bits(value uint32, n uint) {
+	w.buffer |= value << w.bitCount
+	w.bitCount += n
+	for w.bitCount >= 8 {
+		w.data = append(w.data, byte(w.buffer))
+		w.buffer >>= 8
+		w.bitCount -= 8
+	}
+}
+
+//code writes a Huffman code, its most significant bit goes first
+func (w *bitWriterType) code(code uint32, n uint) {
+	reversed := uint32(0)
+	for i := uint(0); i < n; i++ {
+		reversed = reversed<<1 | code>>i&1
+	}
+	w.bits(reversed, n)
+}
+
+func (w *bitWriterType) literal(b byte) {
+	if b < 144 {
+		w.code(0x30+uint32(b), 8)
+	} else {
+		w.code(0x190+uint32(b)-144, 9)
+	}
+}
+
+//lazyGzipReaderType opens a gzip stream on the first read
+type lazyGzipReaderType struct {
+	open   func() (*gzip.Reader, error)
+	reader *gzip.Reader
+}
+
+func (r *lazyGzipReaderType) Read(p []byte) (int, error) {
+	if r.reader == nil {
+		var err error
+		r.reader, err = r.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return r.reader.Read(p)
+}
+
+//readIndexed reads a file from StartFromByte or StartFromLine by its seek index.
+//indexed is false if the dumper has nothing to skip or there is no valid index of the file
+func (dumper *DumperType) readIndexed(
+	ctx context.Context,
+	file *os.File,
+	pathToFile string,
+	rowProcessingFunc RowProcessingFuncType,
+) (lineNumber uint64, indexed bool, err error) {
+	startFromByte := dumper.config.StartFromByte != nil && dumper.config.StartFromByte.Position > 0
+	startFromLine := dumper.config.StartFromLine != nil && dumper.config.StartFromLine.Line > 0
+	if !startFromByte && !startFromLine {
+		return
+	}
+	if codec := dumper.config.streamCodec(); codec != CodecGZip && codec != CodecAuto {
+		return
+	}
+	index, err := LoadIndex(pathToFile)
+	if err != nil || index == nil {
+		return
+	}
+
+	var position int64
+	if startFromByte {
+		position = int64(dumper.config.StartFromByte.Position)
+		lineNumber = dumper.config.StartFromByte.FirstLine
+	} else {
+		//a line mark may fall inside a multi-line record
+		if dumper.config.MultiLineRecords || index.LineSeparator != dumper.config.LineSeparator {
+			return
+		}
+		mark := index.LineStart(dumper.config.StartFromLine.Line)
+		position = mark.Position
+		lineNumber = mark.Line
+	}
+
+	stream, closer, err := index.Open(file, position)
+	if err != nil {
+		err = fmt.Errorf("could not open %v by index: %v", pathToFile, err)
+		return
+	}
+	defer closer()
+
+	lineNumber, err = dumper.readDecompressed(ctx, stream, lineNumber, uint64(position), 0, rowProcessingFunc)
+	return lineNumber, true, err
+}
@@ -0,0 +1,165 @@
+package dump
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+)
+
+//testLines makes text of numbered lines of varying lengths with repeated words for back references
+func testLines(random *rand.Rand, count int) []byte {
+	words := []string{"alpha", "beta", "gamma", "delta", "epsilon", "zeta", "eta", "theta"}
+	var data bytes.Buffer
+	for line := 0; line < count; line++ {
+		fmt.Fprintf(&data, "%v", line)
+		for n := random.Intn(12); n > 0; n-- {
+			data.WriteByte('|')
+			if random.Intn(3) == 0 {
+				fmt.Fprintf(&data, "%x", random.Int63())
+			} else {
+				data.WriteString(words[random.Intn(len(words))])
+			}
+		}
+		data.WriteByte('\n')
+	}
+	return data.Bytes()
+}
+
+//writeMembers writes data to a gzip file split into members at random places
+func writeMembers(t *testing.T, pathToFile string, data []byte, level int, members int, random *rand.Rand) {
+	var zipped bytes.Buffer
+	for member := 0; member < members; member++ {
+		end := len(data)
+		if member < members-1 {
+			end = random.Intn(len(data) + 1)
+		}
+		writer, err := gzip.NewWriterLevel(&zipped, level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.Write(data[:end])
+		if err = writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		data = data[end:]
+	}
+	if err := ioutil.WriteFile(pathToFile, zipped.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIndexOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	random := rand.New(rand.NewSource(1))
+	data := testLines(random, 20000)
+	lineCount := uint64(bytes.Count(data, []byte("\n")))
+	levels := []int{gzip.NoCompression, gzip.BestSpeed, gzip.DefaultCompression, gzip.BestCompression, gzip.HuffmanOnly}
+	for _, level := range levels {
+		for _, members := range []int{1, 3} {
+			pathToFile := path.Join(dir, fmt.Sprintf("data.%v.%v.gz", level, members))
+			writeMembers(t, pathToFile, data, level, members, random)
+			built, err := BuildIndex(pathToFile, 16*1024, '\n')
+			if err != nil {
+				t.Fatalf("level %v, %v member(s): %v", level, members, err)
+			}
+			if built.Size != int64(len(data)) || built.LineCount != lineCount || len(built.Members) != members {
+				t.Fatalf("level %v, %v member(s): size %v, %v line(s), %v member(s) indexed",
+					level, members, built.Size, built.LineCount, len(built.Members))
+			}
+			if err = built.Save(IndexFileName(pathToFile)); err != nil {
+				t.Fatal(err)
+			}
+			index, err := LoadIndex(pathToFile)
+			if err != nil || index == nil {
+				t.Fatalf("level %v, %v member(s): index not loaded: %v", level, members, err)
+			}
+			if len(index.Points) < 2 {
+				t.Fatalf("level %v, %v member(s): %v access point(s)", level, members, len(index.Points))
+			}
+
+			file, err := os.Open(pathToFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			positions := []int64{0, int64(len(data))}
+			for _, point := range index.Points {
+				positions = append(positions, point.Uncompressed, point.Uncompressed+1)
+			}
+			for n := 0; n < 50; n++ {
+				positions = append(positions, random.Int63n(int64(len(data))))
+			}
+			for _, position := range positions {
+				if position > int64(len(data)) {
+					continue
+				}
+				stream, closer, err := index.Open(file, position)
+				if err != nil {
+					t.Fatalf("level %v, %v member(s), position %v: %v", level, members, position, err)
+				}
+				read, err := ioutil.ReadAll(stream)
+				closer()
+				if err != nil {
+					t.Fatalf("level %v, %v member(s), position %v: %v", level, members, position, err)
+				}
+				if !bytes.Equal(read, data[position:]) {
+					t.Fatalf("level %v, %v member(s), position %v: %v byte(s) read differ from %v expected",
+						level, members, position, len(read), len(data)-int(position))
+				}
+			}
+			file.Close()
+
+			for n := 0; n < 50; n++ {
+				line := uint64(random.Int63n(int64(lineCount)))
+				mark := index.LineStart(line)
+				if mark.Line > line {
+					t.Fatalf("level %v, %v member(s): line %v marked for line %v", level, members, mark.Line, line)
+				}
+				if mark.Position > 0 && data[mark.Position-1] != '\n' {
+					t.Fatalf("level %v, %v member(s): mark of line %v at %v is not a line start",
+						level, members, mark.Line, mark.Position)
+				}
+				if counted := uint64(bytes.Count(data[:mark.Position], []byte("\n"))); counted != mark.Line {
+					t.Fatalf("level %v, %v member(s): mark at %v is of line %v, %v read sequentially",
+						level, members, mark.Position, mark.Line, counted)
+				}
+			}
+		}
+	}
+}
+
+//TestInflateMemberDistance checks a distance reaching before the start of a member is rejected
+func TestInflateMemberDistance(t *testing.T) {
+	var zipped bytes.Buffer
+	writer := gzip.NewWriter(&zipped)
+	writer.Write([]byte("first member"))
+	writer.Close()
+
+	deflated := &bitWriterType{}
+	deflated.bits(1, 1)
+	deflated.bits(1, 2)
+	deflated.literal('a')
+	//length 3 at distance 2, only a byte of the member is decoded
+	deflated.code(1, 7)
+	deflated.code(1, 5)
+	deflated.code(0, 7)
+	deflated.bits(0, (8-deflated.bitCount%8)%8)
+	zipped.Write([]byte{0x1F, 0x8B, 8, 0, 0, 0, 0, 0, 0, 0xFF})
+	zipped.Write(deflated.data)
+	zipped.Write(make([]byte, 8))
+
+	inflater := newInflater(&zipped)
+	inflater.output = func(decoded []byte) {}
+	if err := inflater.run(); err == nil {
+		t.Fatal("distance beyond the member start is accepted")
+	}
+}
@@ -0,0 +1,526 @@
+package dump
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+//inflater is a deflate decoder reporting bit positions of block starts,
+//which compress/flate hides. It is used to build access points of a gzip index only.
+//It follows RFC 1951 and puff.c by Mark Adler
+
+const (
+	maxCodeBits     = 15
+	fastBits        = 9
+	windowSize      = 32 * 1024
+	inflateOutSize  = 4 * windowSize
+	maxLitLenCodes  = 288
+	maxDistCodes    = 30
+	codeLengthCodes = 19
+)
+
+var (
+	lengthBase  = [...]uint16{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lengthExtra = [...]uint8{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	distBase    = [...]uint16{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra   = [...]uint8{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+	codeOrder   = [codeLengthCodes]uint8{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+)
+
+type bitReaderType struct {
+	reader    *bufio.Reader
+	bytesRead int64
+	buffer    uint64
+	bitCount  uint
+}
+
+//position returns an absolute bit offset of the next bit to read
+func (br *bitReaderType) position() int64 {
+	return br.bytesRead*8 - int64(br.bitCount)
+}
+
+func (br *bitReaderType) need(n uint) error {
+	for br.bitCount < n {
+		b, err := br.reader.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		br.bytesRead++
+		br.buffer |= uint64(b) << br.bitCount
+		br.bitCount += 8
+	}
+	return nil
+}
+
+func (br *bitReaderType) bits(n uint) (uint32, error) {
+	if err := br.need(n); err != nil {
+		return 0, err
+	}
+	value := uint32(br.buffer & (1<<n - 1))
+	br.buffer >>= n
+	br.bitCount -= n
+	return value, nil
+}
+
+func (br *bitReaderType) alignToByte() {
+	drop := br.bitCount % 8
+	br.buffer >>= drop
+	br.bitCount -= drop
+}
+
+//readByte reads a byte aligned to a byte boundary
+func (br *bitReaderType) readByte() (byte, error) {
+	value, err := br.bits(8)
+	return byte(value), err
+}
+
+type huffmanType struct {
+	count  [maxCodeBits + 1]uint16
+	symbol []uint16
+	//fast maps fastBits of input to symbol | length<<9, 0 when a code is longer than fastBits
+	fast [1 << fastBits]uint16
+}
+
+func newHuffman(lengths []uint8) (*huffmanType, error) {
+	h := &huffmanType{symbol: make([]uint16, len(lengths))}
+	for _, length := range lengths {
+		h.count[length]++
+	}
+	h.count[0] = 0
+
+	left := 1
+	for length := 1; length <= maxCodeBits; length++ {
+		left <<= 1
+		left -= int(h.count[length])
+		if left < 0 {
+			return nil, fmt.Errorf("over-subscribed huffman code")
+		}
+	}
+
+	var offsets [maxCodeBits + 2]uint16
+	var nextCode [maxCodeBits + 1]uint32
+	code := uint32(0)
+	for length := 1; length <= maxCodeBits; length++ {
+		offsets[length+1] = offsets[length] + h.count[length]
+		nextCode[length] = code
+		code = (code + uint32(h.count[length])) << 1
+	}
+
+	for sym, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		h.symbol[offsets[length]] = uint16(sym)
+		offsets[length]++
+
+		code := nextCode[length]
+		nextCode[length]++
+		if length > fastBits {
+			continue
+		}
+		reversed := uint32(0)
+		for index := uint8(0); index < length; index++ {
+			reversed |= ((code >> index) & 1) << (length - 1 - index)
+		}
+		for fill := reversed; fill < 1<<fastBits; fill += 1 << length {
+			h.fast[fill] = uint16(sym) | uint16(length)<<9
+		}
+	}
+	return h, nil
+}
+
+func (h *huffmanType) decode(br *bitReaderType) (int, error) {
+	if br.need(fastBits) == nil {
+		entry := h.fast[br.buffer&(1<<fastBits-1)]
+		if entry != 0 {
+			length := uint(entry >> 9)
+			br.buffer >>= length
+			br.bitCount -= length
+			return int(entry & 0x1FF), nil
+		}
+	}
+	code, first, index := 0, 0, 0
+	for length := 1; length <= maxCodeBits; length++ {
+		bit, err := br.bits(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= int(bit)
+		count := int(h.count[length])
+		if code-count < first {
+			return int(h.symbol[index+code-first]), nil
+		}
+		index += count
+		first += count
+		first <<= 1
+		code <<= 1
+	}
+	return 0, fmt.Errorf("invalid huffman code")
+}
+
+var fixedLitLen, fixedDist *huffmanType
+
+func init() {
+	var lengths [maxLitLenCodes]uint8
+	for sym := range lengths {
+		switch {
+		case sym < 144:
+			lengths[sym] = 8
+		case sym < 256:
+			lengths[sym] = 9
+		case sym < 280:
+			lengths[sym] = 7
+		default:
+			lengths[sym] = 8
+		}
+	}
+	fixedLitLen, _ = newHuffman(lengths[:])
+	var distLengths [maxDistCodes]uint8
+	for sym := range distLengths {
+		distLengths[sym] = 5
+	}
+	fixedDist, _ = newHuffman(distLengths[:])
+}
+
+//inflaterType decodes a gzip stream member by member.
+//Decoded bytes are handed to output in pieces, the last windowSize bytes are kept in out
+type inflaterType struct {
+	br *bitReaderType
+	//out holds decoded bytes, out[:flushed] have been passed to output already
+	out     []byte
+	flushed int
+	//total is a number of bytes decoded, memberTotal is that of the current member,
+	//which distances can't reach beyond
+	total       int64
+	memberTotal int64
+	output      func(decoded []byte)
+	//blockStart is called before each deflate block with the bit position of its header
+	blockStart func(bitPosition int64, firstInMember bool)
+	//memberStart is called with a byte offset of a gzip member header
+	memberStart func(offset int64)
+}
+
+func newInflater(stream io.Reader) *inflaterType {
+	return &inflaterType{
+		br:  &bitReaderType{reader: bufio.NewReaderSize(stream, 1024*1024)},
+		out: make([]byte, 0, inflateOutSize),
+	}
+}
+
+//window returns up to windowSize last decoded bytes
+func (f *inflaterType) window() []byte {
+	if len(f.out) > windowSize {
+		return f.out[len(f.out)-windowSize:]
+	}
+	return f.out
+}
+
+func (f *inflaterType) flush() {
+	if f.flushed < len(f.out) {
+		f.output(f.out[f.flushed:])
+		f.flushed = len(f.out)
+	}
+	if len(f.out) > inflateOutSize-maxLitLenCodes {
+		kept := copy(f.out, f.out[len(f.out)-windowSize:])
+		f.out = f.out[:kept]
+		f.flushed = kept
+	}
+}
+
+func (f *inflaterType) put(b byte) {
+	if len(f.out) == cap(f.out) {
+		f.flush()
+	}
+	f.out = append(f.out, b)
+	f.total++
+	f.memberTotal++
+}
+
+//run decodes all members of a gzip stream
+func (f *inflaterType) run() error {
+	for member := 0; ; member++ {
+		offset := f.br.position() / 8
+		id1, err := f.br.readByte()
+		if err == io.ErrUnexpectedEOF && member > 0 {
+			break
+		} else if err != nil {
+			return fmt.Errorf("couldn't read gzip header: %v", err)
+		}
+		id2, err := f.br.readByte()
+		if err != nil || id1 != 0x1F || id2 != 0x8B {
+			if member > 0 {
+				//trailing garbage after the last member
+				break
+			}
+			return fmt.Errorf("not a gzip stream")
+		}
+		if f.memberStart != nil {
+			f.memberStart(offset)
+		}
+		f.memberTotal = 0
+		if err = f.skipHeader(); err != nil {
+			return fmt.Errorf("couldn't read gzip header: %v", err)
+		}
+		if err = f.inflate(); err != nil {
+			return fmt.Errorf("couldn't inflate gzip member %v: %v", member, err)
+		}
+		f.br.alignToByte()
+		for index := 0; index < 8; index++ {
+			if _, err = f.br.readByte(); err != nil {
+				return fmt.Errorf("couldn't read gzip trailer: %v", err)
+			}
+		}
+	}
+	f.flush()
+	return nil
+}
+
+func (f *inflaterType) skipHeader() error {
+	const (
+		flagHCRC    = 1 << 1
+		flagExtra   = 1 << 2
+		flagName    = 1 << 3
+		flagComment = 1 << 4
+	)
+	var head [8]byte
+	for index := range head {
+		b, err := f.br.readByte()
+		if err != nil {
+			return err
+		}
+		head[index] = b
+	}
+	if head[0] != 8 {
+		return fmt.Errorf("unsupported compression method %v", head[0])
+	}
+	flags := head[1]
+	if flags&flagExtra != 0 {
+		lo, err := f.br.readByte()
+		if err != nil {
+			return err
+		}
+		hi, err := f.br.readByte()
+		if err != nil {
+			return err
+		}
+		for index := 0; index < int(lo)|int(hi)<<8; index++ {
+			if _, err = f.br.readByte(); err != nil {
+				return err
+			}
+		}
+	}
+	for _, flag := range []byte{flagName, flagComment} {
+		if flags&flag == 0 {
+			continue
+		}
+		for {
+			b, err := f.br.readByte()
+			if err != nil {
+				return err
+			}
+			if b == 0 {
+				break
+			}
+		}
+	}
+	if flags&flagHCRC != 0 {
+		if _, err := f.br.bits(16); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *inflaterType) inflate() error {
+	for first := true; ; first = false {
+		if f.blockStart != nil {
+			f.blockStart(f.br.position(), first)
+		}
+		final, err := f.br.bits(1)
+		if err != nil {
+			return err
+		}
+		blockType, err := f.br.bits(2)
+		if err != nil {
+			return err
+		}
+		switch blockType {
+		case 0:
+			err = f.stored()
+		case 1:
+			err = f.codes(fixedLitLen, fixedDist)
+		case 2:
+			err = f.dynamic()
+		default:
+			err = fmt.Errorf("invalid block type %v", blockType)
+		}
+		if err != nil {
+			return err
+		}
+		if final == 1 {
+			return nil
+		}
+	}
+}
+
+func (f *inflaterType) stored() error {
+	f.br.alignToByte()
+	length, err := f.br.bits(16)
+	if err != nil {
+		return err
+	}
+	complement, err := f.br.bits(16)
+	if err != nil {
+		return err
+	}
+	if length != ^complement&0xFFFF {
+		return fmt.Errorf("stored block length mismatch")
+	}
+	for ; length > 0; length-- {
+		b, err := f.br.readByte()
+		if err != nil {
+			return err
+		}
+		f.put(b)
+	}
+	return nil
+}
+
+func (f *inflaterType) dynamic() error {
+	hlit, err := f.br.bits(5)
+	if err != nil {
+		return err
+	}
+	hdist, err := f.br.bits(5)
+	if err != nil {
+		return err
+	}
+	hclen, err := f.br.bits(4)
+	if err != nil {
+		return err
+	}
+	nlen, ndist, ncode := int(hlit)+257, int(hdist)+1, int(hclen)+4
+	if nlen > maxLitLenCodes || ndist > maxDistCodes {
+		return fmt.Errorf("bad counts of dynamic block codes")
+	}
+
+	var codeLengths [codeLengthCodes]uint8
+	for index := 0; index < ncode; index++ {
+		length, err := f.br.bits(3)
+		if err != nil {
+			return err
+		}
+		codeLengths[codeOrder[index]] = uint8(length)
+	}
+	lencode, err := newHuffman(codeLengths[:])
+	if err != nil {
+		return err
+	}
+
+	lengths := make([]uint8, nlen+ndist)
+	for index := 0; index < nlen+ndist; {
+		sym, err := lencode.decode(f.br)
+		if err != nil {
+			return err
+		}
+		if sym < 16 {
+			lengths[index] = uint8(sym)
+			index++
+			continue
+		}
+		var repeat uint32
+		var value uint8
+		switch sym {
+		case 16:
+			if index == 0 {
+				return fmt.Errorf("repeat with no previous length")
+			}
+			value = lengths[index-1]
+			repeat, err = f.br.bits(2)
+			repeat += 3
+		case 17:
+			repeat, err = f.br.bits(3)
+			repeat += 3
+		default:
+			repeat, err = f.br.bits(7)
+			repeat += 11
+		}
+		if err != nil {
+			return err
+		}
+		if index+int(repeat) > nlen+ndist {
+			return fmt.Errorf("too many code lengths")
+		}
+		for ; repeat > 0; repeat-- {
+			lengths[index] = value
+			index++
+		}
+	}
+	if lengths[256] == 0 {
+		return fmt.Errorf("no end-of-block code")
+	}
+
+	litlen, err := newHuffman(lengths[:nlen])
+	if err != nil {
+		return err
+	}
+	dist, err := newHuffman(lengths[nlen:])
+	if err != nil {
+		return err
+	}
+	return f.codes(litlen, dist)
+}
+
+func (f *inflaterType) codes(litlen, dist *huffmanType) error {
+	for {
+		sym, err := litlen.decode(f.br)
+		if err != nil {
+			return err
+		}
+		if sym < 256 {
+			f.put(byte(sym))
+			continue
+		}
+		if sym == 256 {
+			return nil
+		}
+		sym -= 257
+		if sym >= len(lengthBase) {
+			return fmt.Errorf("invalid length code")
+		}
+		extra, err := f.br.bits(uint(lengthExtra[sym]))
+		if err != nil {
+			return err
+		}
+		length := int(lengthBase[sym]) + int(extra)
+
+		dsym, err := dist.decode(f.br)
+		if err != nil {
+			return err
+		}
+		if dsym >= len(distBase) {
+			return fmt.Errorf("invalid distance code")
+		}
+		extra, err = f.br.bits(uint(distExtra[dsym]))
+		if err != nil {
+			return err
+		}
+		distance := int(distBase[dsym]) + int(extra)
+		if int64(distance) > f.memberTotal || distance > len(f.out) {
+			return fmt.Errorf("distance too far back")
+		}
+		if len(f.out)+length > cap(f.out) {
+			f.flush()
+		}
+		from := len(f.out) - distance
+		for index := 0; index < length; index++ {
+			f.out = append(f.out, f.out[from+index])
+		}
+		f.total += int64(length)
+		f.memberTotal += int64(length)
+	}
+}
@@ -0,0 +1,227 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var defaultChunkSize = 4 * 1024 * 1024
+
+//chunkType is a piece of a decompressed stream holding complete lines only
+type chunkType struct {
+	sequence       int
+	streamPosition uint64
+	data           []byte
+}
+
+//parsedChunkType keeps lines of a chunk split into cells
+type parsedChunkType struct {
+	*chunkType
+	//lineBounds[i] is the start of line i in data, the last element is len(data)
+	lineBounds []int
+	//cellBounds[i] is the index of the first cell of line i in cells
+	cellBounds []int
+	cells      [][]byte
+	//brokenLine is an index of the first line with an unterminated quoted cell or -1
+	brokenLine int
+}
+
+//readPipelined decompresses, splits and tokenizes the stream on separate goroutines.
+//Rows are passed to rowProcessingFunc sequentially in the stream order
+func (dumper *DumperType) readPipelined(
+	ctx context.Context,
+	stream io.Reader,
+	lineNumber uint64,
+	streamPosition uint64,
+	rowProcessingFunc RowProcessingFuncType,
+) (uint64, error) {
+	parsers := dumper.config.ParsingWorkers
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	//tokens bound the number of chunks held in memory at once
+	tokens := make(chan struct{}, 2*parsers)
+	chunks := make(chan *chunkType, parsers)
+	parsed := make(chan *parsedChunkType, parsers)
+	var readErr error
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(chunks)
+		readErr = dumper.readChunks(ctx, stream, streamPosition, tokens, chunks)
+	}()
+
+	var parsersWg sync.WaitGroup
+	for index := 0; index < parsers; index++ {
+		wg.Add(1)
+		parsersWg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer parsersWg.Done()
+			splitter := newCellSplitter(&dumper.config)
+			for chunk := range chunks {
+				select {
+				case parsed <- dumper.parseChunk(splitter, chunk):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		parsersWg.Wait()
+		close(parsed)
+	}()
+
+	pending := make(map[int]*parsedChunkType)
+	next := 0
+	for chunk := range parsed {
+		pending[chunk.sequence] = chunk
+		for {
+			chunk, found := pending[next]
+			if !found {
+				break
+			}
+			delete(pending, next)
+			next++
+			for index := 0; index < len(chunk.lineBounds)-1; index++ {
+				if index == chunk.brokenLine {
+					return lineNumber, fmt.Errorf(
+						"unterminated quoted cell at line %v, position %v",
+						lineNumber,
+						chunk.streamPosition+uint64(chunk.lineBounds[index]),
+					)
+				}
+				if dumper.config.StartFromLine != nil && lineNumber < dumper.config.StartFromLine.Line {
+					lineNumber++
+					continue
+				}
+				err := rowProcessingFunc(ctx,
+					&dumper.config,
+					lineNumber,
+					chunk.streamPosition+uint64(chunk.lineBounds[index]),
+					chunk.cells[chunk.cellBounds[index]:chunk.cellBounds[index+1]],
+					chunk.data[chunk.lineBounds[index]:chunk.lineBounds[index+1]],
+				)
+				if err != nil {
+					return lineNumber, err
+				}
+				lineNumber++
+			}
+			<-tokens
+		}
+	}
+
+	if ctx.Err() != nil && readErr == nil {
+		return lineNumber, &ErrorAbortedByContext{}
+	}
+	return lineNumber, readErr
+}
+
+//readChunks cuts the stream into chunks at LineSeparator boundaries
+func (dumper *DumperType) readChunks(
+	ctx context.Context,
+	stream io.Reader,
+	streamPosition uint64,
+	tokens chan struct{},
+	chunks chan<- *chunkType,
+) error {
+	chunkSize := dumper.config.ChunkSize
+	var carry []byte
+	for sequence := 0; ; {
+		select {
+		case tokens <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+
+		data := make([]byte, len(carry), len(carry)+chunkSize)
+		copy(data, carry)
+		atEOF := false
+		cut := -1
+		for cut == -1 && !atEOF {
+			filled, err := io.ReadFull(stream, data[len(data):cap(data)])
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				atEOF = true
+			} else if err != nil {
+				return fmt.Errorf("couldn't read data from stream: %v", err)
+			}
+			cut = bytes.LastIndexByte(data[len(data):len(data)+filled], dumper.config.LineSeparator)
+			if cut != -1 {
+				cut += len(data) + 1
+			}
+			data = data[:len(data)+filled]
+			if cut == -1 && !atEOF {
+				data = append(data, make([]byte, chunkSize)...)[:len(data)]
+			}
+		}
+		if atEOF {
+			cut = len(data)
+		}
+		carry = data[cut:]
+		data = data[:cut:cut]
+
+		if len(data) == 0 {
+			<-tokens
+			return nil
+		}
+		select {
+		case chunks <- &chunkType{
+			sequence:       sequence,
+			streamPosition: streamPosition,
+			data:           data,
+		}:
+		case <-ctx.Done():
+			return nil
+		}
+		sequence++
+		streamPosition += uint64(len(data))
+		if atEOF {
+			return nil
+		}
+	}
+}
+
+func (dumper *DumperType) parseChunk(splitter *cellSplitter, chunk *chunkType) *parsedChunkType {
+	result := &parsedChunkType{
+		chunkType:  chunk,
+		lineBounds: []int{0},
+		cellBounds: []int{0},
+		brokenLine: -1,
+	}
+	var cellsData []byte
+	var dataBounds []int
+	for start := 0; start < len(chunk.data); {
+		end := bytes.IndexByte(chunk.data[start:], dumper.config.LineSeparator)
+		if end == -1 {
+			end = len(chunk.data)
+		} else {
+			end += start + 1
+		}
+		lineCells, complete := splitter.split(chunk.data[start:end])
+		if !complete && result.brokenLine == -1 {
+			result.brokenLine = len(result.lineBounds) - 1
+		}
+		for _, cell := range lineCells {
+			dataBounds = append(dataBounds, len(cellsData))
+			cellsData = append(cellsData, cell...)
+		}
+		result.cellBounds = append(result.cellBounds, len(dataBounds))
+		result.lineBounds = append(result.lineBounds, end)
+		start = end
+	}
+	dataBounds = append(dataBounds, len(cellsData))
+	result.cells = make([][]byte, len(dataBounds)-1)
+	for index := range result.cells {
+		result.cells[index] = cellsData[dataBounds[index]:dataBounds[index+1]:dataBounds[index+1]]
+	}
+	return result
+}
@@ -0,0 +1,128 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+type pipelineRowType struct {
+	line     uint64
+	position uint64
+	cells    []string
+	raw      string
+}
+
+func readPipelineRows(t *testing.T, config DumperConfigType, data []byte) (rows []pipelineRowType, lineCount uint64, err error) {
+	dumper, err := NewDumper(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lineCount, err = dumper.ReadFromStream(context.Background(), bytes.NewReader(data), func(
+		cancelContext context.Context,
+		config *DumperConfigType,
+		currentLineNumber uint64,
+		currentStreamPosition uint64,
+		cellsBytes [][]byte,
+		rawLineBytes []byte,
+	) error {
+		row := pipelineRowType{line: currentLineNumber, position: currentStreamPosition, raw: string(rawLineBytes)}
+		for _, cell := range cellsBytes {
+			row.cells = append(row.cells, string(cell))
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	return
+}
+
+//TestReadPipelined checks rows parsed by several workers are the ones of the sequential path in the same order
+func TestReadPipelined(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	var data bytes.Buffer
+	for line := 0; line < 5000; line++ {
+		fmt.Fprintf(&data, "%v", line)
+		for n := random.Intn(6); n > 0; n-- {
+			switch random.Intn(4) {
+			case 0:
+				fmt.Fprintf(&data, `,"quoted ""%v"", with a comma"`, random.Intn(100))
+			case 1:
+				//a line longer than a chunk
+				fmt.Fprintf(&data, ",%v", bytes.Repeat([]byte{'x'}, 300))
+			default:
+				fmt.Fprintf(&data, ",%x", random.Int63())
+			}
+		}
+		data.WriteByte('\n')
+	}
+	data.WriteString("last,line,without,separator")
+
+	sequential := DumperConfigType{
+		ColumnSeparator: ',',
+		LineSeparator:   LineFeedByte,
+		Codec:           CodecNone,
+		QuoteChar:       DoubleQuoteByte,
+	}
+	pipelined := sequential
+	pipelined.ParsingWorkers = 4
+	pipelined.ChunkSize = 256
+
+	for _, start := range []struct {
+		line *DumperStartFromLine
+		byte *DumperStartFromByte
+	}{
+		{},
+		{line: &DumperStartFromLine{Line: 1234}},
+		{byte: &DumperStartFromByte{Position: 0, FirstLine: 0}},
+	} {
+		sequential.StartFromLine, pipelined.StartFromLine = start.line, start.line
+		expected, expectedCount, err := readPipelineRows(t, sequential, data.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if start.byte != nil {
+			//the row at the middle of the stream is the start
+			middle := expected[len(expected)/2]
+			start.byte.Position, start.byte.FirstLine = int(middle.position), middle.line
+			sequential.StartFromByte, pipelined.StartFromByte = start.byte, start.byte
+			expected, expectedCount, err = readPipelineRows(t, sequential, data.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(expected[0], middle) {
+				t.Fatalf("sequential read from %v starts at %+v", start.byte, expected[0])
+			}
+		}
+		rows, lineCount, err := readPipelineRows(t, pipelined, data.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lineCount != expectedCount || len(rows) != len(expected) {
+			t.Fatalf("%v row(s) of %v line(s) read, %v of %v expected", len(rows), lineCount, len(expected), expectedCount)
+		}
+		for index := range rows {
+			if !reflect.DeepEqual(rows[index], expected[index]) {
+				t.Fatalf("row %v is %+v, %+v expected", index, rows[index], expected[index])
+			}
+		}
+	}
+}
+
+func TestReadPipelinedUnterminated(t *testing.T) {
+	config := DumperConfigType{
+		ColumnSeparator: ',',
+		LineSeparator:   LineFeedByte,
+		Codec:           CodecNone,
+		QuoteChar:       DoubleQuoteByte,
+		ParsingWorkers:  2,
+		ChunkSize:       16,
+	}
+	data := []byte("1,a\n2,b\n3,\"c\n4,d\n")
+	rows, _, err := readPipelineRows(t, config, data)
+	if err == nil || len(rows) != 2 {
+		t.Fatalf("%v row(s) read before an unterminated quote, error %v", len(rows), err)
+	}
+}
@@ -0,0 +1,134 @@
+package dump
+
+import "bytes"
+
+//EscapeStyleType defines how a quote character is escaped inside a quoted cell
+type EscapeStyleType int
+
+const (
+	//EscapeDoubled - "" inside a quoted cell stands for a single quote char
+	EscapeDoubled EscapeStyleType = iota
+	//EscapeBackslash - \ inside a quoted cell makes the next byte literal
+	EscapeBackslash
+)
+
+const backslashByte = byte('\\')
+
+//DoubleQuoteByte "   U+0022 quotation mark
+const DoubleQuoteByte = byte('"')
+
+//cellSplitter splits a record into cells honouring quotes and escapes.
+//Unquoted values are copied into its own buffer, so cells it returns
+//stay valid until the next call of split only.
+type cellSplitter struct {
+	separator   byte
+	quote       byte
+	escapeStyle EscapeStyleType
+	buffer      []byte
+	bounds      []int
+	cells       [][]byte
+}
+
+func newCellSplitter(config *DumperConfigType) *cellSplitter {
+	return &cellSplitter{
+		separator:   config.ColumnSeparator,
+		quote:       config.QuoteChar,
+		escapeStyle: config.EscapeStyle,
+	}
+}
+
+//split returns the unquoted cells of the record.
+//complete is false when the record ends inside a quoted cell,
+//which means the line separator belongs to the cell value and the record continues on the next line
+func (s *cellSplitter) split(record []byte) (cells [][]byte, complete bool) {
+	if s.quote == 0 {
+		return SplitDumpLine(record, s.separator), true
+	}
+
+	const (
+		fieldStart = iota
+		unquoted
+		quoted
+		afterQuote
+	)
+
+	trimmed := record
+	if len(trimmed) > 0 {
+		trimmed = TruncateFromCRLF(trimmed)
+	}
+
+	s.buffer = s.buffer[:0]
+	s.bounds = append(s.bounds[:0], 0)
+	state := fieldStart
+	for index := 0; index < len(trimmed); index++ {
+		b := trimmed[index]
+		switch state {
+		case fieldStart:
+			switch b {
+			case s.quote:
+				state = quoted
+			case s.separator:
+				s.bounds = append(s.bounds, len(s.buffer))
+			default:
+				s.buffer = append(s.buffer, b)
+				state = unquoted
+			}
+		case unquoted, afterQuote:
+			if b == s.separator {
+				s.bounds = append(s.bounds, len(s.buffer))
+				state = fieldStart
+			} else {
+				s.buffer = append(s.buffer, b)
+			}
+		case quoted:
+			switch {
+			case s.escapeStyle == EscapeBackslash && b == backslashByte && index+1 < len(trimmed):
+				index++
+				s.buffer = append(s.buffer, trimmed[index])
+			case b == s.quote && s.escapeStyle == EscapeDoubled &&
+				index+1 < len(trimmed) && trimmed[index+1] == s.quote:
+				index++
+				s.buffer = append(s.buffer, b)
+			case b == s.quote:
+				state = afterQuote
+			default:
+				s.buffer = append(s.buffer, b)
+			}
+		}
+	}
+
+	if state == quoted {
+		return nil, false
+	}
+
+	s.bounds = append(s.bounds, len(s.buffer))
+	s.cells = s.cells[:0]
+	for index := 1; index < len(s.bounds); index++ {
+		s.cells = append(s.cells, s.buffer[s.bounds[index-1]:s.bounds[index]:s.bounds[index]])
+	}
+	return s.cells, true
+}
+
+//AppendCell appends a cell to a record of cells separated by separator. The cell is quoted with QuoteChar,
+//escaped the EscapeStyle way, if it has the separator, QuoteChar or a line break, so a dumper of the config
+//splits it back as it is. ok is false when the cell needs quoting, but quoting is off
+func (cfg *DumperConfigType) AppendCell(record, cell []byte, separator byte) (result []byte, ok bool) {
+	if bytes.IndexByte(cell, separator) == -1 && bytes.IndexAny(cell, "\r\n") == -1 &&
+		(cfg.QuoteChar == 0 || bytes.IndexByte(cell, cfg.QuoteChar) == -1) {
+		return append(record, cell...), true
+	}
+	if cfg.QuoteChar == 0 {
+		return record, false
+	}
+	record = append(record, cfg.QuoteChar)
+	for _, b := range cell {
+		switch {
+		case b == cfg.QuoteChar && cfg.EscapeStyle == EscapeDoubled:
+			record = append(record, b)
+		case (b == cfg.QuoteChar || b == backslashByte) && cfg.EscapeStyle == EscapeBackslash:
+			record = append(record, backslashByte)
+		}
+		record = append(record, b)
+	}
+	return append(record, cfg.QuoteChar), true
+}
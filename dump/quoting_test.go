@@ -0,0 +1,197 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCellSplitter(t *testing.T) {
+	tests := []struct {
+		record      string
+		escapeStyle EscapeStyleType
+		cells       []string
+		complete    bool
+	}{
+		{`a,b,,c` + "\n", EscapeDoubled, []string{"a", "b", "", "c"}, true},
+		{`"a","b,c"` + "\r\n", EscapeDoubled, []string{"a", "b,c"}, true},
+		{`"say ""hi""",x`, EscapeDoubled, []string{`say "hi"`, "x"}, true},
+		{`"""",""`, EscapeDoubled, []string{`"`, ""}, true},
+		{`"say \"hi\"",x`, EscapeBackslash, []string{`say "hi"`, "x"}, true},
+		{`"a\\",b`, EscapeBackslash, []string{`a\`, "b"}, true},
+		{`"a\,b",c`, EscapeBackslash, []string{"a,b", "c"}, true},
+		{`"a""b"`, EscapeBackslash, []string{`a"b"`}, true},
+		//a quote in the middle of a cell is a part of the value
+		{`ab"c,d"e`, EscapeDoubled, []string{`ab"c`, `d"e`}, true},
+		//a value following a closing quote is joined to the quoted one
+		{`"ab"c,d`, EscapeDoubled, []string{"abc", "d"}, true},
+		{`"a` + "\n", EscapeDoubled, nil, false},
+		{`"a,b""`, EscapeDoubled, nil, false},
+		{`x,"a\"`, EscapeBackslash, nil, false},
+		{`"a` + "\nb\"\n", EscapeDoubled, []string{"a\nb"}, true},
+		{"", EscapeDoubled, []string{""}, true},
+	}
+	for _, test := range tests {
+		splitter := newCellSplitter(&DumperConfigType{
+			ColumnSeparator: ',',
+			QuoteChar:       DoubleQuoteByte,
+			EscapeStyle:     test.escapeStyle,
+		})
+		cells, complete := splitter.split([]byte(test.record))
+		if complete != test.complete {
+			t.Errorf("%q is complete %v, %v expected", test.record, complete, test.complete)
+			continue
+		}
+		var values []string
+		for _, cell := range cells {
+			values = append(values, string(cell))
+		}
+		if !reflect.DeepEqual(values, test.cells) {
+			t.Errorf("%q split into %q, %q expected", test.record, values, test.cells)
+		}
+	}
+}
+
+func TestCellSplitterNoQuote(t *testing.T) {
+	splitter := newCellSplitter(&DumperConfigType{ColumnSeparator: ','})
+	cells, complete := splitter.split([]byte(`"a,b",c` + "\n"))
+	if !complete || len(cells) != 3 || string(cells[0]) != `"a` || string(cells[2]) != "c" {
+		t.Fatalf("quotes are honoured with quoting off: %q", cells)
+	}
+}
+
+func TestAppendCell(t *testing.T) {
+	tests := []struct {
+		cell        string
+		escapeStyle EscapeStyleType
+		record      string
+	}{
+		{"abc", EscapeDoubled, "x;abc"},
+		{"", EscapeDoubled, "x;"},
+		{"a;b", EscapeDoubled, `x;"a;b"`},
+		{"a,b", EscapeDoubled, "x;a,b"},
+		{"a\nb", EscapeDoubled, "x;\"a\nb\""},
+		{"a\r", EscapeDoubled, "x;\"a\r\""},
+		{`say "hi"`, EscapeDoubled, `x;"say ""hi"""`},
+		{`say "hi"`, EscapeBackslash, `x;"say \"hi\""`},
+		{`a\b;c`, EscapeBackslash, `x;"a\\b;c"`},
+		{`a\b`, EscapeBackslash, `x;a\b`},
+	}
+	for _, test := range tests {
+		config := &DumperConfigType{ColumnSeparator: ';', QuoteChar: DoubleQuoteByte, EscapeStyle: test.escapeStyle}
+		record, ok := config.AppendCell([]byte("x;"), []byte(test.cell), ';')
+		if !ok || string(record) != test.record {
+			t.Errorf("%q appended as %q", test.cell, record)
+			continue
+		}
+		//cells are split back as they are
+		cells, complete := newCellSplitter(config).split(record)
+		if !complete || len(cells) != 2 || string(cells[1]) != test.cell {
+			t.Errorf("%q split back into %q", record, cells)
+		}
+	}
+
+	config := &DumperConfigType{ColumnSeparator: ';'}
+	for cell, ok := range map[string]bool{`"a"`: true, "a;b": false, "a\nb": false} {
+		if record, appended := config.AppendCell(nil, []byte(cell), ';'); appended != ok || ok && string(record) != cell {
+			t.Errorf("%q appended as %q with quoting off: %v", cell, record, appended)
+		}
+	}
+}
+
+//TestCellSplitterReuse checks cells of a call hold their values until the next call
+func TestCellSplitterReuse(t *testing.T) {
+	splitter := newCellSplitter(&DumperConfigType{ColumnSeparator: ',', QuoteChar: DoubleQuoteByte})
+	record := []byte(`"first value",second`)
+	cells, _ := splitter.split(record)
+	copy(record, bytes.Repeat([]byte{'x'}, len(record)))
+	if string(cells[0]) != "first value" || string(cells[1]) != "second" {
+		t.Fatalf("cells %q depend on the record", cells)
+	}
+
+	cells, _ = splitter.split([]byte(`a,"b"`))
+	if len(cells) != 2 || string(cells[0]) != "a" || string(cells[1]) != "b" {
+		t.Fatalf("cells %q of a shorter record", cells)
+	}
+	//appending to a cell must not overwrite the next one sharing the buffer
+	_ = append(cells[0], 'z')
+	if string(cells[1]) != "b" {
+		t.Fatalf("cell %q is overwritten", cells[1])
+	}
+
+	cells, _ = splitter.split([]byte(`"a long value to grow the buffer",2,3`))
+	if len(cells) != 3 || string(cells[0]) != "a long value to grow the buffer" || string(cells[2]) != "3" {
+		t.Fatalf("cells %q of a longer record", cells)
+	}
+}
+
+type testRowType struct {
+	line     uint64
+	position uint64
+	cells    []string
+}
+
+func readTestRows(t *testing.T, config *DumperConfigType, data string) (rows []testRowType, lines uint64, err error) {
+	dumper, err := NewDumper(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, err = dumper.ReadFromStream(context.Background(), bytes.NewReader([]byte(data)), func(
+		cancelContext context.Context,
+		config *DumperConfigType,
+		currentLineNumber uint64,
+		currentStreamPosition uint64,
+		cellsBytes [][]byte,
+		rawLineBytes []byte,
+	) error {
+		row := testRowType{line: currentLineNumber, position: currentStreamPosition}
+		for _, cell := range cellsBytes {
+			row.cells = append(row.cells, string(cell))
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	return
+}
+
+//TestMultiLineRecords checks records spanning lines are numbered by the physical lines they start at
+func TestMultiLineRecords(t *testing.T) {
+	data := "1,\"a\nb\"\n2,\"c\n\nd\"\n3,e"
+	config := &DumperConfigType{
+		ColumnSeparator:  ',',
+		LineSeparator:    LineFeedByte,
+		Codec:            CodecNone,
+		QuoteChar:        DoubleQuoteByte,
+		MultiLineRecords: true,
+		//records are longer than the buffer
+		BufferSize: 16,
+	}
+	rows, lines, err := readTestRows(t, config, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []testRowType{
+		{0, 0, []string{"1", "a\nb"}},
+		{2, 8, []string{"2", "c\n\nd"}},
+		{5, 17, []string{"3", "e"}},
+	}
+	if !reflect.DeepEqual(rows, expected) || lines != 6 {
+		t.Fatalf("rows %+v of %v line(s) read", rows, lines)
+	}
+
+	config.StartFromLine = &DumperStartFromLine{Line: 2}
+	rows, _, err = readTestRows(t, config, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].line != 2 {
+		t.Fatalf("rows %+v read from line 2", rows)
+	}
+
+	config.StartFromLine = nil
+	rows, _, err = readTestRows(t, config, data+",\"f\n")
+	if err == nil {
+		t.Fatalf("unterminated quote at the end is read as %+v", rows)
+	}
+}
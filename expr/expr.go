@@ -0,0 +1,92 @@
+//Package expr compiles row filter expressions like
+//   amount > 100 AND currency IN ('USD','EUR') AND NOT vendor LIKE 'TEST%'
+//to functions matching cells of dump rows.
+//
+//Operands are column names, case-insensitive, double-quoted when they clash with keywords or contain odd chars,
+//and fusion sub-field references column/position/size (see dump.FusionType), size may be omitted.
+//Predicates are
+//   operand = <> != < <= > >= literal
+//   operand [NOT] BETWEEN literal AND literal
+//   operand [NOT] IN (literal, ...)
+//   operand [NOT] LIKE 'pattern'     % matches any sequence, _ any char, \ escapes them
+//   operand [NOT] MATCHES 'regexp'   RE2 syntax, unanchored
+//   operand IS [NOT] NULL            true when a row has no such cell or fusion sub-field
+//   operand IS [NOT] EMPTY           true when the value is null or blank
+//combined with AND, OR, NOT and parentheses.
+//A literal is a 'string', a number or DATE 'date'. A number or a date literal makes the comparison numeric or temporal,
+//values which can't be parsed as numbers or dates (see ParseNumber and ParseTime) don't match the predicate,
+//as well as null values do not. As in SQL, such values make a predicate unknown rather than false,
+//so they don't match it negated by NOT either, and a row matches an expression only if it's true
+package expr
+
+import (
+	"fmt"
+
+	"github.com/ovlad32/geq/dump"
+)
+
+//ColumnResolverFuncType returns a zero-based position of a column by its name
+type ColumnResolverFuncType func(name string) (position int, err error)
+
+//ConfigType tells how operands of an expression are resolved
+type ConfigType struct {
+	Columns ColumnResolverFuncType
+	//Fusion addresses fusion sub-fields, nil makes fusion references invalid
+	Fusion *dump.FusionType
+}
+
+//truthType is a value of three-valued logic ordered so that AND is the least of its operands and OR is the greatest
+type truthType int8
+
+const (
+	truthFalse truthType = iota
+	truthUnknown
+	truthTrue
+)
+
+func truth(value bool) truthType {
+	if value {
+		return truthTrue
+	}
+	return truthFalse
+}
+
+type matchFuncType func(cells [][]byte) truthType
+
+//ExpressionType is a compiled expression, safe for concurrent use
+type ExpressionType struct {
+	source string
+	match  matchFuncType
+}
+
+//Compile parses an expression resolving its column references
+func Compile(source string, config *ConfigType) (expression *ExpressionType, err error) {
+	if config == nil || config.Columns == nil {
+		err = fmt.Errorf("column resolver is not defined")
+		return
+	}
+	tokens, err := tokenize(source)
+	if err != nil {
+		err = fmt.Errorf("could not parse expression %v: %v", source, err)
+		return
+	}
+	p := &parserType{tokens: tokens, config: config}
+	match, err := p.parseOr()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = p.unexpected()
+	}
+	if err != nil {
+		err = fmt.Errorf("could not parse expression %v: %v", source, err)
+		return
+	}
+	return &ExpressionType{source: source, match: match}, nil
+}
+
+//Match tells if cells of a row satisfy the expression
+func (expression *ExpressionType) Match(cells [][]byte) bool {
+	return expression.match(cells) == truthTrue
+}
+
+func (expression *ExpressionType) String() string {
+	return expression.source
+}
@@ -0,0 +1,86 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var testColumns = []string{"id", "amount", "name", "created"}
+
+func compileTest(t *testing.T, source string) *ExpressionType {
+	expression, err := Compile(source, &ConfigType{
+		Columns: func(name string) (int, error) {
+			for position, column := range testColumns {
+				if strings.EqualFold(column, name) {
+					return position, nil
+				}
+			}
+			return 0, fmt.Errorf("no such column")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return expression
+}
+
+func testRow(cells ...string) [][]byte {
+	result := make([][]byte, len(cells))
+	for index, cell := range cells {
+		result[index] = []byte(cell)
+	}
+	return result
+}
+
+func TestMatch(t *testing.T) {
+	full := testRow("1", "3", "TEST-1", "2020-05-01")
+	text := testRow("1", "n/a", "TEST-1", "unknown")
+	short := testRow("1")
+	tests := []struct {
+		source string
+		row    [][]byte
+		match  bool
+	}{
+		{"amount = 3", full, true},
+		{"amount <> 3", full, false},
+		{"amount < 3", text, false},
+		{"amount >= 3", text, false},
+		{"amount BETWEEN 1 AND 5", full, true},
+		{"amount NOT BETWEEN 1 AND 5", full, false},
+		{"amount NOT BETWEEN 4 AND 5", full, true},
+		{"amount NOT BETWEEN 1 AND 5", text, false},
+		{"amount NOT BETWEEN 1 AND 5", short, false},
+		{"amount IN (1, 2, 3)", full, true},
+		{"amount NOT IN (1, 2)", full, true},
+		{"amount NOT IN (1, 2)", short, false},
+		{"amount NOT IN (1, 2)", text, false},
+		{"amount IN ('n/a', 2)", text, true},
+		{"amount NOT IN ('x', 2)", text, false},
+		{"name LIKE 'TEST%'", full, true},
+		{"name NOT LIKE 'TEST%'", full, false},
+		{"name NOT LIKE 'PROD%'", full, true},
+		{"name NOT LIKE 'PROD%'", short, false},
+		{"name NOT MATCHES '^P'", short, false},
+		{"NOT (name = 'x')", full, true},
+		{"NOT (name = 'x')", short, false},
+		{"NOT (amount > 1)", text, false},
+		{"NOT NOT amount > 1", text, false},
+		{"created > DATE '2020-01-01'", full, true},
+		{"NOT created > DATE '2020-01-01'", text, false},
+		{"name IS NULL", short, true},
+		{"NOT name IS NULL", short, false},
+		{"name IS NOT NULL", short, false},
+		{"NOT name IS EMPTY", full, true},
+		{"amount > 1 OR name IS NULL", short, true},
+		{"amount > 1 OR NOT name = 'x'", short, false},
+		{"NOT (amount > 1 AND name = 'x')", short, false},
+		{"NOT (amount > 1 AND id = '2')", short, true},
+		{"NOT (amount > 1 OR id = '1')", short, false},
+	}
+	for _, test := range tests {
+		if match := compileTest(t, test.source).Match(test.row); match != test.match {
+			t.Errorf("%v on %q: %v, %v expected", test.source, test.row, match, test.match)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	//tokenIdent is a column name or a keyword
+	tokenIdent
+	//tokenQuotedIdent is a column name in double quotes
+	tokenQuotedIdent
+	//tokenString is a literal in single quotes
+	tokenString
+	tokenNumber
+	//tokenPunct is a parenthesis, a comma, a fusion slash or a comparison operator
+	tokenPunct
+)
+
+type tokenType struct {
+	kind tokenKind
+	text string
+	//position is a byte offset of the token in the source
+	position int
+}
+
+func (t tokenType) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return fmt.Sprintf("'%v'", t.text)
+	case tokenQuotedIdent:
+		return fmt.Sprintf("\"%v\"", t.text)
+	}
+	return t.text
+}
+
+//is tells if the token is the keyword word or the punctuation mark word
+func (t tokenType) is(word string) bool {
+	switch t.kind {
+	case tokenIdent:
+		return strings.EqualFold(t.text, word)
+	case tokenPunct:
+		return t.text == word
+	}
+	return false
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+//tokenize splits source to tokens.
+//Quotes inside quoted identifiers and strings are escaped by doubling them, as in SQL
+func tokenize(source string) (tokens []tokenType, err error) {
+	for i := 0; ; {
+		for i < len(source) && strings.IndexByte(" \t\r\n", source[i]) != -1 {
+			i++
+		}
+		if i == len(source) {
+			tokens = append(tokens, tokenType{kind: tokenEOF, position: i})
+			return
+		}
+		start := i
+		c := source[i]
+		switch {
+		case isIdentStart(c):
+			for i < len(source) && (isIdentStart(source[i]) || isDigit(source[i])) {
+				i++
+			}
+			tokens = append(tokens, tokenType{kind: tokenIdent, text: source[start:i], position: start})
+		case isDigit(c) || (c == '-' || c == '.') && i+1 < len(source) && (isDigit(source[i+1]) || source[i+1] == '.'):
+			i++
+			for i < len(source) && (isDigit(source[i]) || source[i] == '.' ||
+				(source[i] == 'e' || source[i] == 'E') ||
+				(source[i] == '-' || source[i] == '+') && (source[i-1] == 'e' || source[i-1] == 'E')) {
+				i++
+			}
+			tokens = append(tokens, tokenType{kind: tokenNumber, text: source[start:i], position: start})
+		case c == '\'' || c == '"':
+			var text strings.Builder
+			for i++; ; i++ {
+				if i == len(source) {
+					err = fmt.Errorf("unterminated quoted text at %v", start)
+					return nil, err
+				}
+				if source[i] == c {
+					if i+1 < len(source) && source[i+1] == c {
+						i++
+					} else {
+						i++
+						break
+					}
+				}
+				text.WriteByte(source[i])
+			}
+			kind := tokenString
+			if c == '"' {
+				kind = tokenQuotedIdent
+			}
+			tokens = append(tokens, tokenType{kind: kind, text: text.String(), position: start})
+		default:
+			for _, punct := range []string{"<>", "!=", "<=", ">=", "=", "<", ">", "(", ")", ",", "/"} {
+				if strings.HasPrefix(source[i:], punct) {
+					i += len(punct)
+					break
+				}
+			}
+			if i == start {
+				err = fmt.Errorf("unexpected character %q at %v", c, start)
+				return nil, err
+			}
+			tokens = append(tokens, tokenType{kind: tokenPunct, text: source[start:i], position: start})
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package expr
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//keywords can't be used as bare column names
+var keywords = map[string]bool{
+	"AND": true, "OR": true, "NOT": true, "IN": true, "LIKE": true, "MATCHES": true,
+	"BETWEEN": true, "IS": true, "NULL": true, "EMPTY": true, "DATE": true,
+}
+
+//valueFuncType returns a value of an operand, present is false for a null value
+type valueFuncType func(cells [][]byte) (value []byte, present bool)
+
+type literalKind int
+
+const (
+	literalString literalKind = iota
+	literalNumber
+	literalTime
+)
+
+type literalType struct {
+	kind   literalKind
+	text   string
+	number float64
+	time   time.Time
+}
+
+//compare compares a value with the literal, ok is false if the value isn't of the literal kind
+func (l *literalType) compare(value []byte) (result int, ok bool) {
+	switch l.kind {
+	case literalNumber:
+		number, ok := ParseNumber(value)
+		if !ok {
+			return 0, false
+		}
+		if number < l.number {
+			return -1, true
+		} else if number > l.number {
+			return 1, true
+		}
+		return 0, true
+	case literalTime:
+		t, ok := ParseTime(value)
+		if !ok {
+			return 0, false
+		}
+		if t.Before(l.time) {
+			return -1, true
+		} else if t.After(l.time) {
+			return 1, true
+		}
+		return 0, true
+	}
+	return bytes.Compare(value, []byte(l.text)), true
+}
+
+//recursive descent parser, NOT binds tighter than AND, AND binds tighter than OR
+type parserType struct {
+	tokens   []tokenType
+	position int
+	config   *ConfigType
+}
+
+func (p *parserType) peek() tokenType {
+	return p.tokens[p.position]
+}
+
+func (p *parserType) next() tokenType {
+	t := p.tokens[p.position]
+	if t.kind != tokenEOF {
+		p.position++
+	}
+	return t
+}
+
+//accept consumes the next token if it is the keyword or the punctuation mark word
+func (p *parserType) accept(word string) bool {
+	if p.peek().is(word) {
+		p.position++
+		return true
+	}
+	return false
+}
+
+func (p *parserType) expect(word string) error {
+	if !p.accept(word) {
+		return fmt.Errorf("%v expected at %v, got %v", word, p.peek().position, p.peek())
+	}
+	return nil
+}
+
+func (p *parserType) unexpected() error {
+	return fmt.Errorf("unexpected %v at %v", p.peek(), p.peek().position)
+}
+
+func (p *parserType) parseOr() (match matchFuncType, err error) {
+	match, err = p.parseAnd()
+	for err == nil && p.accept("OR") {
+		var right matchFuncType
+		right, err = p.parseAnd()
+		left := match
+		match = func(cells [][]byte) truthType {
+			result := left(cells)
+			if result == truthTrue {
+				return result
+			}
+			if other := right(cells); other > result {
+				return other
+			}
+			return result
+		}
+	}
+	return
+}
+
+func (p *parserType) parseAnd() (match matchFuncType, err error) {
+	match, err = p.parseNot()
+	for err == nil && p.accept("AND") {
+		var right matchFuncType
+		right, err = p.parseNot()
+		left := match
+		match = func(cells [][]byte) truthType {
+			result := left(cells)
+			if result == truthFalse {
+				return result
+			}
+			if other := right(cells); other < result {
+				return other
+			}
+			return result
+		}
+	}
+	return
+}
+
+func (p *parserType) parseNot() (match matchFuncType, err error) {
+	if p.accept("NOT") {
+		match, err = p.parseNot()
+		return negate(match, true), err
+	}
+	if p.accept("(") {
+		match, err = p.parseOr()
+		if err == nil {
+			err = p.expect(")")
+		}
+		return
+	}
+	return p.parsePredicate()
+}
+
+//negate swaps true and false, unknown stays unknown
+func negate(match matchFuncType, negated bool) matchFuncType {
+	if !negated || match == nil {
+		return match
+	}
+	return func(cells [][]byte) truthType {
+		return truthTrue - match(cells)
+	}
+}
+
+func (p *parserType) parsePredicate() (match matchFuncType, err error) {
+	value, err := p.parseOperand()
+	if err != nil {
+		return
+	}
+
+	if p.accept("IS") {
+		negated := p.accept("NOT")
+		switch {
+		case p.accept("NULL"):
+			match = func(cells [][]byte) truthType {
+				_, present := value(cells)
+				return truth(!present)
+			}
+		case p.accept("EMPTY"):
+			match = func(cells [][]byte) truthType {
+				v, present := value(cells)
+				return truth(!present || len(bytes.TrimSpace(v)) == 0)
+			}
+		default:
+			return nil, fmt.Errorf("NULL or EMPTY expected at %v, got %v", p.peek().position, p.peek())
+		}
+		return negate(match, negated), nil
+	}
+
+	negated := p.accept("NOT")
+	switch {
+	case p.accept("IN"):
+		match, err = p.parseIn(value)
+	case p.accept("BETWEEN"):
+		match, err = p.parseBetween(value)
+	case p.accept("LIKE"):
+		match, err = p.parsePattern(value, likePattern)
+	case p.accept("MATCHES"):
+		match, err = p.parsePattern(value, func(s string) string { return s })
+	default:
+		if negated {
+			return nil, fmt.Errorf("IN, BETWEEN, LIKE or MATCHES expected at %v, got %v", p.peek().position, p.peek())
+		}
+		match, err = p.parseComparison(value)
+	}
+	return negate(match, negated), err
+}
+
+//parseOperand reads a column reference with an optional fusion position and size
+func (p *parserType) parseOperand() (value valueFuncType, err error) {
+	t := p.next()
+	if t.kind != tokenIdent && t.kind != tokenQuotedIdent || t.kind == tokenIdent && keywords[strings.ToUpper(t.text)] {
+		return nil, fmt.Errorf("column name expected at %v, got %v", t.position, t)
+	}
+	column, err := p.config.Columns(t.text)
+	if err != nil {
+		return nil, fmt.Errorf("column %v at %v: %v", t.text, t.position, err)
+	}
+	value = func(cells [][]byte) ([]byte, bool) {
+		if column >= len(cells) {
+			return nil, false
+		}
+		return cells[column], true
+	}
+	if !p.accept("/") {
+		return value, nil
+	}
+
+	if p.config.Fusion == nil {
+		return nil, fmt.Errorf("fusion reference at %v, but fusion separator is not defined", t.position)
+	}
+	fusionPosition, err := p.parseInteger("fusion position")
+	if err != nil {
+		return
+	}
+	fusionSize := 0
+	if p.accept("/") {
+		fusionSize, err = p.parseInteger("fusion size")
+		if err != nil {
+			return
+		}
+	}
+	if fusionPosition < 1 || fusionSize < 0 || fusionSize > 0 && fusionPosition > fusionSize {
+		return nil, fmt.Errorf("fusion position %v of size %v at %v is out of range", fusionPosition, fusionSize, t.position)
+	}
+	fusion := p.config.Fusion
+	cell := value
+	value = func(cells [][]byte) ([]byte, bool) {
+		v, present := cell(cells)
+		if !present {
+			return nil, false
+		}
+		return fusion.Field(v, fusionPosition, fusionSize)
+	}
+	return value, nil
+}
+
+func (p *parserType) parseInteger(what string) (result int, err error) {
+	t := p.next()
+	if t.kind == tokenNumber {
+		result, err = strconv.Atoi(t.text)
+	}
+	if t.kind != tokenNumber || err != nil {
+		return 0, fmt.Errorf("%v expected at %v, got %v", what, t.position, t)
+	}
+	return
+}
+
+func (p *parserType) parseLiteral() (literal *literalType, err error) {
+	t := p.next()
+	switch {
+	case t.kind == tokenString:
+		return &literalType{kind: literalString, text: t.text}, nil
+	case t.kind == tokenNumber:
+		literal = &literalType{kind: literalNumber, text: t.text}
+		literal.number, err = strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %v at %v", t.text, t.position)
+		}
+		return
+	case t.is("DATE"):
+		s := p.next()
+		if s.kind != tokenString {
+			return nil, fmt.Errorf("quoted date expected at %v, got %v", s.position, s)
+		}
+		literal = &literalType{kind: literalTime, text: s.text}
+		var ok bool
+		literal.time, ok = ParseTime([]byte(s.text))
+		if !ok {
+			return nil, fmt.Errorf("date %v at %v is not recognized", s, s.position)
+		}
+		return
+	}
+	return nil, fmt.Errorf("literal expected at %v, got %v", t.position, t)
+}
+
+func (p *parserType) parseComparison(value valueFuncType) (match matchFuncType, err error) {
+	t := p.next()
+	var accepts func(result int) bool
+	switch t.text {
+	case "=":
+		accepts = func(result int) bool { return result == 0 }
+	case "<>", "!=":
+		accepts = func(result int) bool { return result != 0 }
+	case "<":
+		accepts = func(result int) bool { return result < 0 }
+	case "<=":
+		accepts = func(result int) bool { return result <= 0 }
+	case ">":
+		accepts = func(result int) bool { return result > 0 }
+	case ">=":
+		accepts = func(result int) bool { return result >= 0 }
+	}
+	if t.kind != tokenPunct || accepts == nil {
+		return nil, fmt.Errorf("comparison operator expected at %v, got %v", t.position, t)
+	}
+	literal, err := p.parseLiteral()
+	if err != nil {
+		return
+	}
+	match = func(cells [][]byte) truthType {
+		v, present := value(cells)
+		if !present {
+			return truthUnknown
+		}
+		result, ok := literal.compare(v)
+		if !ok {
+			return truthUnknown
+		}
+		return truth(accepts(result))
+	}
+	return
+}
+
+func (p *parserType) parseBetween(value valueFuncType) (match matchFuncType, err error) {
+	low, err := p.parseLiteral()
+	if err != nil {
+		return
+	}
+	if err = p.expect("AND"); err != nil {
+		return
+	}
+	high, err := p.parseLiteral()
+	if err != nil {
+		return
+	}
+	match = func(cells [][]byte) truthType {
+		v, present := value(cells)
+		if !present {
+			return truthUnknown
+		}
+		lowResult, lowOk := low.compare(v)
+		highResult, highOk := high.compare(v)
+		if !lowOk || !highOk {
+			return truthUnknown
+		}
+		return truth(lowResult >= 0 && highResult <= 0)
+	}
+	return
+}
+
+func (p *parserType) parseIn(value valueFuncType) (match matchFuncType, err error) {
+	if err = p.expect("("); err != nil {
+		return
+	}
+	//string literals are looked up in a set, the other ones are compared one by one
+	texts := make(map[string]bool)
+	var others []*literalType
+	for {
+		var literal *literalType
+		literal, err = p.parseLiteral()
+		if err != nil {
+			return
+		}
+		if literal.kind == literalString {
+			texts[literal.text] = true
+		} else {
+			others = append(others, literal)
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	if err = p.expect(")"); err != nil {
+		return
+	}
+	//a value not found is unknown rather than false if it can't be compared with one of the literals
+	match = func(cells [][]byte) truthType {
+		v, present := value(cells)
+		if !present {
+			return truthUnknown
+		}
+		if texts[string(v)] {
+			return truthTrue
+		}
+		found := truthFalse
+		for _, literal := range others {
+			result, ok := literal.compare(v)
+			if !ok {
+				found = truthUnknown
+			} else if result == 0 {
+				return truthTrue
+			}
+		}
+		return found
+	}
+	return
+}
+
+func (p *parserType) parsePattern(value valueFuncType, toRegexp func(string) string) (match matchFuncType, err error) {
+	t := p.next()
+	if t.kind != tokenString {
+		return nil, fmt.Errorf("quoted pattern expected at %v, got %v", t.position, t)
+	}
+	re, err := regexp.Compile(toRegexp(t.text))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %v at %v: %v", t, t.position, err)
+	}
+	match = func(cells [][]byte) truthType {
+		v, present := value(cells)
+		if !present {
+			return truthUnknown
+		}
+		return truth(re.Match(v))
+	}
+	return
+}
+
+//likePattern translates a LIKE pattern to an anchored regular expression
+func likePattern(pattern string) string {
+	var result strings.Builder
+	result.WriteString("(?s)^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '%':
+			result.WriteString(".*")
+		case c == '_':
+			result.WriteString(".")
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			result.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			result.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	result.WriteString("$")
+	return result.String()
+}
@@ -0,0 +1,54 @@
+package expr
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+)
+
+//TimeLayouts are formats of dates and timestamps recognized in dump cells, tried in order
+var TimeLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05.999999999",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006/01/02",
+	"2006/01/02 15:04:05",
+	"02-Jan-06",
+	"02-Jan-2006",
+	"02-Jan-2006 15:04:05",
+	"02.01.2006",
+	"02.01.2006 15:04:05",
+	"20060102",
+}
+
+//ParseNumber reads a decimal number from a cell, surrounding spaces are ignored
+func ParseNumber(cell []byte) (number float64, ok bool) {
+	cell = bytes.TrimSpace(cell)
+	if len(cell) == 0 || bytes.IndexByte([]byte("0123456789+-."), cell[0]) == -1 {
+		return 0, false
+	}
+	number, err := strconv.ParseFloat(string(cell), 64)
+	return number, err == nil
+}
+
+//ParseTime reads a date or a timestamp from a cell in one of TimeLayouts, surrounding spaces are ignored
+func ParseTime(cell []byte) (value time.Time, ok bool) {
+	cell = bytes.TrimSpace(cell)
+	//all layouts start with a digit and have date parts separated, but the compact one
+	if len(cell) < 6 || cell[0] < '0' || cell[0] > '9' ||
+		bytes.IndexAny(cell, "-/.") == -1 && len(cell) != len("20060102") {
+		return value, false
+	}
+	s := string(cell)
+	for _, layout := range TimeLayouts {
+		value, err := time.Parse(layout, s)
+		if err == nil {
+			return value, true
+		}
+	}
+	return value, false
+}
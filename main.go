@@ -3,21 +3,27 @@ package main
 import (
 	"bytes"
 	"container/list"
+	"context"
 	"encoding/json"
 	"flag"
-	"io"
 	"io/ioutil"
+	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 
+	"github.com/ovlad32/geq/dump"
 	"github.com/pkg/errors"
 )
 
 var pfout = flag.String("o", "", "")
 var pfin = flag.String("i", "", "")
 var cmd = flag.String("c", "", "")
+var workers = flag.Int("workers", runtime.NumCPU(), "")
+var parsers = flag.Int("parsers", 1, "")
 
 func main() {
 	flag.Parse()
@@ -35,6 +41,16 @@ func main() {
 		Filter()
 	} else if *cmd == "u" {
 		Pgu()
+	} else if *cmd == "i" {
+		Index()
+	} else if *cmd == "l" {
+		Lookup()
+	} else if *cmd == "p" || *cmd == "profile" {
+		Profile()
+	} else if *cmd == "d" || *cmd == "fusion" {
+		Fusion()
+	} else if *cmd == "s" || *cmd == "select" {
+		Select()
 	}
 
 }
@@ -47,7 +63,7 @@ func split(path string) (dir, file string) {
 	return path[:i+1], path[i+1:]
 }
 
-func allFiles(p, pext string) (result []string) {
+func allFiles(p string, pexts ...string) (result []string) {
 	result = make([]string, 0, 10)
 	all := list.New()
 	all.PushBack(p)
@@ -69,8 +85,11 @@ func allFiles(p, pext string) (result []string) {
 				all.PushBack(path.Join(curdir, f.Name()))
 			} else {
 				ext := path.Ext(strings.ToLower(f.Name()))
-				if ext == pext {
-					result = append(result, path.Join(curdir, f.Name()))
+				for _, pext := range pexts {
+					if ext == pext {
+						result = append(result, path.Join(curdir, f.Name()))
+						break
+					}
 				}
 			}
 		}
@@ -79,16 +98,25 @@ func allFiles(p, pext string) (result []string) {
 }
 
 type TableMap struct {
-	TableName      string `json:"table_name"`
-	PathToHeader   string `json:"path_to_header"`
-	PathToData     string `json:"path_to_data"`
+	TableName    string `json:"table_name"`
+	PathToHeader string `json:"path_to_header"`
+	PathToData   string `json:"path_to_data"`
+	//Codec of data files, auto-detected when empty
+	Codec string `json:"codec"`
+	//DataFileExtensions limits data files to the given extensions, the ones of compressed formats when empty,
+	//so plain files like .txt, .csv or .dat are read only when listed
+	DataFileExtensions []string `json:"data_file_extensions"`
+	//ColumnTypes are types Pgu loads columns as, and Parquet outputs are written in, by header name:
+	//integer, numeric, date, timestamp or text, optionally followed by a colon and the format, like date:02-Jan-06
+	ColumnTypes map[string]string `json:"column_types"`
+	//KeyColumns make Pgu update rows of the same key instead of inserting duplicates
+	KeyColumns     []string `json:"key_columns"`
 	allHeaderBytes []byte
 	headers        [][]byte
 	//headerFlags[]bool
 	allFiles []string
 	//fusions map[int]map[int]int //Map[colPosition]map[FusSize]FusPos
-	file   *os.File
-	writer io.WriteCloser
+	file *os.File
 }
 
 type TableMaps struct {
@@ -98,6 +126,9 @@ type TableMaps struct {
 	HeaderColumnSeparatorChar string      `json:"header_column_separator_char"`
 	ResultColumnSeparatorByte int         `json:"result_column_separator_byte"`
 	FusionColumnSizeAlignment int         `json:"fusion_column_size_alignment"`
+	DataQuoteChar             *string     `json:"data_quote_char"`
+	DataEscapeStyle           string      `json:"data_escape_style"`
+	DataMultiLineRecords      bool        `json:"data_multiline_records"`
 }
 
 func readConfig() (result *TableMaps, err error) {
@@ -140,3 +171,202 @@ func (t *TableMap) readHeader(sep []byte) {
 		t.headers[index] = hb[index]
 	}
 }
+
+//columnPosition returns a zero-based position of a column by its case-insensitive name
+func (t *TableMap) columnPosition(name string) (int, error) {
+	for index, hb := range t.headers {
+		if strings.ToLower(strings.TrimSpace(string(hb))) ==
+			strings.ToLower(strings.TrimSpace(name)) {
+			return index, nil
+		}
+	}
+	return -1, errors.Errorf("column name %v not found in %v", name, t.TableName)
+}
+
+//fusion returns the way fused cells are split, nil if no fusion separator is configured
+func (c *TableMaps) fusion() *dump.FusionType {
+	if c.FusionSeparatorChar == "" {
+		return nil
+	}
+	return &dump.FusionType{
+		Separator:     []byte(c.FusionSeparatorChar),
+		SizeAlignment: c.FusionColumnSizeAlignment,
+	}
+}
+
+//dumperConfig makes the way data files of a table are read.
+//Quote char is " unless data_quote_char is set, as cells of dumps have always been double-quoted,
+//so a cell starting with it is read as a quoted value. An empty data_quote_char switches quoting off
+func (c *TableMaps) dumperConfig(t *TableMap) (result *dump.DumperConfigType, err error) {
+	codec, err := dump.ParseCodec(t.Codec)
+	if err != nil {
+		err = errors.Wrapf(err, "could not configure codec of %v", t.TableName)
+		return nil, err
+	}
+	result = &dump.DumperConfigType{
+		ColumnSeparator:  byte(c.DataColumnSeparatorByte),
+		LineSeparator:    dump.LineFeedByte,
+		Codec:            codec,
+		BufferSize:       4096,
+		QuoteChar:        dump.DoubleQuoteByte,
+		MultiLineRecords: c.DataMultiLineRecords,
+		ParsingWorkers:   *parsers,
+		UseIndex:         true,
+	}
+	if c.DataQuoteChar != nil {
+		switch len(*c.DataQuoteChar) {
+		case 0:
+			result.QuoteChar = 0
+		case 1:
+			result.QuoteChar = (*c.DataQuoteChar)[0]
+		default:
+			err = errors.Errorf("data_quote_char must be a single char, got %v", *c.DataQuoteChar)
+			return nil, err
+		}
+	}
+	switch strings.ToLower(c.DataEscapeStyle) {
+	case "", "doubled":
+		result.EscapeStyle = dump.EscapeDoubled
+	case "backslash":
+		result.EscapeStyle = dump.EscapeBackslash
+	default:
+		err = errors.Errorf("data_escape_style %v is not recognized: doubled or backslash expected", c.DataEscapeStyle)
+		return nil, err
+	}
+	return result, nil
+}
+
+//readData feeds rows of all table data files to processors made by newProcessor.
+//In ordered mode rows are processed one by one following the file order,
+//otherwise processors of different files are called concurrently.
+//Files completed according to the checkpoint are skipped, the ones in progress are read from the saved position.
+//fileDone, if given, is called after the last row of a file has been processed
+func (t *TableMap) readData(
+	dmp *dump.DumperType,
+	ordered bool,
+	cp *checkpoint,
+	newProcessor func(pathToFile string) dump.RowProcessingFuncType,
+	fileDone func(pathToFile string),
+) (err error) {
+	var done func(worker int, pathToFile string)
+	if fileDone != nil {
+		done = func(worker int, pathToFile string) {
+			fileDone(pathToFile)
+		}
+	}
+	return t.readDataByWorkers(dmp, ordered, *workers, cp, func(worker int, pathToFile string) dump.RowProcessingFuncType {
+		return newProcessor(pathToFile)
+	}, done)
+}
+
+//readDataByWorkers reads table data files with a given number of workers,
+//telling the callbacks which worker is reading the file
+func (t *TableMap) readDataByWorkers(
+	dmp *dump.DumperType,
+	ordered bool,
+	workers int,
+	cp *checkpoint,
+	newProcessor func(worker int, pathToFile string) dump.RowProcessingFuncType,
+	fileDone func(worker int, pathToFile string),
+) (err error) {
+	stop := make(chan struct{})
+	go cp.saveRegularly(stop)
+
+	_, err = dmp.ReadFromFiles(
+		context.Background(),
+		cp.pendingFiles(t.dataFiles()),
+		&dump.FilesConfigType{
+			Workers:            workers,
+			Ordered:            ordered,
+			FileStartPositions: cp.startPositions(),
+			NewFileProcessor: func(worker int, pathToFile string) (dump.RowProcessingFuncType, error) {
+				log.Printf("%v...", pathToFile)
+				return cp.track(pathToFile, newProcessor(worker, pathToFile)), nil
+			},
+			FileDone: func(worker int, pathToFile string, lineCount uint64) error {
+				if fileDone != nil {
+					fileDone(worker, pathToFile)
+				}
+				cp.fileDone(pathToFile, lineCount)
+				return nil
+			},
+		},
+	)
+	close(stop)
+	if err != nil {
+		err = errors.Wrapf(err, "could not read data of %v", t.TableName)
+	}
+	if saveErr := cp.save(); saveErr != nil {
+		if err != nil {
+			log.Printf("%v", saveErr)
+			return
+		}
+		return saveErr
+	}
+	return
+}
+
+//mergeableType collects rows of a file to be merged into the total of a table
+type mergeableType interface {
+	add(cellsBytes [][]byte)
+	merge(partial mergeableType)
+}
+
+//readDataMerging reads table data files concurrently. Rows of every file are collected by a partial made by newPartial,
+//which is merged into total when the file is done and when a checkpoint is saved.
+//state returns the command state to be saved along with the checkpoint, once all the partials are merged
+func (t *TableMap) readDataMerging(
+	dmp *dump.DumperType,
+	cp *checkpoint,
+	total mergeableType,
+	newPartial func() mergeableType,
+	state func() interface{},
+) error {
+	var mutex sync.Mutex
+	active := make(map[string]*mergeableType)
+	cp.flush = func() (interface{}, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, partial := range active {
+			total.merge(*partial)
+			*partial = newPartial()
+		}
+		return state(), nil
+	}
+
+	return t.readData(dmp, false, cp, func(pathToFile string) dump.RowProcessingFuncType {
+		partial := newPartial()
+		mutex.Lock()
+		active[pathToFile] = &partial
+		mutex.Unlock()
+		return func(
+			cancelContext context.Context,
+			config *dump.DumperConfigType,
+			currentLineNumber uint64,
+			currentStreamPosition uint64,
+			cellsBytes [][]byte,
+			rawLineBytes []byte,
+		) (err error) {
+			partial.add(cellsBytes)
+			return
+		}
+	}, func(pathToFile string) {
+		mutex.Lock()
+		total.merge(*active[pathToFile])
+		delete(active, pathToFile)
+		mutex.Unlock()
+	})
+}
+
+func (t *TableMap) dataFiles() []string {
+	exts := t.DataFileExtensions
+	if len(exts) == 0 {
+		exts = dump.DataFileExtensions()
+	}
+	return allFiles(t.PathToData, exts...)
+}
+
+//dataFileName returns the path of a data file relative to path_to_data
+func (t *TableMap) dataFileName(pathToFile string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(pathToFile, path.Clean(t.PathToData)), "/")
+}
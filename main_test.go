@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/ovlad32/geq/dump"
+)
+
+//readCells splits data by the dumper config of a table
+func readCells(t *testing.T, conf *TableMaps, data string) (rows [][]string) {
+	dc, err := conf.dumperConfig(&TableMap{TableName: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc.Codec = dump.CodecNone
+	dmp, err := dump.NewDumper(dc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dmp.ReadFromStream(context.Background(), bytes.NewReader([]byte(data)), func(
+		cancelContext context.Context,
+		config *dump.DumperConfigType,
+		currentLineNumber uint64,
+		currentStreamPosition uint64,
+		cellsBytes [][]byte,
+		rawLineBytes []byte,
+	) error {
+		var row []string
+		for _, cell := range cellsBytes {
+			row = append(row, string(cell))
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+//TestDumperConfigQuoting checks cells are double-quoted unless data_quote_char says otherwise
+func TestDumperConfigQuoting(t *testing.T) {
+	data := "\"a\",\"b\"\"c\",\"d,e\"\n'f',g\"h\n"
+	quote := func(char string) *string {
+		return &char
+	}
+	tests := []struct {
+		quoteChar *string
+		rows      [][]string
+	}{
+		{nil, [][]string{{"a", `b"c`, "d,e"}, {"'f'", `g"h`}}},
+		{quote(`"`), [][]string{{"a", `b"c`, "d,e"}, {"'f'", `g"h`}}},
+		{quote(""), [][]string{{`"a"`, `"b""c"`, `"d`, `e"`}, {"'f'", `g"h`}}},
+		{quote("'"), [][]string{{`"a"`, `"b""c"`, `"d`, `e"`}, {"f", `g"h`}}},
+	}
+	for index, test := range tests {
+		conf := &TableMaps{DataColumnSeparatorByte: ',', DataQuoteChar: test.quoteChar}
+		if rows := readCells(t, conf, data); !reflect.DeepEqual(rows, test.rows) {
+			t.Errorf("test %v: rows %q, %q expected", index, rows, test.rows)
+		}
+	}
+
+	if _, err := (&TableMaps{DataQuoteChar: quote("ab")}).dumperConfig(&TableMap{}); err == nil {
+		t.Errorf("data_quote_char of two chars is accepted")
+	}
+	if _, err := (&TableMaps{DataEscapeStyle: "none"}).dumperConfig(&TableMap{}); err == nil {
+		t.Errorf("data_escape_style none is accepted")
+	}
+}
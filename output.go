@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ovlad32/geq/dump"
+	"github.com/ovlad32/geq/parquet"
+	"github.com/pkg/errors"
+)
+
+var outputFormat = flag.String("format", "text", "")
+var parquetRowGroup = flag.Int("pqrowgroup", 64, "")
+var parquetCompression = flag.String("pqcompression", "snappy", "")
+var parquetTyped = flag.Bool("pqtyped", false, "")
+
+//parquetTypes map types of column_types to those of Parquet columns
+var parquetTypes = map[string]parquet.Type{
+	"integer":   parquet.Int64,
+	"numeric":   parquet.Double,
+	"date":      parquet.Date,
+	"timestamp": parquet.Timestamp,
+	"text":      parquet.String,
+}
+
+//outputStateType is saved with a checkpoint to continue an output
+type outputStateType struct {
+	Output *int64 `json:"output"`
+	//RowGroups are those of a Parquet output written up to Output
+	RowGroups []*parquet.RowGroupType `json:"row_groups,omitempty"`
+}
+
+//outputType is a file rows of a command are written to in the -format given
+type outputType interface {
+	//write writes a row, text outputs take the line as it is and Parquet ones take the cells
+	write(cells [][]byte, line []byte) error
+	//flush makes rows written durable and returns the state to continue the output with
+	flush() (*outputStateType, error)
+	Close() error
+}
+
+//outputColumnType is a column of a Parquet output, cells are converted to its type
+type outputColumnType struct {
+	name       string
+	columnType *pgColumnType
+}
+
+func textColumn(name string) *outputColumnType {
+	return &outputColumnType{name: name, columnType: &pgColumnType{name: "text"}}
+}
+
+//parquetOutput tells if outputs are Parquet files, which are named with the .parquet extension
+func parquetOutput() bool {
+	switch strings.ToLower(*outputFormat) {
+	case "text":
+		return false
+	case "parquet":
+		return true
+	}
+	panic(fmt.Sprintf("output format %v is not one of text, parquet", *outputFormat))
+}
+
+//outputExtension keeps outputs and checkpoints of different formats apart
+func outputExtension() string {
+	if parquetOutput() {
+		return ".parquet"
+	}
+	return ""
+}
+
+//outputColumns returns columns of a table in the header order typed by column_types,
+//the other ones are inferred from -pgsample rows of every data file with -pqtyped or are text otherwise.
+//Columns are not needed for text outputs and are not made
+func (t *TableMap) outputColumns(dmp *dump.DumperType) (result []*outputColumnType, err error) {
+	if !parquetOutput() {
+		return
+	}
+	types, err := t.pgColumnTypes(dmp, *parquetTyped)
+	if err != nil {
+		return
+	}
+	for index, columnType := range types {
+		result = append(result, &outputColumnType{
+			name:       strings.TrimSpace(string(t.headers[index])),
+			columnType: columnType,
+		})
+	}
+	return
+}
+
+//newOutput creates an output, or continues the one of state being resumed
+func newOutput(pathToFile string, columns []*outputColumnType, state *outputStateType) (outputType, error) {
+	if state == nil {
+		state = &outputStateType{}
+	}
+	file, err := openOutput(pathToFile, state.Output)
+	if err != nil {
+		return nil, err
+	}
+	if !parquetOutput() {
+		return newTextOutput(file), nil
+	}
+	compression, err := parquet.ParseCompression(*parquetCompression)
+	if err == nil && *parquetRowGroup <= 0 {
+		err = errors.New("row group size must be positive")
+	}
+	schema := make([]parquet.ColumnType, len(columns))
+	for index, column := range columns {
+		schema[index] = parquet.ColumnType{Name: column.name, Type: parquetTypes[column.columnType.name]}
+	}
+	var writer *parquet.WriterType
+	if err == nil {
+		writer, err = parquet.NewWriter(file, schema, compression, int64(*parquetRowGroup)<<20)
+	}
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrapf(err, "could not create %v", pathToFile)
+	}
+	if state.Output != nil {
+		writer.Restore(*state.Output, state.RowGroups)
+	}
+	return &parquetOutputType{
+		pathToFile: pathToFile,
+		file:       file,
+		writer:     writer,
+		columns:    columns,
+	}, nil
+}
+
+//textOutputType writes lines to a file or to stdout
+type textOutputType struct {
+	file   *os.File
+	buffer *bufio.Writer
+}
+
+func newTextOutput(file *os.File) *textOutputType {
+	return &textOutputType{file: file, buffer: bufio.NewWriter(file)}
+}
+
+func (o *textOutputType) write(cells [][]byte, line []byte) (err error) {
+	_, err = o.buffer.Write(line)
+	return
+}
+
+func (o *textOutputType) flush() (state *outputStateType, err error) {
+	err = o.buffer.Flush()
+	if err != nil {
+		return
+	}
+	state = &outputStateType{}
+	state.Output, err = outputSize(o.file)
+	return
+}
+
+func (o *textOutputType) Close() (err error) {
+	err = o.buffer.Flush()
+	if closeErr := o.file.Close(); err == nil {
+		err = closeErr
+	}
+	return
+}
+
+//parquetOutputType writes cells converted to types of columns to a Parquet file.
+//The file is completed at every flush, so it's readable up to the last checkpoint while being written.
+//Empty cells of typed columns and missing cells are null, text ones are written as they are
+type parquetOutputType struct {
+	pathToFile string
+	file       *os.File
+	writer     *parquet.WriterType
+	columns    []*outputColumnType
+	//invalid counts values not of their column types, which are written as null
+	invalid int64
+}
+
+func (o *parquetOutputType) write(cells [][]byte, line []byte) (err error) {
+	for index, column := range o.columns {
+		if index >= len(cells) {
+			o.writer.WriteNull(index)
+			continue
+		}
+		err = o.writeCell(index, column.columnType, cells[index])
+		if err != nil {
+			o.invalid++
+			o.writer.WriteNull(index)
+		}
+	}
+	err = o.writer.EndRow()
+	if err != nil {
+		err = errors.Wrapf(err, "could not write %v", o.pathToFile)
+	}
+	return
+}
+
+func (o *parquetOutputType) writeCell(index int, columnType *pgColumnType, cell []byte) (err error) {
+	if columnType.name == "text" {
+		o.writer.WriteString(index, cell)
+		return
+	}
+	cell = bytes.TrimSpace(cell)
+	if len(cell) == 0 {
+		o.writer.WriteNull(index)
+		return
+	}
+	switch columnType.name {
+	case "integer", "numeric":
+		text, err := columnType.convertNumber(cell)
+		if err != nil {
+			return err
+		}
+		if columnType.name == "numeric" {
+			value, err := strconv.ParseFloat(text, 64)
+			if err == nil {
+				o.writer.WriteDouble(index, value)
+			}
+			return err
+		}
+		value, err := strconv.ParseInt(text, 10, 64)
+		if err == nil {
+			o.writer.WriteInt64(index, value)
+		}
+		return err
+	}
+	value, err := columnType.parseTime(cell)
+	if err == nil {
+		o.writer.WriteTime(index, value)
+	}
+	return
+}
+
+func (o *parquetOutputType) flush() (*outputStateType, error) {
+	err := o.writer.Flush()
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not flush %v", o.pathToFile)
+	}
+	offset := o.writer.Offset()
+	return &outputStateType{Output: &offset, RowGroups: o.writer.RowGroups()}, nil
+}
+
+//size estimates the size of the file with rows not flushed yet
+func (o *parquetOutputType) size() int64 {
+	return o.writer.Size()
+}
+
+func (o *parquetOutputType) Close() (err error) {
+	err = o.writer.Close()
+	if closeErr := o.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "could not close %v", o.pathToFile)
+	}
+	if o.invalid > 0 {
+		log.Printf("%v value(s) not of their column types written to %v as null", o.invalid, o.pathToFile)
+	}
+	return
+}
@@ -0,0 +1,233 @@
+package parquet
+
+import (
+	"fmt"
+	"strings"
+)
+
+//Type is a type of a column, each is stored as a Parquet physical type annotated with a logical one
+type Type int
+
+const (
+	//String is BYTE_ARRAY of UTF-8 text
+	String Type = iota
+	//Int64 is INT64
+	Int64
+	//Double is DOUBLE
+	Double
+	//Date is INT32 of days since 1970-01-01
+	Date
+	//Timestamp is INT64 of microseconds since 1970-01-01 00:00:00 of the local time, not adjusted to UTC
+	Timestamp
+)
+
+//physical types, encodings and other enums of parquet.thrift
+const (
+	physicalInt32     = 1
+	physicalInt64     = 2
+	physicalDouble    = 5
+	physicalByteArray = 6
+
+	convertedUTF8 = 0
+	convertedDate = 6
+
+	repetitionOptional = 1
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	pageData = 0
+)
+
+func (t Type) physical() int32 {
+	switch t {
+	case Int64, Timestamp:
+		return physicalInt64
+	case Double:
+		return physicalDouble
+	case Date:
+		return physicalInt32
+	}
+	return physicalByteArray
+}
+
+//ColumnType is a column of a file schema, all columns are optional
+type ColumnType struct {
+	Name string
+	Type Type
+}
+
+//CompressionType names a codec pages are compressed with
+type CompressionType string
+
+const (
+	CompressionNone   CompressionType = "none"
+	CompressionSnappy CompressionType = "snappy"
+	CompressionGZip   CompressionType = "gzip"
+	CompressionZstd   CompressionType = "zstd"
+)
+
+//codecs are CompressionCodec values of parquet.thrift
+var codecs = map[CompressionType]int32{
+	CompressionNone:   0,
+	CompressionSnappy: 1,
+	CompressionGZip:   2,
+	CompressionZstd:   6,
+}
+
+//ParseCompression reads a codec name, uncompressed is accepted for none
+func ParseCompression(name string) (CompressionType, error) {
+	compression := CompressionType(strings.ToLower(strings.TrimSpace(name)))
+	if compression == "uncompressed" {
+		compression = CompressionNone
+	}
+	if _, found := codecs[compression]; !found {
+		return "", fmt.Errorf("parquet compression %v is not one of none, snappy, gzip, zstd", name)
+	}
+	return compression, nil
+}
+
+//RowGroupType describes a row group written to a file.
+//Row groups are kept along with the end of the last one to continue the file after a restart
+type RowGroupType struct {
+	Rows    int64              `json:"rows"`
+	Columns []*ColumnChunkType `json:"columns"`
+}
+
+//ColumnChunkType describes pages of a column in a row group
+type ColumnChunkType struct {
+	Offset int64 `json:"offset"`
+	//Values include nulls
+	Values           int64 `json:"values"`
+	Nulls            int64 `json:"nulls"`
+	Size             int64 `json:"size"`
+	UncompressedSize int64 `json:"uncompressed_size"`
+	//Min and Max are plain-encoded, nil if there are no values or they are too long to keep
+	Min []byte `json:"min,omitempty"`
+	Max []byte `json:"max,omitempty"`
+}
+
+func writeDataPageHeader(w *thriftWriterType, values, size, compressedSize int) {
+	w.begin()
+	w.i32(1, pageData)
+	w.i32(2, int32(size))
+	w.i32(3, int32(compressedSize))
+	w.structField(5)
+	w.i32(1, int32(values))
+	w.i32(2, encodingPlain)
+	w.i32(3, encodingRLE)
+	w.i32(4, encodingRLE)
+	w.end()
+	w.end()
+}
+
+func writeSchemaElement(w *thriftWriterType, column ColumnType) {
+	w.begin()
+	w.i32(1, column.Type.physical())
+	w.i32(3, repetitionOptional)
+	w.binary(4, []byte(column.Name))
+	switch column.Type {
+	case String:
+		w.i32(6, convertedUTF8)
+	case Date:
+		w.i32(6, convertedDate)
+	}
+	//the logical type is a union of structs, those of STRING and DATE are empty
+	switch column.Type {
+	case String:
+		w.structField(10)
+		w.structField(1)
+		w.end()
+		w.end()
+	case Date:
+		w.structField(10)
+		w.structField(6)
+		w.end()
+		w.end()
+	case Timestamp:
+		w.structField(10)
+		w.structField(8)
+		w.bool(1, false)
+		w.structField(2)
+		w.structField(2)
+		w.end()
+		w.end()
+		w.end()
+		w.end()
+	}
+	w.end()
+}
+
+func writeColumnChunk(w *thriftWriterType, column ColumnType, compression CompressionType, chunk *ColumnChunkType) {
+	w.begin()
+	w.i64(2, chunk.Offset)
+	w.structField(3)
+	w.i32(1, column.Type.physical())
+	w.list(2, thriftI32, 2)
+	w.zigzag(encodingPlain)
+	w.zigzag(encodingRLE)
+	w.list(3, thriftBinary, 1)
+	w.bytes([]byte(column.Name))
+	w.i32(4, codecs[compression])
+	w.i64(5, chunk.Values)
+	w.i64(6, chunk.UncompressedSize)
+	w.i64(7, chunk.Size)
+	w.i64(9, chunk.Offset)
+	w.structField(12)
+	w.i64(3, chunk.Nulls)
+	if chunk.Max != nil {
+		w.binary(5, chunk.Max)
+		w.binary(6, chunk.Min)
+	}
+	w.end()
+	w.end()
+	w.end()
+}
+
+//writeFileMetaData encodes the footer of a file of row groups, min and max values are ordered by their types
+func writeFileMetaData(w *thriftWriterType, columns []ColumnType, compression CompressionType, rowGroups []*RowGroupType) {
+	var rows int64
+	for _, rowGroup := range rowGroups {
+		rows += rowGroup.Rows
+	}
+	w.begin()
+	w.i32(1, 1)
+	w.list(2, thriftStruct, len(columns)+1)
+	w.begin()
+	w.binary(4, []byte("schema"))
+	w.i32(5, int32(len(columns)))
+	w.end()
+	for _, column := range columns {
+		writeSchemaElement(w, column)
+	}
+	w.i64(3, rows)
+	w.list(4, thriftStruct, len(rowGroups))
+	for _, rowGroup := range rowGroups {
+		var size, compressedSize int64
+		for _, chunk := range rowGroup.Columns {
+			size += chunk.UncompressedSize
+			compressedSize += chunk.Size
+		}
+		w.begin()
+		w.list(1, thriftStruct, len(rowGroup.Columns))
+		for index, chunk := range rowGroup.Columns {
+			writeColumnChunk(w, columns[index], compression, chunk)
+		}
+		w.i64(2, size)
+		w.i64(3, rowGroup.Rows)
+		if len(rowGroup.Columns) > 0 {
+			w.i64(5, rowGroup.Columns[0].Offset)
+		}
+		w.i64(6, compressedSize)
+		w.end()
+	}
+	w.binary(6, []byte("geq"))
+	w.list(7, thriftStruct, len(columns))
+	for range columns {
+		w.begin()
+		w.structField(1)
+		w.end()
+		w.end()
+	}
+	w.end()
+}
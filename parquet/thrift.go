@@ -0,0 +1,101 @@
+package parquet
+
+import "encoding/binary"
+
+//thrift types of the compact protocol
+const (
+	thriftBoolTrue  = 1
+	thriftBoolFalse = 2
+	thriftI32       = 5
+	thriftI64       = 6
+	thriftBinary    = 8
+	thriftList      = 9
+	thriftStruct    = 12
+)
+
+//thriftWriterType encodes structures of the Parquet metadata in the Thrift compact protocol.
+//Fields must be written in the order of their ids within a struct
+type thriftWriterType struct {
+	data []byte
+	//scratch holds a varint being encoded
+	scratch [binary.MaxVarintLen64]byte
+	//last is the id of the field written last, lasts are those of enclosing structs
+	last  int16
+	lasts []int16
+}
+
+func (w *thriftWriterType) varint(value uint64) {
+	n := binary.PutUvarint(w.scratch[:], value)
+	w.data = append(w.data, w.scratch[:n]...)
+}
+
+func (w *thriftWriterType) zigzag(value int64) {
+	w.varint(uint64((value << 1) ^ (value >> 63)))
+}
+
+func (w *thriftWriterType) field(id int16, kind byte) {
+	if delta := id - w.last; delta > 0 && delta <= 15 {
+		w.data = append(w.data, byte(delta)<<4|kind)
+	} else {
+		w.data = append(w.data, kind)
+		w.zigzag(int64(id))
+	}
+	w.last = id
+}
+
+func (w *thriftWriterType) i32(id int16, value int32) {
+	w.field(id, thriftI32)
+	w.zigzag(int64(value))
+}
+
+func (w *thriftWriterType) i64(id int16, value int64) {
+	w.field(id, thriftI64)
+	w.zigzag(value)
+}
+
+func (w *thriftWriterType) bool(id int16, value bool) {
+	if value {
+		w.field(id, thriftBoolTrue)
+	} else {
+		w.field(id, thriftBoolFalse)
+	}
+}
+
+func (w *thriftWriterType) binary(id int16, value []byte) {
+	w.field(id, thriftBinary)
+	w.bytes(value)
+}
+
+func (w *thriftWriterType) bytes(value []byte) {
+	w.varint(uint64(len(value)))
+	w.data = append(w.data, value...)
+}
+
+//list starts a list field, elements are written right after it
+func (w *thriftWriterType) list(id int16, kind byte, size int) {
+	w.field(id, thriftList)
+	if size < 15 {
+		w.data = append(w.data, byte(size)<<4|kind)
+	} else {
+		w.data = append(w.data, 0xf0|kind)
+		w.varint(uint64(size))
+	}
+}
+
+//structField starts a struct field, which is ended by end
+func (w *thriftWriterType) structField(id int16) {
+	w.field(id, thriftStruct)
+	w.begin()
+}
+
+//begin starts a struct of a list element or the top level one
+func (w *thriftWriterType) begin() {
+	w.lasts = append(w.lasts, w.last)
+	w.last = 0
+}
+
+func (w *thriftWriterType) end() {
+	w.data = append(w.data, 0)
+	w.last = w.lasts[len(w.lasts)-1]
+	w.lasts = w.lasts[:len(w.lasts)-1]
+}
@@ -0,0 +1,347 @@
+//Package parquet writes flat tables of optional columns to Apache Parquet files.
+//Values are plain-encoded in version 1 data pages, and every column chunk carries min/max statistics
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+var magic = []byte("PAR1")
+
+//pageSize is the size of plain-encoded values a page is ended at
+const pageSize = 1 << 20
+
+//maxStatsLength is the longest string kept as the min or the max of a column chunk,
+//a chunk having a longer one has no min/max statistics
+const maxStatsLength = 64
+
+//chunkWriterType collects values of a column in the current row group
+type chunkWriterType struct {
+	column ColumnType
+	//levels are definition levels of values of the page being filled, a bit per value, 0 for null
+	levels []byte
+	//values are plain-encoded non-null values of the page
+	values []byte
+	count  int
+	//pages are compressed pages preceded by their headers
+	pages []byte
+	chunk ColumnChunkType
+	//noStats is set once a value is too long to be the min or the max
+	noStats bool
+}
+
+func (c *chunkWriterType) level(defined bool) {
+	if c.count%8 == 0 {
+		c.levels = append(c.levels, 0)
+	}
+	if defined {
+		c.levels[c.count/8] |= 1 << uint(c.count%8)
+	} else {
+		c.chunk.Nulls++
+	}
+	c.count++
+	c.chunk.Values++
+}
+
+//less compares plain-encoded values of the column
+func (c *chunkWriterType) less(a, b []byte) bool {
+	switch c.column.Type {
+	case Int64, Timestamp:
+		return int64(binary.LittleEndian.Uint64(a)) < int64(binary.LittleEndian.Uint64(b))
+	case Date:
+		return int32(binary.LittleEndian.Uint32(a)) < int32(binary.LittleEndian.Uint32(b))
+	case Double:
+		return math.Float64frombits(binary.LittleEndian.Uint64(a)) < math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+	return bytes.Compare(a, b) < 0
+}
+
+//stats updates min and max by a plain-encoded value, the length prefix of a string excluded
+func (c *chunkWriterType) stats(value []byte) {
+	if c.noStats {
+		return
+	}
+	if len(value) > maxStatsLength {
+		c.noStats = true
+		c.chunk.Min, c.chunk.Max = nil, nil
+		return
+	}
+	if c.chunk.Max == nil {
+		c.chunk.Min = append([]byte{}, value...)
+		c.chunk.Max = append([]byte{}, value...)
+		return
+	}
+	if c.less(value, c.chunk.Min) {
+		c.chunk.Min = append(c.chunk.Min[:0], value...)
+	} else if c.less(c.chunk.Max, value) {
+		c.chunk.Max = append(c.chunk.Max[:0], value...)
+	}
+}
+
+//WriterType writes rows to a file a row group at a time, row groups are buffered in memory.
+//A row is written by a Write method per column followed by EndRow
+type WriterType struct {
+	stream       io.WriteSeeker
+	columns      []ColumnType
+	compression  CompressionType
+	rowGroupSize int64
+	chunks       []*chunkWriterType
+	rows         int64
+	//offset is the end of the last row group written
+	offset    int64
+	rowGroups []*RowGroupType
+	scratch   [8]byte
+	buffer    bytes.Buffer
+	gzip      *gzip.Writer
+	zstd      *zstd.Encoder
+	thrift    thriftWriterType
+}
+
+//NewWriter makes a writer of a new file, row groups are ended when they are about rowGroupSize bytes
+func NewWriter(stream io.WriteSeeker, columns []ColumnType, compression CompressionType, rowGroupSize int64) (w *WriterType, err error) {
+	if _, found := codecs[compression]; !found {
+		return nil, fmt.Errorf("parquet compression %v is not supported", compression)
+	}
+	w = &WriterType{
+		stream:       stream,
+		columns:      columns,
+		compression:  compression,
+		rowGroupSize: rowGroupSize,
+	}
+	for _, column := range columns {
+		w.chunks = append(w.chunks, &chunkWriterType{column: column})
+	}
+	if compression == CompressionZstd {
+		w.zstd, err = zstd.NewWriter(nil)
+	}
+	return
+}
+
+//Restore continues a file of row groups written before, the stream must be positioned at the end of the last of them
+func (w *WriterType) Restore(offset int64, rowGroups []*RowGroupType) {
+	w.offset = offset
+	w.rowGroups = rowGroups
+}
+
+//Offset returns the end of the last row group written
+func (w *WriterType) Offset() int64 {
+	return w.offset
+}
+
+//RowGroups returns row groups written
+func (w *WriterType) RowGroups() []*RowGroupType {
+	return w.rowGroups
+}
+
+//Size estimates the size of the file with rows buffered
+func (w *WriterType) Size() (size int64) {
+	size = w.offset
+	for _, c := range w.chunks {
+		size += int64(len(c.pages) + len(c.values) + len(c.levels))
+	}
+	return
+}
+
+func (w *WriterType) chunk(column int, columnType Type) *chunkWriterType {
+	c := w.chunks[column]
+	if c.column.Type != columnType {
+		panic(fmt.Sprintf("column %v is not of type %v", c.column.Name, columnType))
+	}
+	return c
+}
+
+//WriteNull writes null to a column of any type
+func (w *WriterType) WriteNull(column int) {
+	w.chunks[column].level(false)
+}
+
+//WriteInt64 writes a value to an Int64 column
+func (w *WriterType) WriteInt64(column int, value int64) {
+	w.writeFixed(w.chunk(column, Int64), 8, uint64(value))
+}
+
+//WriteDouble writes a value to a Double column
+func (w *WriterType) WriteDouble(column int, value float64) {
+	c := w.chunk(column, Double)
+	if math.IsNaN(value) {
+		//NaN is not ordered, so it's not taken into statistics
+		c.level(true)
+		binary.LittleEndian.PutUint64(w.scratch[:], math.Float64bits(value))
+		c.values = append(c.values, w.scratch[:]...)
+		return
+	}
+	w.writeFixed(c, 8, math.Float64bits(value))
+}
+
+//WriteTime writes the date or the timestamp of the wall clock of a value to a Date or a Timestamp column
+func (w *WriterType) WriteTime(column int, value time.Time) {
+	year, month, day := value.Date()
+	if w.chunks[column].column.Type == Date {
+		w.writeFixed(w.chunks[column], 4, uint64(time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix()/86400))
+		return
+	}
+	wall := time.Date(year, month, day, value.Hour(), value.Minute(), value.Second(), value.Nanosecond(), time.UTC)
+	w.writeFixed(w.chunk(column, Timestamp), 8, uint64(wall.Unix()*1000000+int64(wall.Nanosecond()/1000)))
+}
+
+//WriteString writes a value to a String column
+func (w *WriterType) WriteString(column int, value []byte) {
+	c := w.chunk(column, String)
+	c.level(true)
+	binary.LittleEndian.PutUint32(w.scratch[:], uint32(len(value)))
+	c.values = append(c.values, w.scratch[:4]...)
+	c.values = append(c.values, value...)
+	c.stats(value)
+}
+
+func (w *WriterType) writeFixed(c *chunkWriterType, size int, value uint64) {
+	c.level(true)
+	binary.LittleEndian.PutUint64(w.scratch[:], value)
+	c.values = append(c.values, w.scratch[:size]...)
+	c.stats(w.scratch[:size])
+}
+
+//EndRow completes a row, pages and the row group are ended when they are full
+func (w *WriterType) EndRow() (err error) {
+	w.rows++
+	for _, c := range w.chunks {
+		if c.chunk.Values != w.rows {
+			return fmt.Errorf("%v value(s) of column %v written for %v row(s)", c.chunk.Values, c.column.Name, w.rows)
+		}
+		if len(c.values) >= pageSize {
+			err = w.endPage(c)
+			if err != nil {
+				return
+			}
+		}
+	}
+	if w.Size()-w.offset >= w.rowGroupSize {
+		err = w.endRowGroup()
+	}
+	return
+}
+
+func (w *WriterType) compress(data []byte) (result []byte, err error) {
+	switch w.compression {
+	case CompressionSnappy:
+		return s2.EncodeSnappy(nil, data), nil
+	case CompressionGZip:
+		w.buffer.Reset()
+		if w.gzip == nil {
+			w.gzip = gzip.NewWriter(&w.buffer)
+		} else {
+			w.gzip.Reset(&w.buffer)
+		}
+		_, err = w.gzip.Write(data)
+		if err == nil {
+			err = w.gzip.Close()
+		}
+		return w.buffer.Bytes(), err
+	case CompressionZstd:
+		return w.zstd.EncodeAll(data, nil), nil
+	}
+	return data, nil
+}
+
+//endPage compresses the page of a column, definition levels are encoded as a single bit-packed run
+func (w *WriterType) endPage(c *chunkWriterType) (err error) {
+	var levels thriftWriterType
+	levels.varint(uint64(len(c.levels))<<1 | 1)
+	levels.data = append(levels.data, c.levels...)
+	page := make([]byte, 4, 4+len(levels.data)+len(c.values))
+	binary.LittleEndian.PutUint32(page, uint32(len(levels.data)))
+	page = append(page, levels.data...)
+	page = append(page, c.values...)
+	compressed, err := w.compress(page)
+	if err != nil {
+		return fmt.Errorf("could not compress page of column %v: %v", c.column.Name, err)
+	}
+	w.thrift.data = w.thrift.data[:0]
+	writeDataPageHeader(&w.thrift, c.count, len(page), len(compressed))
+	c.pages = append(c.pages, w.thrift.data...)
+	c.pages = append(c.pages, compressed...)
+	c.chunk.UncompressedSize += int64(len(w.thrift.data) + len(page))
+	c.chunk.Size += int64(len(w.thrift.data) + len(compressed))
+	c.levels = c.levels[:0]
+	c.values = c.values[:0]
+	c.count = 0
+	return
+}
+
+func (w *WriterType) write(data []byte) (err error) {
+	if w.offset == 0 {
+		_, err = w.stream.Write(magic)
+		if err != nil {
+			return
+		}
+		w.offset = int64(len(magic))
+	}
+	_, err = w.stream.Write(data)
+	return
+}
+
+func (w *WriterType) endRowGroup() (err error) {
+	if w.rows == 0 {
+		return
+	}
+	rowGroup := &RowGroupType{Rows: w.rows}
+	for _, c := range w.chunks {
+		if c.count > 0 {
+			err = w.endPage(c)
+			if err != nil {
+				return
+			}
+		}
+		err = w.write(c.pages)
+		if err != nil {
+			return
+		}
+		chunk := c.chunk
+		chunk.Offset = w.offset
+		w.offset += int64(len(c.pages))
+		rowGroup.Columns = append(rowGroup.Columns, &chunk)
+		c.pages = c.pages[:0]
+		c.chunk = ColumnChunkType{}
+		c.noStats = false
+	}
+	w.rowGroups = append(w.rowGroups, rowGroup)
+	w.rows = 0
+	return
+}
+
+//writeFooter completes the file after the last row group
+func (w *WriterType) writeFooter() (err error) {
+	w.thrift.data = w.thrift.data[:0]
+	writeFileMetaData(&w.thrift, w.columns, w.compression, w.rowGroups)
+	binary.LittleEndian.PutUint32(w.scratch[:], uint32(len(w.thrift.data)))
+	footer := append(w.thrift.data, w.scratch[:4]...)
+	footer = append(footer, magic...)
+	return w.write(footer)
+}
+
+//Flush ends the row group and the file, which stays complete until the next row group is written over the footer
+func (w *WriterType) Flush() (err error) {
+	err = w.Close()
+	if err == nil {
+		_, err = w.stream.Seek(w.offset, io.SeekStart)
+	}
+	return
+}
+
+//Close ends the row group and writes the footer, the stream is left open
+func (w *WriterType) Close() (err error) {
+	err = w.endRowGroup()
+	if err == nil {
+		err = w.writeFooter()
+	}
+	return
+}
@@ -0,0 +1,537 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+//thriftReaderType decodes the Thrift compact protocol into maps of fields by id,
+//values are bool, int64, float64, []byte, []interface{} and map[int16]interface{}
+type thriftReaderType struct {
+	data []byte
+	err  error
+}
+
+func (r *thriftReaderType) byte() byte {
+	if len(r.data) == 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *thriftReaderType) varint() uint64 {
+	value, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = fmt.Errorf("bad varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return value
+}
+
+func (r *thriftReaderType) zigzag() int64 {
+	value := r.varint()
+	return int64(value>>1) ^ -int64(value&1)
+}
+
+func (r *thriftReaderType) value(kind byte) interface{} {
+	switch kind {
+	case thriftBoolTrue:
+		return true
+	case thriftBoolFalse:
+		return false
+	case 3:
+		return int64(int8(r.byte()))
+	case 4, thriftI32, thriftI64:
+		return r.zigzag()
+	case 7:
+		if len(r.data) < 8 {
+			r.err = io.ErrUnexpectedEOF
+			return nil
+		}
+		value := math.Float64frombits(binary.LittleEndian.Uint64(r.data))
+		r.data = r.data[8:]
+		return value
+	case thriftBinary:
+		length := int(r.varint())
+		if length > len(r.data) {
+			r.err = io.ErrUnexpectedEOF
+			return nil
+		}
+		value := r.data[:length]
+		r.data = r.data[length:]
+		return value
+	case thriftList:
+		header := r.byte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.varint())
+		}
+		var list []interface{}
+		for index := 0; index < size && r.err == nil; index++ {
+			//booleans of lists take a byte each
+			if kind := header & 0x0f; kind == thriftBoolTrue || kind == thriftBoolFalse {
+				list = append(list, r.byte() == thriftBoolTrue)
+			} else {
+				list = append(list, r.value(kind))
+			}
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	}
+	r.err = fmt.Errorf("thrift type %v is not expected", kind)
+	return nil
+}
+
+func (r *thriftReaderType) readStruct() map[int16]interface{} {
+	result := make(map[int16]interface{})
+	var last int16
+	for r.err == nil {
+		header := r.byte()
+		if header == 0 {
+			break
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		result[id] = r.value(header & 0x0f)
+		last = id
+	}
+	return result
+}
+
+func field(t *testing.T, s map[int16]interface{}, ids ...int16) interface{} {
+	var value interface{} = s
+	for _, id := range ids {
+		fields, ok := value.(map[int16]interface{})
+		if !ok {
+			t.Fatalf("field %v of %v is not in a struct", id, ids)
+		}
+		if value, ok = fields[id]; !ok {
+			t.Fatalf("field %v of %v is missing", id, ids)
+		}
+	}
+	return value
+}
+
+//testColumns are one of each type
+var testColumns = []ColumnType{
+	{Name: "s", Type: String},
+	{Name: "i", Type: Int64},
+	{Name: "d", Type: Double},
+	{Name: "dt", Type: Date},
+	{Name: "ts", Type: Timestamp},
+}
+
+var testZone = time.FixedZone("test", 3*3600)
+
+//testRow makes values of a row, nil for null, dates are days and timestamps are microseconds of the wall clock
+func testRow(n int) []interface{} {
+	row := make([]interface{}, len(testColumns))
+	if n%7 != 3 {
+		row[0] = fmt.Sprintf("value %v", n)
+		if n%50 == 1 {
+			row[0] = strings.Repeat("long ", 20) + row[0].(string)
+		}
+	}
+	if n%5 != 0 {
+		row[1] = int64(n*1000 - 7000)
+	}
+	if n%3 != 0 {
+		row[2] = float64(n) / 4
+	}
+	if n%4 != 2 {
+		row[3] = int64(18000 + n)
+	}
+	if n%6 != 5 {
+		row[4] = int64(1577934245000000) + int64(n)*3600000000 + int64(n%1000)
+	}
+	return row
+}
+
+func writeTestRow(t *testing.T, w *WriterType, row []interface{}) {
+	for column, value := range row {
+		switch {
+		case value == nil:
+			w.WriteNull(column)
+		case testColumns[column].Type == String:
+			w.WriteString(column, []byte(value.(string)))
+		case testColumns[column].Type == Int64:
+			w.WriteInt64(column, value.(int64))
+		case testColumns[column].Type == Double:
+			w.WriteDouble(column, value.(float64))
+		case testColumns[column].Type == Date:
+			//any time of the day in any zone makes the date
+			w.WriteTime(column, time.Date(1970, 1, 1+int(value.(int64)), 23, 59, 0, 0, testZone))
+		default:
+			micros := value.(int64)
+			wall := time.Unix(micros/1000000, micros%1000000*1000).UTC()
+			w.WriteTime(column, time.Date(wall.Year(), wall.Month(), wall.Day(),
+				wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), testZone))
+		}
+	}
+	if err := w.EndRow(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func decompress(codec int64, data []byte, size int) (result []byte, err error) {
+	switch codec {
+	case 0:
+		result = data
+	case 1:
+		result, err = s2.Decode(nil, data)
+	case 2:
+		var reader *gzip.Reader
+		reader, err = gzip.NewReader(bytes.NewReader(data))
+		if err == nil {
+			result, err = ioutil.ReadAll(reader)
+		}
+	case 6:
+		var decoder *zstd.Decoder
+		decoder, err = zstd.NewReader(nil)
+		if err == nil {
+			result, err = decoder.DecodeAll(data, nil)
+			decoder.Close()
+		}
+	default:
+		err = fmt.Errorf("codec %v", codec)
+	}
+	if err == nil && len(result) != size {
+		err = fmt.Errorf("%v byte(s) decompressed, %v expected", len(result), size)
+	}
+	return
+}
+
+//levels decodes definition levels of bit width 1 encoded in the RLE/bit-packed hybrid
+func levels(t *testing.T, data []byte, count int) []bool {
+	r := &thriftReaderType{data: data}
+	var result []bool
+	for len(result) < count && r.err == nil {
+		header := r.varint()
+		if header&1 == 0 {
+			defined := r.byte() == 1
+			for n := uint64(0); n < header>>1; n++ {
+				result = append(result, defined)
+			}
+			continue
+		}
+		for n := uint64(0); n < header>>1; n++ {
+			b := r.byte()
+			for bit := uint(0); bit < 8; bit++ {
+				result = append(result, b>>bit&1 == 1)
+			}
+		}
+	}
+	if r.err != nil || len(result) < count || len(r.data) > 0 {
+		t.Fatalf("definition levels of %v value(s) are not decoded: %v", count, r.err)
+	}
+	return result[:count]
+}
+
+//readValue decodes a plain-encoded value, returning it as testRow does and in the encoding of statistics
+func readValue(t *testing.T, columnType Type, data []byte) (value interface{}, encoded []byte, rest []byte) {
+	switch columnType {
+	case String:
+		length := int(binary.LittleEndian.Uint32(data))
+		return string(data[4 : 4+length]), data[4 : 4+length], data[4+length:]
+	case Int64, Timestamp:
+		return int64(binary.LittleEndian.Uint64(data)), data[:8], data[8:]
+	case Double:
+		return math.Float64frombits(binary.LittleEndian.Uint64(data)), data[:8], data[8:]
+	}
+	return int64(int32(binary.LittleEndian.Uint32(data))), data[:4], data[4:]
+}
+
+//readFile decodes a file checking its metadata, pages and statistics, and returns its rows
+func readFile(t *testing.T, data []byte, compression CompressionType) (rows [][]interface{}) {
+	if len(data) < 12 || string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		t.Fatalf("no magic")
+	}
+	footerLength := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	r := &thriftReaderType{data: data[len(data)-8-footerLength : len(data)-8]}
+	metadata := r.readStruct()
+	if r.err != nil || len(r.data) > 0 {
+		t.Fatalf("footer is not decoded: %v", r.err)
+	}
+	if field(t, metadata, 1).(int64) != 1 {
+		t.Errorf("version %v", metadata[1])
+	}
+	schema := field(t, metadata, 2).([]interface{})
+	if len(schema) != len(testColumns)+1 || field(t, schema[0].(map[int16]interface{}), 5).(int64) != int64(len(testColumns)) {
+		t.Fatalf("schema %v", schema)
+	}
+	logicalTypes := map[Type]int16{String: 1, Date: 6, Timestamp: 8}
+	for index, column := range testColumns {
+		element := schema[index+1].(map[int16]interface{})
+		if field(t, element, 1).(int64) != int64(column.Type.physical()) || field(t, element, 3).(int64) != repetitionOptional ||
+			string(field(t, element, 4).([]byte)) != column.Name {
+			t.Errorf("schema element %v of column %v", element, column.Name)
+		}
+		if logicalType, found := logicalTypes[column.Type]; found {
+			if _, found = field(t, element, 10).(map[int16]interface{})[logicalType]; !found {
+				t.Errorf("logical type of column %v is %v", column.Name, element[10])
+			}
+		}
+	}
+	if column := schema[5].(map[int16]interface{}); field(t, column, 10, 8, 1).(bool) ||
+		field(t, column, 10, 8, 2, 2) == nil {
+		t.Errorf("timestamp is not of local microseconds: %v", column[10])
+	}
+
+	rowGroups := field(t, metadata, 4).([]interface{})
+	var rowCount int64
+	for _, rowGroup := range rowGroups {
+		rowGroup := rowGroup.(map[int16]interface{})
+		groupRows := int(field(t, rowGroup, 3).(int64))
+		rowCount += int64(groupRows)
+		groupRowsStart := len(rows)
+		for n := 0; n < groupRows; n++ {
+			rows = append(rows, make([]interface{}, len(testColumns)))
+		}
+		chunks := field(t, rowGroup, 1).([]interface{})
+		if len(chunks) != len(testColumns) {
+			t.Fatalf("%v column chunk(s)", len(chunks))
+		}
+		var groupSize, groupCompressedSize int64
+		for index, column := range testColumns {
+			chunk := chunks[index].(map[int16]interface{})
+			meta := field(t, chunk, 3).(map[int16]interface{})
+			offset := field(t, meta, 9).(int64)
+			size := field(t, meta, 7).(int64)
+			groupSize += field(t, meta, 6).(int64)
+			groupCompressedSize += size
+			if field(t, chunk, 2).(int64) != offset || field(t, meta, 1).(int64) != int64(column.Type.physical()) ||
+				field(t, meta, 4).(int64) != int64(codecs[compression]) ||
+				string(field(t, meta, 3).([]interface{})[0].([]byte)) != column.Name {
+				t.Fatalf("metadata %v of column %v", meta, column.Name)
+			}
+			if index == 0 && field(t, rowGroup, 5).(int64) != offset {
+				t.Errorf("row group offset %v, %v expected", rowGroup[5], offset)
+			}
+
+			pages := &thriftReaderType{data: data[offset : offset+size]}
+			var values [][]byte
+			row, nulls, uncompressedSize := groupRowsStart, int64(0), int64(0)
+			for len(pages.data) > 0 {
+				before := len(pages.data)
+				header := pages.readStruct()
+				if pages.err != nil {
+					t.Fatalf("page header of column %v is not decoded: %v", column.Name, pages.err)
+				}
+				compressedSize := int(field(t, header, 3).(int64))
+				pageSize := int(field(t, header, 2).(int64))
+				count := int(field(t, header, 5, 1).(int64))
+				if field(t, header, 1).(int64) != pageData || field(t, header, 5, 2).(int64) != encodingPlain ||
+					field(t, header, 5, 3).(int64) != encodingRLE || field(t, header, 5, 4).(int64) != encodingRLE {
+					t.Fatalf("page header %v of column %v", header, column.Name)
+				}
+				uncompressedSize += int64(before - len(pages.data) + pageSize)
+				page, err := decompress(int64(codecs[compression]), pages.data[:compressedSize], pageSize)
+				if err != nil {
+					t.Fatalf("page of column %v is not decompressed: %v", column.Name, err)
+				}
+				pages.data = pages.data[compressedSize:]
+				levelsLength := int(binary.LittleEndian.Uint32(page))
+				plain := page[4+levelsLength:]
+				for _, defined := range levels(t, page[4:4+levelsLength], count) {
+					if defined {
+						var encoded []byte
+						rows[row][index], encoded, plain = readValue(t, column.Type, plain)
+						values = append(values, encoded)
+					} else {
+						nulls++
+					}
+					row++
+				}
+				if len(plain) > 0 {
+					t.Fatalf("%v byte(s) left in a page of column %v", len(plain), column.Name)
+				}
+			}
+			if row != len(rows) || field(t, meta, 5).(int64) != int64(groupRows) || uncompressedSize != field(t, meta, 6).(int64) {
+				t.Fatalf("column %v of row group of %v row(s) has %v value(s), %v recorded, %v uncompressed byte(s) of %v",
+					column.Name, groupRows, row-groupRowsStart, meta[5], uncompressedSize, meta[6])
+			}
+
+			statistics := field(t, meta, 12).(map[int16]interface{})
+			if field(t, statistics, 3).(int64) != nulls {
+				t.Errorf("%v null(s) of column %v counted, %v read", statistics[3], column.Name, nulls)
+			}
+			chunkWriter := &chunkWriterType{column: column}
+			for _, value := range values {
+				chunkWriter.stats(value)
+			}
+			if !bytes.Equal(chunkWriter.chunk.Max, toBytes(statistics[5])) || !bytes.Equal(chunkWriter.chunk.Min, toBytes(statistics[6])) {
+				t.Errorf("min %v and max %v of column %v, %v and %v expected",
+					statistics[6], statistics[5], column.Name, chunkWriter.chunk.Min, chunkWriter.chunk.Max)
+			}
+		}
+		if field(t, rowGroup, 2).(int64) != groupSize || field(t, rowGroup, 6).(int64) != groupCompressedSize {
+			t.Errorf("row group size %v and compressed size %v, %v and %v expected",
+				rowGroup[2], rowGroup[6], groupSize, groupCompressedSize)
+		}
+	}
+	if field(t, metadata, 3).(int64) != rowCount {
+		t.Errorf("%v row(s) in the footer, %v in row groups", metadata[3], rowCount)
+	}
+	return
+}
+
+func toBytes(value interface{}) []byte {
+	if value == nil {
+		return nil
+	}
+	return value.([]byte)
+}
+
+func checkFile(t *testing.T, pathToFile string, compression CompressionType, expected [][]interface{}) (rowGroups int) {
+	data, err := ioutil.ReadFile(pathToFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := readFile(t, data, compression)
+	if len(rows) != len(expected) {
+		t.Fatalf("%v: %v row(s) read, %v expected", compression, len(rows), len(expected))
+	}
+	for n, row := range rows {
+		if !reflect.DeepEqual(row, expected[n]) {
+			t.Fatalf("%v: row %v read as %v, %v expected", compression, n, row, expected[n])
+		}
+	}
+	r := &thriftReaderType{data: data[len(data)-8-int(binary.LittleEndian.Uint32(data[len(data)-8:])) : len(data)-8]}
+	return len(r.readStruct()[4].([]interface{}))
+}
+
+//TestWriterRoundTrip writes rows of every type with nulls in several row groups, flushes the file in between,
+//and continues it from a saved offset after rows written past it are lost
+func TestWriterRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, compression := range []CompressionType{CompressionNone, CompressionSnappy, CompressionGZip, CompressionZstd} {
+		pathToFile := path.Join(dir, string(compression)+".parquet")
+		file, err := os.Create(pathToFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := NewWriter(file, testColumns, compression, 4096)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expected [][]interface{}
+		write := func(from, to int) {
+			for n := from; n < to; n++ {
+				row := testRow(n)
+				writeTestRow(t, w, row)
+				expected = append(expected, row)
+			}
+		}
+
+		write(0, 700)
+		if err = w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if rowGroups := checkFile(t, pathToFile, compression, expected); rowGroups < 3 {
+			t.Fatalf("%v: %v row group(s) written", compression, rowGroups)
+		}
+		write(700, 750)
+		if err = w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		checkFile(t, pathToFile, compression, expected)
+
+		//the state is saved along with a checkpoint as JSON
+		state, err := json.Marshal(w.RowGroups())
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset := w.Offset()
+		write(750, 1500)
+		file.Close()
+		expected = expected[:750]
+
+		file, err = os.OpenFile(pathToFile, os.O_RDWR, 0666)
+		if err == nil {
+			err = file.Truncate(offset)
+		}
+		if err == nil {
+			_, err = file.Seek(offset, io.SeekStart)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rowGroups []*RowGroupType
+		if err = json.Unmarshal(state, &rowGroups); err != nil {
+			t.Fatal(err)
+		}
+		w, err = NewWriter(file, testColumns, compression, 4096)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Restore(offset, rowGroups)
+		write(750, 1000)
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		checkFile(t, pathToFile, compression, expected)
+	}
+}
+
+func TestWriterEmpty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pathToFile := path.Join(dir, "empty.parquet")
+	file, err := os.Create(pathToFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWriter(file, testColumns, CompressionNone, 4096)
+	if err == nil {
+		err = w.Close()
+	}
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rowGroups := checkFile(t, pathToFile, CompressionNone, nil); rowGroups != 0 {
+		t.Fatalf("%v row group(s) written", rowGroups)
+	}
+}
+
+func TestWriterRowMismatch(t *testing.T) {
+	w, err := NewWriter(nil, testColumns, CompressionNone, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString(0, []byte("a"))
+	if err = w.EndRow(); err == nil {
+		t.Fatal("row missing values is ended")
+	}
+}
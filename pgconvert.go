@@ -0,0 +1,119 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ovlad32/geq/expr"
+	"github.com/pkg/errors"
+)
+
+//pgColumnType converts cells to text of a PostgreSQL type, the one the server parses regardless of its settings
+type pgColumnType struct {
+	//name is one of pgTypes keys
+	name string
+	//layout is a Go time layout of dates and timestamps, or the decimal separator of numbers
+	//optionally followed by the digit grouping char.
+	//Dates and timestamps are read in expr.TimeLayouts, numbers with the decimal point and no grouping if it's empty
+	layout string
+}
+
+//parsePgColumnType reads a type of column_types, the name may be followed by a colon and the layout,
+//like date:02-Jan-06, numeric:, or numeric:,.
+func parsePgColumnType(spec string) (*pgColumnType, error) {
+	result := &pgColumnType{name: strings.ToLower(strings.TrimSpace(spec))}
+	if colon := strings.Index(spec, ":"); colon >= 0 {
+		result.name = strings.ToLower(strings.TrimSpace(spec[:colon]))
+		result.layout = spec[colon+1:]
+	}
+	if _, found := pgTypes[result.name]; !found {
+		return nil, errors.Errorf("column type %v is not one of integer, numeric, date, timestamp, text", spec)
+	}
+	if (result.name == "integer" || result.name == "numeric") && len(result.layout) > 2 {
+		return nil, errors.Errorf("number format of %v must be the decimal separator and the grouping char", spec)
+	}
+	return result, nil
+}
+
+//convert returns the cell as text of the column type
+func (c *pgColumnType) convert(cell []byte) (string, error) {
+	switch c.name {
+	case "integer", "numeric":
+		return c.convertNumber(cell)
+	case "date", "timestamp":
+		t, err := c.parseTime(cell)
+		if err != nil {
+			return "", err
+		}
+		if c.name == "date" {
+			return t.Format("2006-01-02"), nil
+		}
+		return t.Format("2006-01-02 15:04:05.999999999"), nil
+	}
+	return string(cell), nil
+}
+
+//parseTime reads a cell of a date or a timestamp column
+func (c *pgColumnType) parseTime(cell []byte) (t time.Time, err error) {
+	ok := true
+	if c.layout == "" {
+		t, ok = expr.ParseTime(cell)
+	} else {
+		t, err = time.Parse(c.layout, string(cell))
+	}
+	if !ok || err != nil {
+		return t, errors.Errorf("%q is not a %v", cell, c.name)
+	}
+	return
+}
+
+var pgNumber = regexp.MustCompile(`^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+
+//convertNumber removes digit grouping and makes the decimal separator a point.
+//The text is kept as it is rather than formatted from float64 not to lose digits
+func (c *pgColumnType) convertNumber(cell []byte) (string, error) {
+	decimal, grouping := byte('.'), -1
+	if len(c.layout) > 0 {
+		decimal = c.layout[0]
+	}
+	if len(c.layout) > 1 {
+		grouping = int(c.layout[1])
+	}
+	number := make([]byte, 0, len(cell))
+	for _, b := range cell {
+		switch {
+		case b == decimal:
+			number = append(number, '.')
+		case int(b) == grouping:
+		case b == '.':
+			//the point is not the decimal separator
+			return "", errors.Errorf("%q is not %v", cell, c.name)
+		default:
+			number = append(number, b)
+		}
+	}
+	text := string(number)
+	valid := pgNumber.MatchString(text)
+	if c.name == "integer" {
+		_, err := strconv.ParseInt(text, 10, 64)
+		valid = err == nil
+	}
+	if !valid {
+		return "", errors.Errorf("%q is not %v", cell, c.name)
+	}
+	return text, nil
+}
+
+//pgNullMarkers is a set of trimmed cell values loaded as NULL
+type pgNullMarkers map[string]bool
+
+//parsePgNullMarkers reads a comma-separated list, an empty item is the empty string
+func parsePgNullMarkers(list string) pgNullMarkers {
+	result := make(pgNullMarkers)
+	for _, marker := range strings.Split(list, ",") {
+		result[strings.TrimSpace(marker)] = true
+	}
+	return result
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePgColumnType(t *testing.T) {
+	tests := []struct {
+		spec   string
+		name   string
+		layout string
+	}{
+		{"integer", "integer", ""},
+		{" Numeric ", "numeric", ""},
+		{"numeric:", "numeric", ""},
+		{"numeric:,.", "numeric", ",."},
+		{"integer: ", "integer", " "},
+		{"Date:02-Jan-06", "date", "02-Jan-06"},
+		{"timestamp:2006-01-02T15:04:05", "timestamp", "2006-01-02T15:04:05"},
+		{"money", "", ""},
+		{"numeric:,.x", "", ""},
+		{":,", "", ""},
+	}
+	for _, test := range tests {
+		columnType, err := parsePgColumnType(test.spec)
+		if test.name == "" {
+			if err == nil {
+				t.Errorf("%q is parsed as %+v", test.spec, *columnType)
+			}
+			continue
+		}
+		if err != nil || columnType.name != test.name || columnType.layout != test.layout {
+			t.Errorf("%q is parsed as %+v: %v", test.spec, columnType, err)
+		}
+	}
+}
+
+func TestPgColumnTypeConvert(t *testing.T) {
+	tests := []struct {
+		spec  string
+		cell  string
+		value string
+		ok    bool
+	}{
+		{"numeric", "1234.5", "1234.5", true},
+		{"numeric", "-1e3", "-1e3", true},
+		{"numeric", ".5", ".5", true},
+		{"numeric", "5.", "5.", true},
+		//digits are kept rather than rounded to float64
+		{"numeric", "12345678901234567890.123456789", "12345678901234567890.123456789", true},
+		{"numeric", "1,234.5", "", false},
+		{"numeric", "abc", "", false},
+		{"numeric", "", "", false},
+		{"numeric:,.", "1.234.567,89", "1234567.89", true},
+		{"numeric:,", "1234,5", "1234.5", true},
+		{"numeric:,", "1234.5", "", false},
+		{"numeric:, ", "1 234,5", "1234.5", true},
+		{"numeric:.,", "1,234.5", "1234.5", true},
+		{"integer", "+7", "+7", true},
+		{"integer", "12.5", "", false},
+		{"integer", "9223372036854775808", "", false},
+		{"integer:.,", "1,234", "1234", true},
+		{"integer:,.", "1.234", "1234", true},
+		{"date", "2020-05-01", "2020-05-01", true},
+		{"date", "20200501", "2020-05-01", true},
+		{"date:02-Jan-06", "31-DEC-17", "2017-12-31", true},
+		{"date:02-Jan-06", "2017-12-31", "", false},
+		{"timestamp", "2020-05-01 10:30:00", "2020-05-01 10:30:00", true},
+		{"timestamp", "2020-05-01 10:30:00.25", "2020-05-01 10:30:00.25", true},
+		{"timestamp", "n/a", "", false},
+		{"text", " as is ", " as is ", true},
+	}
+	for _, test := range tests {
+		columnType, err := parsePgColumnType(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := columnType.convert([]byte(test.cell))
+		if (err == nil) != test.ok || value != test.value {
+			t.Errorf("%q of %v is converted to %q: %v", test.cell, test.spec, value, err)
+		}
+	}
+}
+
+func TestParsePgNullMarkers(t *testing.T) {
+	tests := []struct {
+		list    string
+		markers pgNullMarkers
+	}{
+		{"", pgNullMarkers{"": true}},
+		{"NULL", pgNullMarkers{"NULL": true}},
+		{` NULL , \N ,`, pgNullMarkers{"NULL": true, `\N`: true, "": true}},
+	}
+	for _, test := range tests {
+		if markers := parsePgNullMarkers(test.list); !reflect.DeepEqual(markers, test.markers) {
+			t.Errorf("%q is parsed as %v", test.list, markers)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	pgLoading = "loading"
+	pgLoaded  = "loaded"
+)
+
+//pgLoadsType records data files loaded into the target table in a load-tracking table.
+//Records are written in the transactions loading the rows, so they always agree with the target table
+type pgLoadsType struct {
+	sink   sinkType
+	table  string
+	target string
+	//checksums are SHA-256 of data files by path, a file is hashed only to compare it with a file loaded before
+	//and once it's loaded
+	checksums map[string]string
+	mutex     sync.Mutex
+}
+
+//newPgLoads creates the load-tracking table if it doesn't exist
+func newPgLoads(db *sql.DB, sink sinkType, table, target string) (result *pgLoadsType, err error) {
+	result = &pgLoadsType{
+		sink:      sink,
+		table:     table,
+		target:    target,
+		checksums: make(map[string]string),
+	}
+	_, err = db.Exec(fmt.Sprintf(`create table if not exists %[1]v(
+	target_table %[2]v not null,
+	file %[2]v not null,
+	row_count %[3]v not null,
+	checksum %[2]v not null,
+	status %[2]v not null,
+	updated_at %[4]v not null,
+	primary key(target_table, file)
+)`, table, sink.columnType("text"), sink.columnType("integer"), sink.columnType("timestamp")))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create load-tracking table %v", table)
+	}
+	return
+}
+
+//checksum returns the checksum of a data file, which is hashed once
+func (l *pgLoadsType) checksum(pathToFile string) (checksum string, err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	checksum, found := l.checksums[pathToFile]
+	if !found {
+		checksum, err = fileChecksum(pathToFile)
+		if err == nil {
+			l.checksums[pathToFile] = checksum
+		}
+	}
+	return
+}
+
+func fileChecksum(pathToFile string) (string, error) {
+	file, err := os.Open(pathToFile)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not open %v", pathToFile)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not checksum %v", pathToFile)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+//clear forgets files loaded into the target table, which has been truncated or dropped
+func (l *pgLoadsType) clear(db *sql.DB) (err error) {
+	_, err = db.Exec(fmt.Sprintf("delete from %v where target_table = %v", l.table, l.sink.placeholder(1)), l.target)
+	if err != nil {
+		err = errors.Wrapf(err, "could not clear load-tracking table %v", l.table)
+	}
+	return
+}
+
+//record adds rows to the record of a data file and sets its status.
+//The checksum is recorded along with the loaded status only, a file being loaded is cleaned up anyway
+func (l *pgLoadsType) record(tx *sql.Tx, t *TableMap, pathToFile string, rows uint64, status string) (err error) {
+	checksum := ""
+	if status == pgLoaded {
+		checksum, err = l.checksum(pathToFile)
+		if err != nil {
+			return
+		}
+	}
+	p := l.sink.placeholder
+	_, err = tx.Exec(fmt.Sprintf(`insert into %[1]v(target_table, file, row_count, checksum, status, updated_at)
+values(%v, %v, %v, %v, %v, %v)
+on conflict(target_table, file) do update set
+	row_count = %[1]v.row_count + excluded.row_count,
+	checksum = excluded.checksum,
+	status = excluded.status,
+	updated_at = excluded.updated_at`, l.table, p(1), p(2), p(3), p(4), p(5), l.sink.now()),
+		l.target, t.dataFileName(pathToFile), int64(rows), checksum, status,
+	)
+	if err != nil {
+		err = errors.Wrapf(err, "could not record load of %v", pathToFile)
+	}
+	return
+}
+
+//skipLoaded marks files loaded before and not changed since then completed in the checkpoint.
+//Rows of files partially loaded or changed are deleted by fileColumn if given,
+//otherwise they are left to be replaced by the upsert, a plain load of them fails
+func (l *pgLoadsType) skipLoaded(db *sql.DB, t *TableMap, cp *checkpoint, fileColumn string, upsert bool) (err error) {
+	type loadRecord struct {
+		rows     int64
+		checksum string
+		status   string
+	}
+	records := make(map[string]*loadRecord)
+	rows, err := db.Query(
+		fmt.Sprintf("select file, row_count, checksum, status from %v where target_table = %v", l.table, l.sink.placeholder(1)),
+		l.target,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "could not read load-tracking table %v", l.table)
+	}
+	for rows.Next() {
+		var file string
+		record := &loadRecord{}
+		err = rows.Scan(&file, &record.rows, &record.checksum, &record.status)
+		if err != nil {
+			rows.Close()
+			return errors.Wrapf(err, "could not read load-tracking table %v", l.table)
+		}
+		records[file] = record
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return errors.Wrapf(err, "could not read load-tracking table %v", l.table)
+	}
+
+	for _, pathToFile := range t.dataFiles() {
+		name := t.dataFileName(pathToFile)
+		record, found := records[name]
+		if !found {
+			continue
+		}
+		if record.status == pgLoaded {
+			var checksum string
+			checksum, err = l.checksum(pathToFile)
+			if err != nil {
+				return
+			}
+			if record.checksum == checksum {
+				log.Printf("%v: %v row(s) loaded before, skipping", pathToFile, record.rows)
+				cp.fileDone(pathToFile, uint64(record.rows))
+				continue
+			}
+		}
+		if fileColumn == "" && !upsert {
+			return errors.Errorf(
+				"%v row(s) of %v have been loaded before, which can't be cleaned up without -pgfilecolumn or key_columns",
+				record.rows, pathToFile,
+			)
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if fileColumn != "" {
+			log.Printf("%v: deleting %v row(s) loaded before", pathToFile, record.rows)
+			_, err = tx.Exec(fmt.Sprintf("delete from %v where %v = %v", l.target, fileColumn, l.sink.placeholder(1)), name)
+		} else {
+			log.Printf("%v: replacing %v row(s) loaded before", pathToFile, record.rows)
+		}
+		if err == nil {
+			_, err = tx.Exec(
+				fmt.Sprintf(
+					"delete from %v where target_table = %v and file = %v",
+					l.table, l.sink.placeholder(1), l.sink.placeholder(2),
+				),
+				l.target, name,
+			)
+		}
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+		if err != nil {
+			return errors.Wrapf(err, "could not clean up rows of %v loaded before", pathToFile)
+		}
+	}
+	return
+}
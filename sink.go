@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+//sinkType is a database Pgu loads tables into.
+//Another database is supported by an implementation registered in sinks along with its database/sql driver
+type sinkType interface {
+	//driver is the database/sql driver name
+	driver() string
+	//quote makes an identifier of a header or a flag name
+	quote(name string) string
+	//columnType returns a type of the database for a type of column_types
+	columnType(name string) string
+	//placeholder returns a parameter of a statement, index starts at 1
+	placeholder(index int) string
+	//now is an expression of the current time
+	now() string
+	truncate(table string) string
+	//statements load columns into a table, rows of the same keys are updated if any.
+	//insert asks for row by row inserts when the database has a bulk path
+	statements(table string, columns, keys []string, insert bool) *pgStatementsType
+	//concurrent tells if several connections may load a table at once
+	concurrent() bool
+}
+
+var sinks = map[string]sinkType{
+	"postgres": postgresSink{},
+	"sqlite":   sqliteSink{},
+}
+
+func newSink(name string) (sinkType, error) {
+	sink, found := sinks[strings.ToLower(name)]
+	if !found {
+		names := make([]string, 0, len(sinks))
+		for name := range sinks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, errors.Errorf("sink %v is not one of %v", name, strings.Join(names, ", "))
+	}
+	return sink, nil
+}
+
+//upsertClause makes ON CONFLICT updating columns other than keys, PostgreSQL and SQLite share the syntax
+func upsertClause(columns, keys []string) string {
+	if len(keys) == 0 {
+		return ""
+	}
+	isKey := make(map[string]bool)
+	for _, key := range keys {
+		isKey[key] = true
+	}
+	var updates []string
+	for _, column := range columns {
+		if !isKey[column] {
+			updates = append(updates, fmt.Sprintf("%v = excluded.%v", column, column))
+		}
+	}
+	if len(updates) == 0 {
+		return fmt.Sprintf(" on conflict(%v) do nothing", strings.Join(keys, ","))
+	}
+	return fmt.Sprintf(" on conflict(%v) do update set %v", strings.Join(keys, ","), strings.Join(updates, ", "))
+}
+
+func insertStatement(sink sinkType, table string, columns, keys []string) string {
+	placeholders := make([]string, len(columns))
+	for index := range columns {
+		placeholders[index] = sink.placeholder(index + 1)
+	}
+	return fmt.Sprintf(
+		"insert into %v(%v) values(%v)%v",
+		table, strings.Join(columns, ","), strings.Join(placeholders, ","), upsertClause(columns, keys),
+	)
+}
+
+//postgresSink loads through lib/pq, rows are streamed with COPY FROM STDIN unless -pginsert is given
+type postgresSink struct{}
+
+const pgStageTable = "geq_stage"
+
+func (postgresSink) driver() string                { return "postgres" }
+func (postgresSink) quote(name string) string      { return pgColumnName(name) }
+func (postgresSink) columnType(name string) string { return pgTypes[name] }
+func (postgresSink) placeholder(index int) string  { return fmt.Sprintf("$%v", index) }
+func (postgresSink) now() string                   { return "now()" }
+func (postgresSink) concurrent() bool              { return true }
+
+func (postgresSink) truncate(table string) string {
+	return fmt.Sprintf("truncate table %v", table)
+}
+
+//statements of COPY upserting rows stage them in a temporary table
+func (s postgresSink) statements(table string, columns, keys []string, insert bool) *pgStatementsType {
+	if insert {
+		return &pgStatementsType{dml: insertStatement(s, table, columns, keys)}
+	}
+	list := strings.Join(columns, ",")
+	//lib/pq turns a prepared COPY FROM STDIN into a stream, each Exec adds a row to it
+	if len(keys) == 0 {
+		return &pgStatementsType{
+			dml:     fmt.Sprintf("copy %v(%v) from stdin", table, list),
+			copying: true,
+		}
+	}
+	//a key may occur in a batch more than once, the row copied last is taken
+	return &pgStatementsType{
+		setup:   fmt.Sprintf("create temporary table %v (like %v including defaults) on commit drop", pgStageTable, table),
+		dml:     fmt.Sprintf("copy %v(%v) from stdin", pgStageTable, list),
+		copying: true,
+		apply: fmt.Sprintf(
+			"insert into %v(%v) select distinct on(%v) %v from %v order by %v, ctid desc%v",
+			table, list, strings.Join(keys, ","), list, pgStageTable, strings.Join(keys, ","),
+			upsertClause(columns, keys),
+		),
+	}
+}
+
+//sqliteSink loads into a database file given by -conn through mattn/go-sqlite3.
+//Prepared inserts in a transaction are its bulk path, and it takes one writer at a time
+type sqliteSink struct{}
+
+//sqliteTypes keep dates and timestamps as ISO 8601 text
+var sqliteTypes = map[string]string{
+	"integer":   "integer",
+	"numeric":   "numeric",
+	"date":      "text",
+	"timestamp": "text",
+	"text":      "text",
+}
+
+func (sqliteSink) driver() string                { return "sqlite3" }
+func (sqliteSink) columnType(name string) string { return sqliteTypes[name] }
+func (sqliteSink) placeholder(index int) string  { return "?" }
+func (sqliteSink) now() string                   { return "current_timestamp" }
+func (sqliteSink) concurrent() bool              { return false }
+
+//quote quotes every name, SQLite matches quoted names regardless of the case anyway
+func (sqliteSink) quote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func (sqliteSink) truncate(table string) string {
+	return fmt.Sprintf("delete from %v", table)
+}
+
+func (s sqliteSink) statements(table string, columns, keys []string, insert bool) *pgStatementsType {
+	return &pgStatementsType{dml: insertStatement(s, table, columns, keys)}
+}
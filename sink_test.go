@@ -0,0 +1,286 @@
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+//sinkTest is a target table in a temporary SQLite database along with data files of the source table
+type sinkTest struct {
+	dir   string
+	db    *sql.DB
+	sink  sinkType
+	table *TableMap
+	files []string
+}
+
+func newSinkTest(t *testing.T) *sinkTest {
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	test := &sinkTest{dir: dir, sink: sqliteSink{}}
+	test.table = &TableMap{
+		TableName:          "source",
+		PathToData:         path.Join(dir, "data"),
+		DataFileExtensions: []string{".txt"},
+	}
+	err = os.MkdirAll(test.table.PathToData, 0777)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		pathToFile := path.Join(test.table.PathToData, name)
+		if err == nil {
+			err = ioutil.WriteFile(pathToFile, []byte(name+"\n"), 0666)
+		}
+		test.files = append(test.files, pathToFile)
+	}
+	if err == nil {
+		test.db, err = sql.Open(test.sink.driver(), path.Join(dir, "test.db"))
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return test
+}
+
+func (test *sinkTest) close() {
+	test.db.Close()
+	os.RemoveAll(test.dir)
+}
+
+//prepare creates the target table by prepareTarget of flags given
+func (test *sinkTest) prepare(t *testing.T, create, truncate bool, keys []string) {
+	table, created, dropped, truncated := *targetTable, *pgCreate, *pgDrop, *pgTruncate
+	defer func() {
+		*targetTable, *pgCreate, *pgDrop, *pgTruncate = table, created, dropped, truncated
+	}()
+	*targetTable, *pgCreate, *pgDrop, *pgTruncate = "target", create, false, truncate
+	columns := []string{test.sink.quote("id"), test.sink.quote("name"), test.sink.quote("src")}
+	types := []string{test.sink.columnType("integer"), test.sink.columnType("text"), test.sink.columnType("text")}
+	if err := prepareTarget(test.db, test.sink, columns, types, keys); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//load loads rows of id, name and src in a transaction of a loader
+func (test *sinkTest) load(t *testing.T, keys []string, rows ...[3]string) {
+	columns := []string{test.sink.quote("id"), test.sink.quote("name"), test.sink.quote("src")}
+	l := newPgLoader(0, test.db, test.sink.statements("target", columns, keys, false), len(columns))
+	err := l.begin()
+	for _, row := range rows {
+		if err != nil {
+			break
+		}
+		for index, value := range row {
+			l.values[index].String, l.values[index].Valid = value, true
+		}
+		err = l.load(row[2])
+	}
+	if err == nil {
+		err = l.end()
+	}
+	if err == nil {
+		err = l.commit()
+	}
+	if err != nil {
+		l.rollback()
+		t.Fatal(err)
+	}
+}
+
+func (test *sinkTest) rows(t *testing.T) (result []string) {
+	rows, err := test.db.Query(`select id, name from target order by id, name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, name string
+		if err = rows.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, id+":"+name)
+	}
+	return
+}
+
+func TestSqliteSinkLoad(t *testing.T) {
+	test := newSinkTest(t)
+	defer test.close()
+
+	test.prepare(t, true, false, nil)
+	test.load(t, nil, [3]string{"1", "a", "a.txt"}, [3]string{"1", "b", "a.txt"}, [3]string{"2", "c", "b.txt"})
+	if rows := strings.Join(test.rows(t), ","); rows != "1:a,1:b,2:c" {
+		t.Fatalf("rows %v inserted", rows)
+	}
+
+	test.prepare(t, false, true, nil)
+	if rows := test.rows(t); len(rows) != 0 {
+		t.Fatalf("rows %v left truncated", rows)
+	}
+}
+
+func TestUpsertClause(t *testing.T) {
+	columns := []string{"id", "part", `"Name"`}
+	tests := []struct {
+		keys   []string
+		clause string
+	}{
+		{nil, ""},
+		{[]string{"id"}, ` on conflict(id) do update set part = excluded.part, "Name" = excluded."Name"`},
+		{[]string{"id", "part"}, ` on conflict(id,part) do update set "Name" = excluded."Name"`},
+		{columns, ` on conflict(id,part,"Name") do nothing`},
+	}
+	for _, test := range tests {
+		if clause := upsertClause(columns, test.keys); clause != test.clause {
+			t.Errorf("keys %v make %q", test.keys, clause)
+		}
+	}
+	if statement := insertStatement(sqliteSink{}, "target", columns, []string{"id"}); statement !=
+		`insert into target(id,part,"Name") values(?,?,?) on conflict(id) do update set part = excluded.part, "Name" = excluded."Name"` {
+		t.Errorf("insert %v", statement)
+	}
+}
+
+func TestSqliteSinkUpsert(t *testing.T) {
+	test := newSinkTest(t)
+	defer test.close()
+
+	keys := []string{test.sink.quote("id")}
+	test.prepare(t, true, false, keys)
+	var definition string
+	err := test.db.QueryRow(`select sql from sqlite_master where name = 'target'`).Scan(&definition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(definition, `primary key("id")`) {
+		t.Fatalf("table created as %v", definition)
+	}
+
+	test.load(t, keys, [3]string{"1", "a", "a.txt"}, [3]string{"2", "b", "a.txt"}, [3]string{"1", "c", "a.txt"})
+	test.load(t, keys, [3]string{"2", "d", "b.txt"})
+	if rows := strings.Join(test.rows(t), ","); rows != "1:c,2:d" {
+		t.Fatalf("rows %v upserted", rows)
+	}
+}
+
+func TestSqliteSinkLoads(t *testing.T) {
+	test := newSinkTest(t)
+	defer test.close()
+
+	test.prepare(t, true, false, nil)
+	loads, err := newPgLoads(test.db, test.sink, "geq_loads", "target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.load(t, nil, [3]string{"1", "a", "a.txt"}, [3]string{"2", "b", "b.txt"})
+	tx, err := test.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loads.record(tx, test.table, test.files[0], 1, pgLoading)
+	if err == nil {
+		err = loads.record(tx, test.table, test.files[0], 2, pgLoaded)
+	}
+	if err == nil {
+		err = loads.record(tx, test.table, test.files[1], 1, pgLoading)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rowCount int64
+	var checksum string
+	err = test.db.QueryRow(`select row_count, checksum from geq_loads where target_table = ? and file = ?`, "target", "a.txt").
+		Scan(&rowCount, &checksum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := fileChecksum(test.files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rowCount != 3 || checksum != expected {
+		t.Fatalf("a.txt recorded with %v row(s) of checksum %v", rowCount, checksum)
+	}
+
+	//a.txt is loaded and skipped, rows of b.txt loaded partially are deleted by the file column
+	cp, err := newCheckpoint("u.target", test.table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loads.skipLoaded(test.db, test.table, cp, test.sink.quote("src"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines, completed := cp.CompletedFiles[test.files[0]]; !completed || lines != 3 {
+		t.Fatalf("a.txt completed %v with %v line(s)", completed, lines)
+	}
+	if _, completed := cp.CompletedFiles[test.files[1]]; completed {
+		t.Fatalf("b.txt loaded partially is completed")
+	}
+	if rows := strings.Join(test.rows(t), ","); rows != "1:a" {
+		t.Fatalf("rows %v left", rows)
+	}
+	var records int
+	if err = test.db.QueryRow(`select count(*) from geq_loads`).Scan(&records); err != nil || records != 1 {
+		t.Fatalf("%v load record(s) left: %v", records, err)
+	}
+
+	//a.txt changed since it was loaded is loaded again
+	if err = ioutil.WriteFile(test.files[0], []byte("changed\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	loads, err = newPgLoads(test.db, test.sink, "geq_loads", "target")
+	if err == nil {
+		cp, err = newCheckpoint("u.target", test.table)
+	}
+	if err == nil {
+		err = loads.skipLoaded(test.db, test.table, cp, test.sink.quote("src"), false)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, completed := cp.CompletedFiles[test.files[0]]; completed {
+		t.Fatalf("changed a.txt is completed")
+	}
+	if rows := test.rows(t); len(rows) != 0 {
+		t.Fatalf("rows %v of changed a.txt left", rows)
+	}
+}
+
+func TestPostgresSinkStatements(t *testing.T) {
+	sink := postgresSink{}
+	columns := []string{"id", `"Name"`}
+	statements := sink.statements("target", columns, nil, false)
+	if !statements.copying || statements.setup != "" || statements.apply != "" ||
+		statements.dml != `copy target(id,"Name") from stdin` {
+		t.Errorf("plain COPY %+v", statements)
+	}
+
+	statements = sink.statements("target", columns, []string{"id"}, false)
+	if !statements.copying ||
+		statements.setup != "create temporary table geq_stage (like target including defaults) on commit drop" ||
+		statements.dml != `copy geq_stage(id,"Name") from stdin` ||
+		statements.apply != `insert into target(id,"Name") select distinct on(id) id,"Name" from geq_stage order by id, ctid desc`+
+			` on conflict(id) do update set "Name" = excluded."Name"` {
+		t.Errorf("staged COPY %+v", statements)
+	}
+
+	statements = sink.statements("target", columns, []string{"id", `"Name"`}, false)
+	if !strings.HasSuffix(statements.apply, ` on conflict(id,"Name") do nothing`) {
+		t.Errorf("staged COPY of keys only %+v", statements)
+	}
+
+	statements = sink.statements("target", columns, []string{"id"}, true)
+	if statements.copying || statements.setup != "" || statements.apply != "" ||
+		statements.dml != `insert into target(id,"Name") values($1,$2) on conflict(id) do update set "Name" = excluded."Name"` {
+		t.Errorf("insert %+v", statements)
+	}
+}
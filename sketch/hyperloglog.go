@@ -0,0 +1,108 @@
+//Package sketch holds bounded-memory summaries of value streams
+package sketch
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+//HyperLogLogType estimates a number of distinct values in 2^precision bytes.
+//The standard error is about 1.04/sqrt(2^precision), 0.8% for the default precision
+type HyperLogLogType struct {
+	Precision uint8  `json:"precision"`
+	Registers []byte `json:"registers"`
+}
+
+//DefaultPrecision takes 16K per sketch
+const DefaultPrecision = 14
+
+//NewHyperLogLog creates a sketch of a precision within 4..18
+func NewHyperLogLog(precision uint8) (*HyperLogLogType, error) {
+	if precision < 4 || precision > 18 {
+		return nil, fmt.Errorf("hyperloglog precision %v is out of range 4..18", precision)
+	}
+	return &HyperLogLogType{
+		Precision: precision,
+		Registers: make([]byte, 1<<precision),
+	}, nil
+}
+
+//Hash64 is a 64-bit hash of a value with well mixed bits
+func Hash64(value []byte) uint64 {
+	return Hash64Seed(0, value)
+}
+
+//Hash64Seed is Hash64 of a value varied by a seed
+func Hash64Seed(seed uint64, value []byte) uint64 {
+	//fnv-1a is weak in high bits, they are mixed with the murmur3 finalizer
+	x := uint64(14695981039346656037)
+	//bytes of a seed precede the value, as xor-ing the seed would merely alter the first byte of the value
+	for shift := uint(0); seed != 0 && shift < 64; shift += 8 {
+		x ^= seed >> shift & 0xff
+		x *= 1099511628211
+	}
+	for _, b := range value {
+		x ^= uint64(b)
+		x *= 1099511628211
+	}
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+//Add adds a value to the sketch
+func (h *HyperLogLogType) Add(value []byte) {
+	h.AddHash(Hash64(value))
+}
+
+//AddHash adds a value by its Hash64
+func (h *HyperLogLogType) AddHash(x uint64) {
+	index := x >> (64 - h.Precision)
+	rank := byte(bits.LeadingZeros64(x<<h.Precision|1<<(h.Precision-1))) + 1
+	if rank > h.Registers[index] {
+		h.Registers[index] = rank
+	}
+}
+
+//Merge adds values of another sketch of the same precision
+func (h *HyperLogLogType) Merge(other *HyperLogLogType) error {
+	if h.Precision != other.Precision {
+		return fmt.Errorf("hyperloglog precisions differ: %v and %v", h.Precision, other.Precision)
+	}
+	for index, rank := range other.Registers {
+		if rank > h.Registers[index] {
+			h.Registers[index] = rank
+		}
+	}
+	return nil
+}
+
+//Reset empties the sketch
+func (h *HyperLogLogType) Reset() {
+	for index := range h.Registers {
+		h.Registers[index] = 0
+	}
+}
+
+//Count estimates a number of distinct values added
+func (h *HyperLogLogType) Count() uint64 {
+	m := float64(len(h.Registers))
+	sum := 0.0
+	zeros := 0
+	for _, rank := range h.Registers {
+		sum += 1 / float64(uint64(1)<<rank)
+		if rank == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	//linear counting is more accurate for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
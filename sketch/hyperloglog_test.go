@@ -0,0 +1,80 @@
+package sketch
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHyperLogLogCount(t *testing.T) {
+	for _, distinct := range []int{0, 1, 100, 5000, 200000} {
+		h, err := NewHyperLogLog(DefaultPrecision)
+		if err != nil {
+			t.Fatal(err)
+		}
+		//repeated values are not counted again
+		for round := 0; round < 2; round++ {
+			for value := 0; value < distinct; value++ {
+				h.Add([]byte(fmt.Sprintf("value %v", value)))
+			}
+		}
+		count := h.Count()
+		//5 standard errors of 0.8%
+		if diff := float64(count) - float64(distinct); diff > 0.04*float64(distinct)+0.5 || -diff > 0.04*float64(distinct)+0.5 {
+			t.Errorf("%v distinct value(s) counted as %v", distinct, count)
+		}
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	whole, _ := NewHyperLogLog(10)
+	first, _ := NewHyperLogLog(10)
+	second, _ := NewHyperLogLog(10)
+	for value := 0; value < 3000; value++ {
+		data := []byte(fmt.Sprint(value))
+		whole.Add(data)
+		//halves overlap
+		if value < 2000 {
+			first.Add(data)
+		}
+		if value >= 1000 {
+			second.Add(data)
+		}
+	}
+	if err := first.Merge(second); err != nil {
+		t.Fatal(err)
+	}
+	if first.Count() != whole.Count() {
+		t.Errorf("merged sketch counts %v, the whole one %v", first.Count(), whole.Count())
+	}
+
+	other, _ := NewHyperLogLog(11)
+	if err := first.Merge(other); err == nil {
+		t.Errorf("sketches of different precisions are merged")
+	}
+	first.Reset()
+	if first.Count() != 0 {
+		t.Errorf("reset sketch counts %v", first.Count())
+	}
+}
+
+func TestNewHyperLogLog(t *testing.T) {
+	for _, precision := range []uint8{0, 3, 19} {
+		if _, err := NewHyperLogLog(precision); err == nil {
+			t.Errorf("sketch of precision %v is created", precision)
+		}
+	}
+	h, err := NewHyperLogLog(4)
+	if err != nil || len(h.Registers) != 16 {
+		t.Errorf("sketch of precision 4: %v", err)
+	}
+}
+
+func TestHash64Seed(t *testing.T) {
+	value := []byte("value")
+	if Hash64Seed(0, value) != Hash64(value) {
+		t.Errorf("zero seed changes the hash")
+	}
+	if Hash64Seed(1, value) == Hash64(value) || Hash64Seed(1, value) == Hash64Seed(2, value) {
+		t.Errorf("seeds do not change the hash")
+	}
+}
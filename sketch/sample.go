@@ -0,0 +1,91 @@
+package sketch
+
+import (
+	"container/heap"
+	"sort"
+)
+
+//SampleItemType is an item of a sample, Source and Line tell where it has come from
+type SampleItemType struct {
+	Key    uint64 `json:"key"`
+	Data   []byte `json:"data"`
+	Source string `json:"source,omitempty"`
+	Line   uint64 `json:"line,omitempty"`
+}
+
+//SampleType keeps Size items of the smallest keys (bottom-k sampling).
+//Keys being seeded hashes of items make it a uniform sample, which is reproducible with the seed
+//and does not depend on the order items come in. Items of equal keys are kept once,
+//so hashes of values make a sample of distinct values
+type SampleType struct {
+	Size int `json:"size"`
+	//items is a max-heap by key
+	items sampleHeapType
+	keys  map[uint64]bool
+}
+
+type sampleHeapType []*SampleItemType
+
+func (h sampleHeapType) Len() int            { return len(h) }
+func (h sampleHeapType) Less(i, j int) bool  { return h[i].Key > h[j].Key }
+func (h sampleHeapType) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *sampleHeapType) Push(x interface{}) { *h = append(*h, x.(*SampleItemType)) }
+func (h *sampleHeapType) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+//NewSample creates a sample of size items
+func NewSample(size int) *SampleType {
+	return &SampleType{
+		Size: size,
+		keys: make(map[uint64]bool, size),
+	}
+}
+
+//Add offers an item to the sample, Data is copied when the item is kept
+func (s *SampleType) Add(item SampleItemType) bool {
+	if s.keys[item.Key] {
+		return false
+	}
+	if len(s.items) >= s.Size {
+		if s.Size == 0 || item.Key >= s.items[0].Key {
+			return false
+		}
+		delete(s.keys, heap.Pop(&s.items).(*SampleItemType).Key)
+	}
+	item.Data = append([]byte(nil), item.Data...)
+	heap.Push(&s.items, &item)
+	s.keys[item.Key] = true
+	return true
+}
+
+//Merge adds items of another sample
+func (s *SampleType) Merge(other *SampleType) {
+	for _, item := range other.items {
+		s.Add(*item)
+	}
+}
+
+//Items returns the sample in ascending order of keys
+func (s *SampleType) Items() (result []SampleItemType) {
+	result = make([]SampleItemType, 0, len(s.items))
+	for _, item := range s.items {
+		result = append(result, *item)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return
+}
+
+//Restore refills the sample with items returned by Items
+func (s *SampleType) Restore(items []SampleItemType) {
+	s.items = s.items[:0]
+	s.keys = make(map[uint64]bool, s.Size)
+	for _, item := range items {
+		s.Add(item)
+	}
+}
@@ -0,0 +1,67 @@
+package sketch
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func sampleKeys(items []SampleItemType) (result []uint64) {
+	for _, item := range items {
+		result = append(result, item.Key)
+	}
+	return
+}
+
+func TestSampleBottomK(t *testing.T) {
+	sample := NewSample(3)
+	for _, key := range []uint64{50, 10, 40, 10, 30, 20, 60} {
+		sample.Add(SampleItemType{Key: key, Data: []byte(fmt.Sprint(key))})
+	}
+	items := sample.Items()
+	if !reflect.DeepEqual(sampleKeys(items), []uint64{10, 20, 30}) || string(items[0].Data) != "10" {
+		t.Errorf("sample %v", items)
+	}
+	if sample.Add(SampleItemType{Key: 20}) || sample.Add(SampleItemType{Key: 35}) || !sample.Add(SampleItemType{Key: 5}) {
+		t.Errorf("items of taken or greater keys are added")
+	}
+
+	empty := NewSample(0)
+	if empty.Add(SampleItemType{Key: 1}) || len(empty.Items()) != 0 {
+		t.Errorf("item added to a sample of no size")
+	}
+}
+
+func TestSampleCopiesData(t *testing.T) {
+	sample := NewSample(2)
+	data := []byte("abc")
+	sample.Add(SampleItemType{Key: 1, Data: data})
+	copy(data, "xyz")
+	if items := sample.Items(); string(items[0].Data) != "abc" {
+		t.Errorf("sampled data %q is not copied", items[0].Data)
+	}
+}
+
+func TestSampleMerge(t *testing.T) {
+	whole := NewSample(5)
+	parts := []*SampleType{NewSample(5), NewSample(5), NewSample(5)}
+	//keys of a seeded hash make the sample independent of the order and of the way items are split
+	for n := 0; n < 100; n++ {
+		item := SampleItemType{Key: Hash64Seed(42, []byte(fmt.Sprint(n))), Data: []byte(fmt.Sprint(n))}
+		whole.Add(item)
+		parts[n%3].Add(item)
+	}
+	merged := NewSample(5)
+	for index := len(parts) - 1; index >= 0; index-- {
+		merged.Merge(parts[index])
+	}
+	if !reflect.DeepEqual(merged.Items(), whole.Items()) {
+		t.Errorf("merged sample %v, whole one %v", merged.Items(), whole.Items())
+	}
+
+	restored := NewSample(5)
+	restored.Restore(whole.Items())
+	if !reflect.DeepEqual(restored.Items(), whole.Items()) {
+		t.Errorf("restored sample %v", restored.Items())
+	}
+}
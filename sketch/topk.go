@@ -0,0 +1,126 @@
+package sketch
+
+import (
+	"container/heap"
+	"sort"
+)
+
+//TopItemType is a value with the number of its occurrences.
+//Count may overestimate the real number by up to Error
+type TopItemType struct {
+	Value string `json:"value"`
+	Count uint64 `json:"count"`
+	Error uint64 `json:"error,omitempty"`
+}
+
+//TopKType finds the most frequent values keeping Capacity counters (the Space-Saving algorithm).
+//Counts are exact until the number of distinct values exceeds Capacity.
+//A value occurring more than total/Capacity times is guaranteed to be kept
+type TopKType struct {
+	Capacity int `json:"capacity"`
+	items    map[string]*topCounterType
+	//counters is a min-heap by count
+	counters topHeapType
+}
+
+type topCounterType struct {
+	TopItemType
+	index int
+}
+
+type topHeapType []*topCounterType
+
+func (h topHeapType) Len() int           { return len(h) }
+func (h topHeapType) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h topHeapType) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *topHeapType) Push(x interface{}) {
+	counter := x.(*topCounterType)
+	counter.index = len(*h)
+	*h = append(*h, counter)
+}
+func (h *topHeapType) Pop() interface{} {
+	old := *h
+	counter := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return counter
+}
+
+//NewTopK creates a summary of capacity counters
+func NewTopK(capacity int) *TopKType {
+	return &TopKType{
+		Capacity: capacity,
+		items:    make(map[string]*topCounterType, capacity),
+	}
+}
+
+//Add counts an occurrence of a value
+func (t *TopKType) Add(value []byte) {
+	t.AddCount(value, 1, 0)
+}
+
+//AddCount counts count occurrences of a value
+func (t *TopKType) AddCount(value []byte, count, errorCount uint64) {
+	if counter, found := t.items[string(value)]; found {
+		counter.Count += count
+		counter.Error += errorCount
+		heap.Fix(&t.counters, counter.index)
+		return
+	}
+	if len(t.counters) < t.Capacity {
+		counter := &topCounterType{TopItemType: TopItemType{Value: string(value), Count: count, Error: errorCount}}
+		t.items[counter.Value] = counter
+		heap.Push(&t.counters, counter)
+		return
+	}
+	//the least frequent value is replaced, the new one inherits its count as an error
+	counter := t.counters[0]
+	delete(t.items, counter.Value)
+	counter.Value = string(value)
+	counter.Error = counter.Count + errorCount
+	counter.Count += count
+	t.items[counter.Value] = counter
+	heap.Fix(&t.counters, 0)
+}
+
+//Merge adds counts of another summary
+func (t *TopKType) Merge(other *TopKType) {
+	for _, counter := range other.counters {
+		t.AddCount([]byte(counter.Value), counter.Count, counter.Error)
+	}
+}
+
+//Reset empties the summary
+func (t *TopKType) Reset() {
+	t.items = make(map[string]*topCounterType, t.Capacity)
+	t.counters = t.counters[:0]
+}
+
+//Top returns up to n most frequent values in descending order of counts, n <= 0 returns all of them
+func (t *TopKType) Top(n int) (result []TopItemType) {
+	result = make([]TopItemType, 0, len(t.counters))
+	for _, counter := range t.counters {
+		result = append(result, counter.TopItemType)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Value < result[j].Value
+	})
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return
+}
+
+//Restore refills the summary with items returned by Top
+func (t *TopKType) Restore(items []TopItemType) {
+	t.Reset()
+	for _, item := range items {
+		t.AddCount([]byte(item.Value), item.Count, item.Error)
+	}
+}
@@ -0,0 +1,75 @@
+package sketch
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestTopKExact(t *testing.T) {
+	top := NewTopK(10)
+	for _, value := range []string{"a", "b", "a", "c", "b", "a", "d"} {
+		top.Add([]byte(value))
+	}
+	expected := []TopItemType{{Value: "a", Count: 3}, {Value: "b", Count: 2}, {Value: "c", Count: 1}, {Value: "d", Count: 1}}
+	if items := top.Top(0); !reflect.DeepEqual(items, expected) {
+		t.Errorf("top %v", items)
+	}
+	if items := top.Top(2); !reflect.DeepEqual(items, expected[:2]) {
+		t.Errorf("top 2 %v", items)
+	}
+
+	restored := NewTopK(10)
+	restored.Restore(top.Top(0))
+	if !reflect.DeepEqual(restored.Top(0), expected) {
+		t.Errorf("restored top %v", restored.Top(0))
+	}
+	top.Reset()
+	if len(top.Top(0)) != 0 {
+		t.Errorf("reset top %v", top.Top(0))
+	}
+}
+
+func TestTopKOverflow(t *testing.T) {
+	//frequent values interleaved with many rare ones, values of more than 1750/10 occurrences are kept
+	top := NewTopK(10)
+	for n := 0; n < 1000; n++ {
+		top.Add([]byte(fmt.Sprintf("rare %v", n)))
+		if n%2 == 0 {
+			top.Add([]byte("half"))
+		}
+		if n%4 == 0 {
+			top.Add([]byte("quarter"))
+		}
+	}
+	items := top.Top(2)
+	if len(items) != 2 || items[0].Value != "half" || items[1].Value != "quarter" {
+		t.Fatalf("top %v", items)
+	}
+	for _, item := range items {
+		occurrences := map[string]uint64{"half": 500, "quarter": 250}[item.Value]
+		if item.Count < occurrences || item.Count-item.Error > occurrences {
+			t.Errorf("%v counted %v with error %v, %v occurrences", item.Value, item.Count, item.Error, occurrences)
+		}
+	}
+}
+
+func TestTopKMerge(t *testing.T) {
+	first, second := NewTopK(3), NewTopK(3)
+	for _, value := range []string{"a", "a", "b", "c"} {
+		first.Add([]byte(value))
+	}
+	for _, value := range []string{"b", "b", "a", "d"} {
+		second.Add([]byte(value))
+	}
+	first.Merge(second)
+	items := first.Top(0)
+	counts := make(map[string]uint64)
+	for _, item := range items {
+		counts[item.Value] = item.Count
+	}
+	//d replaces the least frequent c inheriting its count as an error
+	if len(items) != 3 || counts["a"] != 3 || counts["b"] != 3 || counts["d"] != 2 || items[2].Error != 1 {
+		t.Errorf("merged top %v", items)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//maxOpenValueOutputs limits files kept open by valueOutputsType
+const maxOpenValueOutputs = 256
+
+//valueSetType is a set of values loaded from a file along with counts of rows they have been found in
+type valueSetType struct {
+	ignoreCase bool
+	//values keep the file order and spelling for reporting
+	values []string
+	//hits are counted by values folded by key
+	hits map[string]*uint64
+	//spellings are values as the file has them by their keys
+	spellings map[string]string
+}
+
+//loadValueSet reads a newline-delimited list of values, like the one Extract writes. Empty lines are skipped
+func loadValueSet(pathToFile string, ignoreCase bool) (result *valueSetType, err error) {
+	file, err := os.Open(pathToFile)
+	if err != nil {
+		err = errors.Wrapf(err, "could not open value file %v", pathToFile)
+		return
+	}
+	defer file.Close()
+
+	result = &valueSetType{
+		ignoreCase: ignoreCase,
+		hits:       make(map[string]*uint64),
+		spellings:  make(map[string]string),
+	}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			err = errors.Wrapf(err, "could not read value file %v", pathToFile)
+			return nil, err
+		}
+		value := strings.TrimRight(line, "\r\n")
+		key := string(result.key([]byte(value)))
+		if _, found := result.hits[key]; value != "" && !found {
+			result.values = append(result.values, value)
+			result.hits[key] = new(uint64)
+			result.spellings[key] = value
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(result.values) == 0 {
+		err = errors.Errorf("value file %v is empty", pathToFile)
+		return nil, err
+	}
+	return result, nil
+}
+
+//key returns a cell folded the way values of the set are compared
+func (set *valueSetType) key(cell []byte) []byte {
+	if set.ignoreCase {
+		return bytes.ToLower(cell)
+	}
+	return cell
+}
+
+//value returns the value of the set a cell matches as the file spells it
+func (set *valueSetType) value(cell []byte) string {
+	return set.spellings[string(set.key(cell))]
+}
+
+//match tells if a cell is in the set counting the hit
+func (set *valueSetType) match(cell []byte) bool {
+	hits, found := set.hits[string(set.key(cell))]
+	if found {
+		*hits++
+	}
+	return found
+}
+
+//counts returns non-zero hit counts by value
+func (set *valueSetType) counts() map[string]uint64 {
+	result := make(map[string]uint64)
+	for value, hits := range set.hits {
+		if *hits > 0 {
+			result[value] = *hits
+		}
+	}
+	return result
+}
+
+//restoreCounts sets hit counts saved by counts
+func (set *valueSetType) restoreCounts(counts map[string]uint64) {
+	for value, count := range counts {
+		if hits, found := set.hits[value]; found {
+			*hits = count
+		}
+	}
+}
+
+//writeUnseen writes values not found in any row, one per line, and returns their number
+func (set *valueSetType) writeUnseen(pathToFile string) (count int, err error) {
+	file, err := os.Create(pathToFile)
+	if err != nil {
+		return
+	}
+	writer := bufio.NewWriter(file)
+	for _, value := range set.values {
+		if *set.hits[string(set.key([]byte(value)))] == 0 {
+			count++
+			writer.WriteString(value)
+			writer.WriteByte('\n')
+		}
+	}
+	err = writer.Flush()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "could not write unseen values to %v", pathToFile)
+	}
+	return
+}
+
+type valueOutputFileType struct {
+	value string
+	file  *os.File
+}
+
+//valueOutputsType writes rows to a file per value in a directory,
+//only the maxOpenValueOutputs recently used files are kept open
+type valueOutputsType struct {
+	dir string
+	//sizes are numbers of bytes written to files by value
+	sizes  map[string]int64
+	opened map[string]*list.Element
+	recent *list.List
+}
+
+//newValueOutputs prepares a file per value. Files of values having sizes are cut to them,
+//as the sizes are saved with a checkpoint being resumed, the other files are removed
+func newValueOutputs(dir string, values []string, sizes map[string]int64) (outputs *valueOutputsType, err error) {
+	err = os.MkdirAll(dir, 0777)
+	if err != nil {
+		return
+	}
+	outputs = &valueOutputsType{
+		dir:    dir,
+		sizes:  make(map[string]int64),
+		opened: make(map[string]*list.Element),
+		recent: list.New(),
+	}
+	for _, value := range values {
+		pathToFile := outputs.fileName(value)
+		if size, found := sizes[value]; found {
+			outputs.sizes[value] = size
+			err = os.Truncate(pathToFile, size)
+		} else if err = os.Remove(pathToFile); os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			err = errors.Wrapf(err, "could not prepare output %v", pathToFile)
+			return nil, err
+		}
+	}
+	return
+}
+
+//fileName makes a file name of a value, a hash distinguishes values having chars unsafe for file names
+func (outputs *valueOutputsType) fileName(value string) string {
+	safe := []byte(value)
+	for index, c := range safe {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			safe[index] = '_'
+		}
+	}
+	name := string(safe)
+	if name != value || strings.HasPrefix(name, ".") {
+		name = fmt.Sprintf("%v.%v", name, shortHash(value))
+	}
+	return path.Join(outputs.dir, name)
+}
+
+func (outputs *valueOutputsType) write(value string, data []byte) (err error) {
+	element, found := outputs.opened[value]
+	if found {
+		outputs.recent.MoveToFront(element)
+	} else {
+		pathToFile := outputs.fileName(value)
+		file, err := os.OpenFile(pathToFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			err = errors.Wrapf(err, "could not open output %v", pathToFile)
+			return err
+		}
+		element = outputs.recent.PushFront(&valueOutputFileType{value: value, file: file})
+		outputs.opened[value] = element
+		if outputs.recent.Len() > maxOpenValueOutputs {
+			err = outputs.closeFile(outputs.recent.Back())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	written, err := element.Value.(*valueOutputFileType).file.Write(data)
+	outputs.sizes[value] += int64(written)
+	return
+}
+
+func (outputs *valueOutputsType) closeFile(element *list.Element) error {
+	output := outputs.recent.Remove(element).(*valueOutputFileType)
+	delete(outputs.opened, output.value)
+	return output.file.Close()
+}
+
+//Close closes all open files
+func (outputs *valueOutputsType) Close() (err error) {
+	for outputs.recent.Len() > 0 {
+		if closeErr := outputs.closeFile(outputs.recent.Front()); err == nil {
+			err = closeErr
+		}
+	}
+	return
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeValueFile(t *testing.T, dir string, values ...string) string {
+	pathToFile := path.Join(dir, "values.txt")
+	if err := ioutil.WriteFile(pathToFile, []byte(strings.Join(values, "\n")), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return pathToFile
+}
+
+func TestValueSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "valueset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pathToFile := writeValueFile(t, dir, "ID-1", "", "id-2\r", "Id-3", "ID-1", "id-1", "ID-4")
+
+	for _, ignoreCase := range []bool{false, true} {
+		set, err := loadValueSet(pathToFile, ignoreCase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{"ID-1", "id-2", "Id-3", "id-1", "ID-4"}
+		if ignoreCase {
+			//the first spelling of a value is kept
+			expected = []string{"ID-1", "id-2", "Id-3", "ID-4"}
+		}
+		if !reflect.DeepEqual(set.values, expected) {
+			t.Fatalf("ignore case %v: values %q loaded", ignoreCase, set.values)
+		}
+
+		for _, cell := range []string{"id-1", "ID-2", "ID-2", "Id-3", "id-5"} {
+			set.match([]byte(cell))
+		}
+		counts := map[string]uint64{"id-1": 1, "Id-3": 1}
+		if ignoreCase {
+			counts = map[string]uint64{"id-1": 1, "id-2": 2, "id-3": 1}
+		}
+		if !reflect.DeepEqual(set.counts(), counts) {
+			t.Errorf("ignore case %v: counts %v", ignoreCase, set.counts())
+		}
+		if ignoreCase && (set.value([]byte("id-3")) != "Id-3" || set.value([]byte("ID-5")) != "") {
+			t.Errorf("cells spelled as %q, %q", set.value([]byte("id-3")), set.value([]byte("ID-5")))
+		}
+
+		//hits restored from a checkpoint are not counted again
+		restored, err := loadValueSet(pathToFile, ignoreCase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored.restoreCounts(set.counts())
+		restored.restoreCounts(map[string]uint64{"gone": 5})
+		if !reflect.DeepEqual(restored.counts(), set.counts()) {
+			t.Errorf("ignore case %v: counts %v restored", ignoreCase, restored.counts())
+		}
+
+		pathToUnseen := path.Join(dir, "unseen")
+		unseen, err := restored.writeUnseen(pathToUnseen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(pathToUnseen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedUnseen := "ID-1\nid-2\nID-4\n"
+		if ignoreCase {
+			expectedUnseen = "ID-4\n"
+		}
+		if string(data) != expectedUnseen || unseen != strings.Count(expectedUnseen, "\n") {
+			t.Errorf("ignore case %v: %v unseen value(s) %q written", ignoreCase, unseen, data)
+		}
+	}
+
+	if _, err = loadValueSet(writeValueFile(t, dir, "", "\r", ""), false); err == nil {
+		t.Errorf("empty value file is loaded")
+	}
+}
+
+func TestValueOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "valueset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//more values than files kept open
+	values := []string{"a", "B", "c/d", ".hidden"}
+	for n := 0; n < maxOpenValueOutputs; n++ {
+		values = append(values, fmt.Sprintf("v%v", n))
+	}
+	outputs, err := newValueOutputs(dir, values, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for round := 0; round < 2; round++ {
+		for _, value := range values {
+			if err = outputs.write(value, []byte(value+"\n")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if outputs.recent.Len() > maxOpenValueOutputs {
+		t.Errorf("%v file(s) open", outputs.recent.Len())
+	}
+	sizes := make(map[string]int64)
+	for value, size := range outputs.sizes {
+		sizes[value] = size
+	}
+	if err = outputs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, value := range values {
+		pathToFile := outputs.fileName(value)
+		names[pathToFile] = true
+		data, err := ioutil.ReadFile(pathToFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != value+"\n"+value+"\n" || sizes[value] != int64(len(data)) {
+			t.Errorf("output of %v has %q of size %v", value, data, sizes[value])
+		}
+	}
+	if len(names) != len(values) || path.Base(outputs.fileName("c/d")) == "c_d" || path.Base(outputs.fileName("a")) != "a" {
+		t.Errorf("file names %v", names)
+	}
+
+	//outputs resumed are cut to the sizes saved, the others are started over
+	outputs, err = newValueOutputs(dir, values, map[string]int64{"a": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = outputs.write("a", []byte("x\n")); err == nil {
+		err = outputs.write("B", []byte("y\n"))
+	}
+	if err == nil {
+		err = outputs.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(outputs.fileName("a")); string(data) != "a\nx\n" {
+		t.Errorf("resumed output of a has %q", data)
+	}
+	if data, _ := ioutil.ReadFile(outputs.fileName("B")); string(data) != "y\n" {
+		t.Errorf("restarted output of B has %q", data)
+	}
+	if _, err = os.Stat(outputs.fileName("c/d")); !os.IsNotExist(err) {
+		t.Errorf("output of c/d not written since restart is kept: %v", err)
+	}
+}